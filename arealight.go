@@ -0,0 +1,258 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// AreaLightSampling selects how points on an area light are picked.
+type AreaLightSampling int
+
+const (
+	// SampleArea picks points uniformly over the area of the light, which
+	// is cheap but noisy close to large lights.
+	SampleArea AreaLightSampling = iota
+	// SampleSolidAngle picks directions uniformly over the solid angle the
+	// light covers from the shaded point, which is less noisy close to the
+	// light.
+	SampleSolidAngle
+)
+
+// DefaultAreaLightSamples is the number of shadow rays per area light and
+// intersection of lights created by Space.AddGeometry.
+const DefaultAreaLightSamples = 16
+
+// minSolidAngle is the solid angle below which SampleSolidAngle falls back
+// to sampling by area, where spherical triangles lose precision.
+const minSolidAngle = 1e-4
+
+// AreaLight is a light given off by the emissive faces of a mesh. Faces
+// only emit from their front, as given by their winding.
+type AreaLight struct {
+	Triangles []LightTriangle
+	Samples   int
+	Sampling  AreaLightSampling
+
+	area float32
+	// cdf holds the sum of the areas of the triangles up to each one
+	cdf []float32
+}
+
+// LightTriangle is an emissive triangle of an area light in world space.
+type LightTriangle struct {
+	Vertices [3]vec3.T
+	Normal   vec3.T
+	Area     float32
+	Emission color.RGBA
+	Strength float32
+}
+
+// CreateAreaLight creates the light of the faces of o with emissive
+// materials, placed in world space by transform. ok is false if no face
+// emits light.
+func CreateAreaLight(o *Obj, transform mat4.T) (*AreaLight, bool) {
+	l := &AreaLight{Samples: DefaultAreaLightSamples, Sampling: SampleSolidAngle}
+	for _, f := range o.Faces {
+		material := o.faceMaterial(f)
+		if !material.IsEmissive() {
+			continue
+		}
+		for i := 1; i+1 < len(f.VertexIndices); i++ {
+			var t LightTriangle
+			for j, corner := range []int{0, i, i + 1} {
+				v := o.Vertices[f.VertexIndices[corner]]
+				t.Vertices[j] = transform.MulVec3(&v)
+			}
+			e1 := vec3.Sub(&t.Vertices[1], &t.Vertices[0])
+			e2 := vec3.Sub(&t.Vertices[2], &t.Vertices[0])
+			t.Normal = vec3.Cross(&e1, &e2)
+			t.Area = t.Normal.Length() / 2
+			if t.Area == 0 {
+				continue
+			}
+			t.Normal.Normalize()
+			t.Emission = material.Emission
+			t.Strength = material.EmissionStrength
+			l.Triangles = append(l.Triangles, t)
+			l.area += t.Area
+			l.cdf = append(l.cdf, l.area)
+		}
+	}
+	return l, len(l.Triangles) > 0
+}
+
+// CalculateColorContribution returns the light of the area light reflected
+// at the intersection. Every sample is lit like a point light by the
+// shading model of the material, and samples that another geometry of s
+// hides are left out.
+func (l *AreaLight) CalculateColorContribution(intersection RayFaceIntersection, s *Space) color.RGBA {
+	samples := maxInt(l.Samples, 1)
+	var sum vec3.T
+	for i := 0; i < samples; i++ {
+		// Stratify the first random number
+		u1 := (float32(i) + rand.Float32()) / float32(samples)
+		point, t, weight, ok := l.sample(intersection.IntersectionPoint, u1, rand.Float32(), rand.Float32())
+		if !ok {
+			continue
+		}
+		shadowRay := SpawnRayTo(intersection.IntersectionPoint, intersection.GeometricNormal, point)
+		if _, hidden := s.Intersect(shadowRay); hidden {
+			continue
+		}
+
+		// The sample acts as a point light with the emission as its colour.
+		// Dividing by pi makes a surface surrounded by light of strength 1
+		// as bright as under a white light of intensity 1.
+		light := CreateLight(point, t.Emission, t.Strength*weight/math.Pi/float32(samples), 1)
+		c := light.reflectedLight(intersection)
+		sum.Add(&c)
+	}
+	return color.RGBA{
+		R: uint8(clampColorComponent(sum[0])),
+		G: uint8(clampColorComponent(sum[1])),
+		B: uint8(clampColorComponent(sum[2])),
+		A: 255,
+	}
+}
+
+// sample picks a point on the light as seen from p. The weight is the
+// cosine at the light over the squared distance divided by the probability
+// density of the point, so that the light of the sample is its emission
+// times the weight.
+func (l *AreaLight) sample(p vec3.T, u1, u2, u3 float32) (vec3.T, LightTriangle, float32, bool) {
+	if l.Sampling == SampleSolidAngle {
+		if point, t, weight, ok := l.sampleSolidAngle(p, u1, u2, u3); ok {
+			return point, t, weight, true
+		}
+	}
+
+	t := l.Triangles[l.pick(u1*l.area)]
+	point := samplePointOnTriangle(t.Vertices, u2, u3)
+	toLight := vec3.Sub(&point, &p)
+	distanceSqr := toLight.LengthSqr()
+	toLight.Normalize()
+	cosLight := -vec3.Dot(&t.Normal, &toLight)
+	if cosLight <= 0 || distanceSqr == 0 {
+		return vec3.T{}, LightTriangle{}, 0, false
+	}
+	return point, t, cosLight / distanceSqr * l.area, true
+}
+
+// sampleSolidAngle picks a triangle by the solid angle it covers from p and
+// a direction uniformly within that, with Arvo's method for spherical
+// triangles. ok is false if the light covers too small a solid angle.
+func (l *AreaLight) sampleSolidAngle(p vec3.T, u1, u2, u3 float32) (vec3.T, LightTriangle, float32, bool) {
+	angles := make([]float32, len(l.Triangles))
+	var total float32
+	for i, t := range l.Triangles {
+		toTriangle := vec3.Sub(&t.Vertices[0], &p)
+		// Triangles seen from behind give no light
+		if vec3.Dot(&toTriangle, &t.Normal) < 0 {
+			angles[i] = sphericalTriangleArea(directionsTo(p, t.Vertices))
+		}
+		total += angles[i]
+		angles[i] = total
+	}
+	if total < minSolidAngle {
+		return vec3.T{}, LightTriangle{}, 0, false
+	}
+
+	index := sort.Search(len(angles), func(i int) bool { return angles[i] > u1*total })
+	index = minInt(index, len(angles)-1)
+	t := l.Triangles[index]
+	a, b, c := directionsTo(p, t.Vertices)
+	direction := sampleSphericalTriangle(a, b, c, u2, u3)
+
+	// Find the point on the plane of the triangle in that direction
+	toPlane := vec3.Sub(&t.Vertices[0], &p)
+	cos := vec3.Dot(&t.Normal, &direction)
+	if cos >= 0 {
+		return vec3.T{}, LightTriangle{}, 0, false
+	}
+	distance := vec3.Dot(&t.Normal, &toPlane) / cos
+	offset := direction.Scaled(distance)
+	return vec3.Add(&p, &offset), t, total, true
+}
+
+// pick returns the index of the triangle that area a falls on.
+func (l *AreaLight) pick(a float32) int {
+	return minInt(sort.Search(len(l.cdf), func(i int) bool { return l.cdf[i] > a }), len(l.cdf)-1)
+}
+
+// samplePointOnTriangle maps two uniform random numbers uniformly onto a
+// triangle.
+func samplePointOnTriangle(v [3]vec3.T, u1, u2 float32) vec3.T {
+	r := float32(math.Sqrt(float64(u1)))
+	b0, b1 := 1-r, u2*r
+	return weightedSum(v[:], []int{0, 1, 2}, []float32{b0, b1, 1 - b0 - b1})
+}
+
+func directionsTo(p vec3.T, v [3]vec3.T) (vec3.T, vec3.T, vec3.T) {
+	var d [3]vec3.T
+	for i := range v {
+		d[i] = vec3.Sub(&v[i], &p)
+		d[i].Normalize()
+	}
+	return d[0], d[1], d[2]
+}
+
+// sphericalTriangleArea returns the solid angle of the triangle with the
+// unit vectors a, b and c as corners, by the formula of Van Oosterom and
+// Strackee.
+func sphericalTriangleArea(a, b, c vec3.T) float32 {
+	cross := vec3.Cross(&b, &c)
+	numerator := math.Abs(float64(vec3.Dot(&a, &cross)))
+	denominator := 1 + float64(vec3.Dot(&a, &b)+vec3.Dot(&b, &c)+vec3.Dot(&c, &a))
+	return float32(2 * math.Atan2(numerator, denominator))
+}
+
+// sampleSphericalTriangle maps two uniform random numbers uniformly onto the
+// spherical triangle with the unit vectors a, b and c as corners, see
+// "Stratified Sampling of Spherical Triangles" by James Arvo.
+func sampleSphericalTriangle(a, b, c vec3.T, u1, u2 float32) vec3.T {
+	alpha := cornerAngle(a, b, c)
+	beta := cornerAngle(b, c, a)
+	gamma := cornerAngle(c, a, b)
+	area := alpha + beta + gamma - math.Pi
+
+	// Pick the sub-triangle a, b, c' with the sampled area
+	areaHat := float64(u1) * area
+	s, t := math.Sin(areaHat-alpha), math.Cos(areaHat-alpha)
+	u := t - math.Cos(alpha)
+	v := s + math.Sin(alpha)*float64(vec3.Dot(&a, &b))
+	q := ((v*t-u*s)*math.Cos(alpha) - v) / ((v*s + u*t) * math.Sin(alpha))
+	q = math.Max(-1, math.Min(1, q))
+	cHat := a.Scaled(float32(q))
+	perpendicular := orthogonalTo(c, a)
+	perpendicular.Scale(float32(math.Sqrt(1 - q*q)))
+	cHat.Add(&perpendicular)
+
+	// Pick the point on the arc from b to c'
+	z := 1 - float64(u2)*(1-float64(vec3.Dot(&cHat, &b)))
+	z = math.Max(-1, math.Min(1, z))
+	direction := b.Scaled(float32(z))
+	perpendicular = orthogonalTo(cHat, b)
+	perpendicular.Scale(float32(math.Sqrt(1 - z*z)))
+	direction.Add(&perpendicular)
+	return direction.Normalized()
+}
+
+// cornerAngle returns the angle of the spherical triangle at corner a.
+func cornerAngle(a, b, c vec3.T) float64 {
+	tb, tc := orthogonalTo(b, a), orthogonalTo(c, a)
+	cos := vec3.Dot(&tb, &tc)
+	return math.Acos(math.Max(-1, math.Min(1, float64(cos))))
+}
+
+// orthogonalTo returns the unit vector of the part of v perpendicular to the
+// unit vector n.
+func orthogonalTo(v, n vec3.T) vec3.T {
+	along := n.Scaled(vec3.Dot(&v, &n))
+	v.Sub(&along)
+	return v.Normalized()
+}
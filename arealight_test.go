@@ -0,0 +1,122 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// lightSquare is a square from -size to size in X and Y at height z that
+// emits white light downwards.
+func lightSquare(size, z float32) *Obj {
+	return &Obj{
+		Vertices: []vec3.T{{-size, -size, z}, {-size, size, z}, {size, size, z}, {size, -size, z}},
+		Faces:    []Face{{VertexIndices: []int{0, 1, 2, 3}}},
+		Material: Material{Emission: color.RGBA{255, 255, 255, 255}, EmissionStrength: 1},
+	}
+}
+
+func TestAreaLightSampling(t *testing.T) {
+	l, ok := CreateAreaLight(lightSquare(1, 1), mat4.Ident)
+	if !ok {
+		t.Fatal("no area light for an emissive square")
+	}
+	if len(l.Triangles) != 2 || l.area != 4 {
+		t.Fatalf("%d triangles with area %v, want 2 with area 4", len(l.Triangles), l.area)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for _, p := range []vec3.T{{0, 0, 0}, {0.5, -0.3, 0.2}, {3, 0, -1}} {
+		a, b, c := directionsTo(p, l.Triangles[0].Vertices)
+		d, e, f := directionsTo(p, l.Triangles[1].Vertices)
+		solidAngle := sphericalTriangleArea(a, b, c) + sphericalTriangleArea(d, e, f)
+
+		// Both ways of sampling estimate the solid angle of the light
+		for _, sampling := range []AreaLightSampling{SampleArea, SampleSolidAngle} {
+			l.Sampling = sampling
+			var sum float64
+			const n = 100000
+			for i := 0; i < n; i++ {
+				point, _, weight, ok := l.sample(p, random.Float32(), random.Float32(), random.Float32())
+				if !ok {
+					continue
+				}
+				if math.Abs(float64(point[2]-1)) > 1e-4 || math.Abs(float64(point[0])) > 1+1e-4 || math.Abs(float64(point[1])) > 1+1e-4 {
+					t.Fatalf("sampling %d from %v: point %v is not on the light", sampling, p, point)
+				}
+				sum += float64(weight)
+			}
+			if estimate := sum / n; math.Abs(estimate-float64(solidAngle)) > 0.02*float64(solidAngle) {
+				t.Errorf("sampling %d from %v: solid angle %v, want %v", sampling, p, estimate, solidAngle)
+			}
+		}
+	}
+
+	// The light only shines from its front
+	for _, sampling := range []AreaLightSampling{SampleArea, SampleSolidAngle} {
+		l.Sampling = sampling
+		if _, _, _, ok := l.sample(vec3.T{0, 0, 2}, 0.5, 0.5, 0.5); ok {
+			t.Errorf("sampling %d: light is sampled from behind", sampling)
+		}
+	}
+}
+
+func TestSampleSphericalTriangle(t *testing.T) {
+	a, b, c := vec3.T{1, 0, 0}, vec3.T{0, 1, 0}, vec3.T{0, 0, 1}
+	if area := sphericalTriangleArea(a, b, c); math.Abs(float64(area)-math.Pi/2) > 1e-5 {
+		t.Errorf("octant covers %v, want pi / 2", area)
+	}
+	random := rand.New(rand.NewSource(1))
+	var sum vec3.T
+	const n = 20000
+	for i := 0; i < n; i++ {
+		d := sampleSphericalTriangle(a, b, c, random.Float32(), random.Float32())
+		if d[0] < -1e-5 || d[1] < -1e-5 || d[2] < -1e-5 || math.Abs(float64(d.Length()-1)) > 1e-4 {
+			t.Fatalf("direction %v is outside the octant", d)
+		}
+		sum.Add(&d)
+	}
+	// Uniform directions over the octant average to (1, 1, 1) / 2, as the
+	// octant covers a quarter disk seen along each axis
+	sum.Scale(1.0 / n)
+	if !vecApproxEqual(sum, vec3.T{0.5, 0.5, 0.5}, 0.01) {
+		t.Errorf("directions average to %v, want %v", sum, vec3.T{0.5, 0.5, 0.5})
+	}
+}
+
+func TestAreaLightContribution(t *testing.T) {
+	s := &Space{}
+	s.AddGeometry(lightSquare(200, 1))
+	plain := lightSquare(1, 2)
+	plain.Material = Material{Color: color.RGBA{255, 255, 255, 255}}
+	s.AddGeometry(plain)
+	if len(s.AreaLights) != 1 {
+		t.Fatalf("%d area lights, want only the emissive square", len(s.AreaLights))
+	}
+	s.AreaLights[0].Samples = 256
+
+	hit := RayFaceIntersection{
+		Ray:               CreateRay(vec3.T{0, 0, 0.5}, vec3.T{0, 0, -1}),
+		IntersectionPoint: vec3.Zero,
+		Normal:            vec3.T{0, 0, 1},
+		GeometricNormal:   vec3.T{0, 0, 1},
+		Material:          Material{Color: color.RGBA{255, 255, 255, 255}},
+	}
+	// A surface under a light that covers almost all of the sky is about
+	// as bright as under a white light of intensity 1
+	if c := s.AreaLights[0].CalculateColorContribution(hit, s); c.R < 230 {
+		t.Errorf("surface under the light is %v", c)
+	}
+	// Light that a geometry blocks is left out
+	s.AddGeometry(CreateTransformedGeometry(lightSquare(300, 0.5), mat4.Ident))
+	if len(s.AreaLights) != 2 {
+		t.Fatalf("%d area lights, want the transformed one too", len(s.AreaLights))
+	}
+	if c := s.AreaLights[0].CalculateColorContribution(hit, s); c.R != 0 {
+		t.Errorf("surface in the shadow is %v", c)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// BezierPatch is a bicubic Bézier patch. ControlPoints[i][j] is the control
+// point at row i along V and column j along U.
+type BezierPatch struct {
+	ControlPoints [4][4]vec3.T
+}
+
+// bernstein returns the cubic Bernstein polynomials and their derivatives at t.
+func bernstein(t float32) (b, db [4]float32) {
+	s := 1 - t
+	b = [4]float32{s * s * s, 3 * t * s * s, 3 * t * t * s, t * t * t}
+	db = [4]float32{-3 * s * s, 3 * s * (s - 2*t), 3 * t * (2*s - t), 3 * t * t}
+	return b, db
+}
+
+// Evaluate returns the point of the patch at (u, v) and the partial
+// derivatives there.
+func (p BezierPatch) Evaluate(u, v float32) (point, dpdu, dpdv vec3.T) {
+	bu, dbu := bernstein(u)
+	bv, dbv := bernstein(v)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			cp := p.ControlPoints[i][j]
+			weighted := cp.Scaled(bv[i] * bu[j])
+			point.Add(&weighted)
+			weighted = cp.Scaled(bv[i] * dbu[j])
+			dpdu.Add(&weighted)
+			weighted = cp.Scaled(dbv[i] * bu[j])
+			dpdv.Add(&weighted)
+		}
+	}
+	return point, dpdu, dpdv
+}
+
+// Normal returns the normal of the patch at (u, v). At degenerate points,
+// like the poles of the teapot lid, the derivatives are parallel, so the
+// normal is taken from slightly inside the patch instead.
+func (p BezierPatch) Normal(u, v float32) vec3.T {
+	const inset = 1e-3
+	for attempt := 0; attempt < 2; attempt++ {
+		_, dpdu, dpdv := p.Evaluate(u, v)
+		normal := vec3.Cross(&dpdu, &dpdv)
+		if normal.LengthSqr() > 1e-12 {
+			return *normal.Normalize()
+		}
+		u = inset + u*(1-2*inset)
+		v = inset + v*(1-2*inset)
+	}
+	return vec3.T{}
+}
+
+// segments returns the number of grid segments per side for which a grid of
+// the patch deviates from the surface by no more than tolerance. The error of
+// linear interpolation is bounded by the second differences of the control
+// points, including the mixed ones that bend the diagonals of the grid cells.
+func (p BezierPatch) segments(tolerance float32) int {
+	const maxSegments = 64
+	var secondU, secondV, mixed float32
+	for i := 0; i < 4; i++ {
+		for j := 0; j+2 < 4; j++ {
+			du := p.ControlPoints[i][j].Added(&p.ControlPoints[i][j+2])
+			mid := p.ControlPoints[i][j+1].Scaled(2)
+			du.Sub(&mid)
+			secondU = float32(math.Max(float64(secondU), float64(du.Length())))
+
+			dv := p.ControlPoints[j][i].Added(&p.ControlPoints[j+2][i])
+			mid = p.ControlPoints[j+1][i].Scaled(2)
+			dv.Sub(&mid)
+			secondV = float32(math.Max(float64(secondV), float64(dv.Length())))
+		}
+	}
+	for i := 0; i+1 < 4; i++ {
+		for j := 0; j+1 < 4; j++ {
+			duv := p.ControlPoints[i][j].Added(&p.ControlPoints[i+1][j+1])
+			duv.Sub(&p.ControlPoints[i][j+1])
+			duv.Sub(&p.ControlPoints[i+1][j])
+			mixed = float32(math.Max(float64(mixed), float64(duv.Length())))
+		}
+	}
+	if tolerance <= 0 {
+		return maxSegments
+	}
+	// A bicubic's second derivatives are at most 6 times its second
+	// differences along U and V and 9 times the mixed ones, and a triangle
+	// with sides of length h deviates by at most h^2/8 times their sum
+	second := 6*float64(secondU+secondV) + 2*9*float64(mixed)
+	n := math.Ceil(math.Sqrt(second / (8 * float64(tolerance))))
+	return int(math.Max(1, math.Min(maxSegments, n)))
+}
+
+// BezierSurface renders Bézier patches by tessellating each of them into a
+// grid of triangles that is within Tolerance of the surface in world space.
+// Normals are taken from the patches and the texture coordinates are the
+// (u, v) parameters of each patch. A BVH over the triangles keeps finely
+// tessellated surfaces fast to render.
+type BezierSurface struct {
+	Patches   []BezierPatch
+	Tolerance float32
+	Material  Material
+	mesh      *Obj
+	bvh       *BVH
+}
+
+func CreateBezierSurface(patches []BezierPatch, tolerance float32) *BezierSurface {
+	b := &BezierSurface{Patches: patches, Tolerance: tolerance}
+	b.Update()
+	return b
+}
+
+// Update tessellates the patches again after they or Tolerance were changed.
+func (b *BezierSurface) Update() {
+	b.mesh = b.Tessellate(b.Tolerance)
+	v := b.mesh.Vertices
+	bounds := make([]vec3.Box, len(b.mesh.Faces))
+	for i, f := range b.mesh.Faces {
+		bounds[i] = boundsOf([]vec3.T{v[f.VertexIndices[0]], v[f.VertexIndices[1]], v[f.VertexIndices[2]]})
+	}
+	b.bvh = BuildBVH(bounds)
+}
+
+// tessellated returns the mesh of the patches, which surfaces that were not
+// made by CreateBezierSurface tessellate on first use.
+func (b *BezierSurface) tessellated() *Obj {
+	if b.mesh == nil {
+		b.Update()
+	}
+	return b.mesh
+}
+
+// Tessellate converts the patches into a mesh within tolerance.
+func (b *BezierSurface) Tessellate(tolerance float32) *Obj {
+	mesh := &Obj{}
+	for _, patch := range b.Patches {
+		n := patch.segments(tolerance)
+		first := len(mesh.Vertices)
+		for i := 0; i <= n; i++ {
+			for j := 0; j <= n; j++ {
+				u, v := float32(j)/float32(n), float32(i)/float32(n)
+				point, _, _ := patch.Evaluate(u, v)
+				normal := patch.Normal(u, v)
+				mesh.Vertices = append(mesh.Vertices, point)
+				mesh.Normals = append(mesh.Normals, Normal{X: normal[0], Y: normal[1], Z: normal[2]})
+				mesh.TextureCoordinates = append(mesh.TextureCoordinates, TextureCoordinate{U: float64(u), V: float64(v)})
+			}
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a := first + i*(n+1) + j
+				quad := []int{a, a + 1, a + n + 2, a + n + 1}
+				for _, triangle := range [][]int{{quad[0], quad[1], quad[2]}, {quad[0], quad[2], quad[3]}} {
+					mesh.Faces = append(mesh.Faces, Face{
+						VertexIndices:            triangle,
+						TextureCoordinateIndices: triangle,
+						NormalIndices:            triangle,
+					})
+				}
+			}
+		}
+	}
+	return mesh
+}
+
+func (b *BezierSurface) GetGeometryData() GeometryData {
+	data := b.tessellated().GetGeometryData()
+	data.Material = b.Material
+	return data
+}
+
+func (b *BezierSurface) SetMaterial(material Material) {
+	b.Material = material
+}
+
+func (b *BezierSurface) Bounds() vec3.Box {
+	var points []vec3.T
+	for _, patch := range b.Patches {
+		for _, row := range patch.ControlPoints {
+			points = append(points, row[:]...)
+		}
+	}
+	return boundsOf(points)
+}
+
+func (b *BezierSurface) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	mesh := b.tessellated()
+	var hit faceHit
+	face, _, ok := b.bvh.Intersect(ray, func(i int, ray Ray) (float32, bool) {
+		h, ok := mesh.Faces[i].intersect(ray, mesh.Vertices)
+		if ok {
+			hit = h
+		}
+		return h.Distance, ok
+	})
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+	intersection := mesh.faceIntersection(ray, face, hit)
+	intersection.Material = b.Material
+	return intersection, true
+}
+
+// LoadBezierPatches reads patches in the format of the classic teapot data:
+// the number of patches, one line with 16 comma separated 1-based control
+// point indices per patch, the number of control points and one line with
+// comma separated x, y and z coordinates per control point.
+func LoadBezierPatches(filename string) ([]BezierPatch, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Collect the lines that are not empty, each split into numbers
+	var lines [][]string
+	var lineNumbers []int
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) > 0 {
+			lines = append(lines, fields)
+			lineNumbers = append(lineNumbers, lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	next := 0
+	readCount := func() (int, error) {
+		if next >= len(lines) {
+			return 0, fmt.Errorf("%s: unexpected end of file", filename)
+		}
+		count, err := strconv.Atoi(lines[next][0])
+		if err != nil {
+			return 0, fmt.Errorf("%s: line %d: %v", filename, lineNumbers[next], err)
+		}
+		if count < 0 {
+			return 0, fmt.Errorf("%s: line %d: negative count", filename, lineNumbers[next])
+		}
+		next++
+		return count, nil
+	}
+
+	patchCount, err := readCount()
+	if err != nil {
+		return nil, err
+	}
+	if next+patchCount > len(lines) {
+		return nil, fmt.Errorf("%s: unexpected end of file", filename)
+	}
+	indices := make([][16]int, patchCount)
+	for p := range indices {
+		fields := lines[next]
+		if len(fields) != 16 {
+			return nil, fmt.Errorf("%s: line %d: patch has %d control points instead of 16", filename, lineNumbers[next], len(fields))
+		}
+		for k, field := range fields {
+			idx, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %v", filename, lineNumbers[next], err)
+			}
+			indices[p][k] = idx - 1
+		}
+		next++
+	}
+
+	pointCount, err := readCount()
+	if err != nil {
+		return nil, err
+	}
+	if next+pointCount > len(lines) {
+		return nil, fmt.Errorf("%s: unexpected end of file", filename)
+	}
+	points := make([]vec3.T, pointCount)
+	for i := range points {
+		fields := lines[next]
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s: line %d: control point has %d coordinates instead of 3", filename, lineNumbers[next], len(fields))
+		}
+		for k, field := range fields {
+			c, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %v", filename, lineNumbers[next], err)
+			}
+			points[i][k] = float32(c)
+		}
+		next++
+	}
+
+	patches := make([]BezierPatch, patchCount)
+	for p, patchIndices := range indices {
+		for k, idx := range patchIndices {
+			if idx < 0 || idx >= len(points) {
+				return nil, fmt.Errorf("%s: patch %d: control point %d does not exist", filename, p+1, idx+1)
+			}
+			patches[p].ControlPoints[k/4][k%4] = points[idx]
+		}
+	}
+	return patches, nil
+}
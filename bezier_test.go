@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// domePatch spans -1 to 1 in X and Y and bulges up to z = height in the
+// middle.
+func domePatch(height float32) BezierPatch {
+	var p BezierPatch
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			var z float32
+			if i > 0 && i < 3 && j > 0 && j < 3 {
+				z = height
+			}
+			p.ControlPoints[i][j] = vec3.T{float32(j)*2/3 - 1, float32(i)*2/3 - 1, z}
+		}
+	}
+	return p
+}
+
+func TestBezierPatchEvaluate(t *testing.T) {
+	p := domePatch(0)
+	point, dpdu, dpdv := p.Evaluate(0.25, 0.75)
+	if !vecApproxEqual(point, vec3.T{-0.5, 0.5, 0}, 1e-6) {
+		t.Errorf("point %v, want %v", point, vec3.T{-0.5, 0.5, 0})
+	}
+	if !vecApproxEqual(dpdu, vec3.T{2, 0, 0}, 1e-5) || !vecApproxEqual(dpdv, vec3.T{0, 2, 0}, 1e-5) {
+		t.Errorf("derivatives %v and %v, want %v and %v", dpdu, dpdv, vec3.T{2, 0, 0}, vec3.T{0, 2, 0})
+	}
+	if n := p.Normal(0.25, 0.75); !vecApproxEqual(n, vec3.T{0, 0, 1}, 1e-6) {
+		t.Errorf("normal %v, want %v", n, vec3.T{0, 0, 1})
+	}
+	if n := p.segments(0.01); n != 1 {
+		t.Errorf("flat patch has %d segments, want 1", n)
+	}
+}
+
+func TestBezierSurfaceTolerance(t *testing.T) {
+	patch := domePatch(1)
+	for _, tolerance := range []float32{0.1, 0.03, 0.01} {
+		surface := CreateBezierSurface([]BezierPatch{patch}, tolerance)
+		// Compare the surface and the mesh in the middle of the grid cells,
+		// where they are farthest apart
+		n := patch.segments(tolerance)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				u, v := (float32(j)+0.5)/float32(n), (float32(i)+0.5)/float32(n)
+				point, _, _ := patch.Evaluate(u, v)
+				hit, ok := surface.Intersect(CreateRay(vec3.T{point[0], point[1], 10}, vec3.T{0, 0, -1}))
+				if !ok {
+					t.Fatalf("tolerance %v: ray misses the surface at %v", tolerance, point)
+				}
+				if d := math.Abs(float64(hit.IntersectionPoint[2] - point[2])); d > float64(tolerance) {
+					t.Fatalf("tolerance %v: mesh is %v away from the surface at %v", tolerance, d, point)
+				}
+			}
+		}
+	}
+}
+
+func TestBezierSurfaceIntersect(t *testing.T) {
+	patches := []BezierPatch{domePatch(1), domePatch(-0.5)}
+	for i := range patches[1].ControlPoints {
+		for j := range patches[1].ControlPoints[i] {
+			patches[1].ControlPoints[i][j][0] += 2
+		}
+	}
+	// Surfaces that were not made by CreateBezierSurface are tessellated
+	// when they are first hit
+	surface := &BezierSurface{Patches: patches, Tolerance: 0.001}
+	mesh := surface.Tessellate(surface.Tolerance)
+	for x := float32(-1.2); x < 3.2; x += 0.13 {
+		for y := float32(-1.2); y < 1.2; y += 0.17 {
+			ray := CreateRay(vec3.T{x, y, 5}, vec3.T{0.1, -0.05, -1})
+			want, wantOk := mesh.Intersect(ray)
+			hit, ok := surface.Intersect(ray)
+			if ok != wantOk {
+				t.Fatalf("ray from %v: hit %v, mesh hit %v", ray.Origin, ok, wantOk)
+			}
+			if ok && (hit.IntersectionDistance != want.IntersectionDistance || hit.Normal != want.Normal || hit.TextureCoordinate != want.TextureCoordinate) {
+				t.Fatalf("ray from %v: hit %+v, mesh hit %+v", ray.Origin, hit, want)
+			}
+		}
+	}
+	if data := (&BezierSurface{Patches: patches}).GetGeometryData(); len(data.Faces) == 0 {
+		t.Error("surface without a mesh has no geometry data")
+	}
+}
+
+func TestLoadBezierPatches(t *testing.T) {
+	var points []string
+	for i := 0; i < 16; i++ {
+		points = append(points, fmt.Sprintf("%d, %d, 0", i%4, i/4))
+	}
+	indices := "1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16"
+	content := "1\n" + indices + "\n16\n" + strings.Join(points, "\n") + "\n"
+
+	patches, err := LoadBezierPatches(writeTestFile(t, "patch.bpt", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("%d patches, want 1", len(patches))
+	}
+	if cp := patches[0].ControlPoints[2][1]; cp != (vec3.T{1, 2, 0}) {
+		t.Errorf("control point [2][1] is %v, want %v", cp, vec3.T{1, 2, 0})
+	}
+
+	broken := map[string]string{
+		"too few indices":      "1\n1,2,3\n16\n" + strings.Join(points, "\n"),
+		"missing points":       "1\n" + indices + "\n16\n" + strings.Join(points[:10], "\n"),
+		"index out of range":   "1\n" + strings.Replace(indices, "16", "17", 1) + "\n16\n" + strings.Join(points, "\n"),
+		"not a number":         "one\n",
+		"too many coordinates": "1\n" + indices + "\n16\n1,2,3,4\n" + strings.Join(points[1:], "\n"),
+		"negative patches":     "-1\n",
+		"negative points":      "1\n" + indices + "\n-16\n" + strings.Join(points, "\n"),
+	}
+	for name, content := range broken {
+		if _, err := LoadBezierPatches(writeTestFile(t, "patch.bpt", content)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, err := LoadBezierPatches(writeTestFile(t, "patch.bpt", "-1\n")); err == nil || !strings.HasSuffix(err.Error(), "line 1: negative count") {
+		t.Errorf("negative count: error %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// BRDF is the metallic-roughness model of glTF: a Lambert diffuse lobe and a
+// specular lobe with the GGX (Trowbridge-Reitz) distribution of microfacets,
+// Smith masking-shadowing and Schlick's approximation of Fresnel
+// reflectance. All directions are unit vectors pointing away from the
+// surface.
+type BRDF struct {
+	BaseColor vec3.T  // red, green and blue between 0 and 1
+	Metallic  float32 // 0 for dielectrics, 1 for metals
+	Roughness float32 // perceptual roughness, squared for the distribution
+	Diffuse   float32 // weight of the diffuse lobe
+}
+
+// dielectricReflectance is the reflectance at normal incidence of
+// non-metals, that of an index of refraction of 1.5.
+const dielectricReflectance = 0.04
+
+// minAlpha keeps perfectly smooth surfaces from dividing by zero.
+const minAlpha = 1e-3
+
+func CreateBRDF(m Material) BRDF {
+	return BRDF{
+		BaseColor: colorToVec3(m.Color),
+		Metallic:  m.Metallic,
+		Roughness: m.Roughness,
+		Diffuse:   m.Diffuse,
+	}
+}
+
+func colorToVec3(c color.RGBA) vec3.T {
+	return vec3.T{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255}
+}
+
+func (b BRDF) alpha() float32 {
+	return float32(math.Max(float64(b.Roughness*b.Roughness), minAlpha))
+}
+
+// reflectance returns the Fresnel reflectance at normal incidence, which is
+// the base colour for metals.
+func (b BRDF) reflectance() vec3.T {
+	dielectric := vec3.T{dielectricReflectance, dielectricReflectance, dielectricReflectance}
+	return vec3.Interpolate(&dielectric, &b.BaseColor, b.Metallic)
+}
+
+// Evaluate returns the reflected fraction of the light from lightDir towards
+// viewDir per colour channel, without the cosine of the light.
+func (b BRDF) Evaluate(normal, viewDir, lightDir vec3.T) vec3.T {
+	cosV := vec3.Dot(&normal, &viewDir)
+	cosL := vec3.Dot(&normal, &lightDir)
+	if cosV <= 0 || cosL <= 0 {
+		return vec3.T{}
+	}
+	half := vec3.Add(&viewDir, &lightDir)
+	half.Normalize()
+	cosH := vec3.Dot(&normal, &half)
+	cosVH := vec3.Dot(&viewDir, &half)
+
+	alpha := b.alpha()
+	f0 := b.reflectance()
+	fresnel := schlickFresnel(f0, cosVH)
+	specular := fresnel.Scaled(ggxDistribution(cosH, alpha) * smithMasking(cosV, cosL, alpha) / (4 * cosV * cosL))
+
+	// Light that is not reflected at the surface is scattered inside it,
+	// which metals do not do
+	diffuse := b.BaseColor.Scaled(b.Diffuse * (1 - b.Metallic) / math.Pi)
+	for i := range diffuse {
+		diffuse[i] *= 1 - fresnel[i]
+	}
+	return vec3.Add(&diffuse, &specular)
+}
+
+// Sample picks a direction of incoming light for viewDir with about the
+// distribution of the reflected light, given two uniform random numbers in
+// [0, 1). It returns the direction, the BRDF times the cosine of the
+// direction divided by its probability density, which is what a path
+// carries on, and the density. ok is false if no light is reflected.
+func (b BRDF) Sample(normal, viewDir vec3.T, u1, u2 float32) (lightDir, weight vec3.T, pdf float32, ok bool) {
+	if vec3.Dot(&normal, &viewDir) <= 0 {
+		return vec3.T{}, vec3.T{}, 0, false
+	}
+	tangent, bitangent := coordinateSystem(normal)
+	toWorld := func(x, y, z float32) vec3.T {
+		t, bt, n := tangent.Scaled(x), bitangent.Scaled(y), normal.Scaled(z)
+		v := vec3.Add(&t, &bt)
+		return *v.Add(&n)
+	}
+
+	// Pick a lobe, then reuse the random number that picked it
+	pSpecular := b.specularProbability()
+	if u1 < pSpecular {
+		u1 /= pSpecular
+		// Half vector from the GGX distribution of normals
+		alpha := b.alpha()
+		phi := 2 * math.Pi * float64(u2)
+		cosTheta := math.Sqrt((1 - float64(u1)) / (1 + float64(alpha*alpha-1)*float64(u1)))
+		sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+		half := toWorld(float32(sinTheta*math.Cos(phi)), float32(sinTheta*math.Sin(phi)), float32(cosTheta))
+		lightDir = half.Scaled(2 * vec3.Dot(&viewDir, &half))
+		lightDir.Sub(&viewDir)
+	} else {
+		u1 = (u1 - pSpecular) / (1 - pSpecular)
+		// Cosine weighted hemisphere
+		r := math.Sqrt(float64(u1))
+		phi := 2 * math.Pi * float64(u2)
+		lightDir = toWorld(float32(r*math.Cos(phi)), float32(r*math.Sin(phi)), float32(math.Sqrt(1-float64(u1))))
+	}
+	lightDir.Normalize()
+
+	cosL := vec3.Dot(&normal, &lightDir)
+	pdf = b.PDF(normal, viewDir, lightDir)
+	if cosL <= 0 || pdf <= 0 {
+		return vec3.T{}, vec3.T{}, 0, false
+	}
+	weight = b.Evaluate(normal, viewDir, lightDir)
+	weight.Scale(cosL / pdf)
+	return lightDir, weight, pdf, true
+}
+
+// PDF returns the probability density with which Sample picks lightDir.
+func (b BRDF) PDF(normal, viewDir, lightDir vec3.T) float32 {
+	cosL := vec3.Dot(&normal, &lightDir)
+	if cosL <= 0 || vec3.Dot(&normal, &viewDir) <= 0 {
+		return 0
+	}
+	half := vec3.Add(&viewDir, &lightDir)
+	half.Normalize()
+	cosH := vec3.Dot(&normal, &half)
+	cosVH := vec3.Dot(&viewDir, &half)
+
+	// The density of the half vector changes with the reflection around it
+	specular := ggxDistribution(cosH, b.alpha()) * cosH / (4 * cosVH)
+	diffuse := cosL / math.Pi
+	pSpecular := b.specularProbability()
+	return pSpecular*specular + (1-pSpecular)*diffuse
+}
+
+// specularProbability returns how often Sample picks the specular lobe, by
+// roughly how much each lobe reflects.
+func (b BRDF) specularProbability() float32 {
+	f0 := b.reflectance()
+	specular := (f0[0] + f0[1] + f0[2]) / 3
+	diffuse := b.Diffuse * (1 - b.Metallic) * (b.BaseColor[0] + b.BaseColor[1] + b.BaseColor[2]) / 3
+	if specular+diffuse <= 0 {
+		return 1
+	}
+	return float32(math.Max(float64(specular/(specular+diffuse)), 0.1))
+}
+
+// ggxDistribution returns the density of microfacets with a normal at the
+// angle with cosine cosH from the surface normal.
+func ggxDistribution(cosH, alpha float32) float32 {
+	if cosH <= 0 {
+		return 0
+	}
+	a2 := alpha * alpha
+	d := cosH*cosH*(a2-1) + 1
+	return a2 / (math.Pi * d * d)
+}
+
+// smithMasking returns the fraction of microfacets that are visible from both
+// directions, with masking and shadowing taken as independent.
+func smithMasking(cosV, cosL, alpha float32) float32 {
+	return smithG1(cosV, alpha) * smithG1(cosL, alpha)
+}
+
+func smithG1(cos, alpha float32) float32 {
+	a2 := float64(alpha * alpha)
+	c2 := float64(cos * cos)
+	return float32(2 * float64(cos) / (float64(cos) + math.Sqrt(a2+(1-a2)*c2)))
+}
+
+// schlickFresnel approximates the Fresnel reflectance at the angle with
+// cosine cosTheta from the reflectance f0 at normal incidence.
+func schlickFresnel(f0 vec3.T, cosTheta float32) vec3.T {
+	m := float32(math.Pow(math.Max(0, 1-float64(cosTheta)), 5))
+	return vec3.T{
+		f0[0] + (1-f0[0])*m,
+		f0[1] + (1-f0[1])*m,
+		f0[2] + (1-f0[2])*m,
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+var testBRDFs = []BRDF{
+	{BaseColor: vec3.T{0.8, 0.5, 0.2}, Roughness: 0.3, Diffuse: 1},
+	{BaseColor: vec3.T{0.8, 0.5, 0.2}, Roughness: 0.7, Diffuse: 1},
+	{BaseColor: vec3.T{1, 1, 1}, Metallic: 1, Roughness: 0.5, Diffuse: 1},
+}
+
+// uniformHemisphere returns a direction on the hemisphere around Z for two
+// uniform random numbers, with density 1 / (2 pi).
+func uniformHemisphere(u1, u2 float64) vec3.T {
+	z := u1
+	r := math.Sqrt(1 - z*z)
+	phi := 2 * math.Pi * u2
+	return vec3.T{float32(r * math.Cos(phi)), float32(r * math.Sin(phi)), float32(z)}
+}
+
+func TestBRDFPDFIntegratesToOne(t *testing.T) {
+	normal := vec3.T{0, 0, 1}
+	viewDir := vec3.T{0.6, 0, 0.8}
+	random := rand.New(rand.NewSource(1))
+	const n = 200000
+	for _, b := range testBRDFs {
+		var sum float64
+		for i := 0; i < n; i++ {
+			lightDir := uniformHemisphere(random.Float64(), random.Float64())
+			sum += float64(b.PDF(normal, viewDir, lightDir)) * 2 * math.Pi
+		}
+		// Some specular samples are reflected below the horizon
+		if integral := sum / n; integral > 1.02 || integral < 0.9 {
+			t.Errorf("%+v: PDF integrates to %v", b, integral)
+		}
+	}
+}
+
+func TestBRDFSample(t *testing.T) {
+	normal := vec3.T{0, 0, 1}
+	viewDir := vec3.T{0.6, 0, 0.8}
+	random := rand.New(rand.NewSource(1))
+	for _, b := range testBRDFs {
+		var reflected vec3.T
+		const n = 20000
+		for i := 0; i < n; i++ {
+			lightDir, weight, pdf, ok := b.Sample(normal, viewDir, random.Float32(), random.Float32())
+			if !ok {
+				continue
+			}
+			if lightDir[2] <= 0 || math.Abs(float64(lightDir.Length()-1)) > 1e-4 {
+				t.Fatalf("%+v: sampled direction %v", b, lightDir)
+			}
+			if want := b.PDF(normal, viewDir, lightDir); math.Abs(float64(pdf-want)) > 1e-3*float64(want) {
+				t.Fatalf("%+v: density %v, PDF gives %v", b, pdf, want)
+			}
+			want := b.Evaluate(normal, viewDir, lightDir)
+			want.Scale(lightDir[2] / pdf)
+			if !vecApproxEqual(weight, want, 1e-4*want.Length()+1e-6) {
+				t.Fatalf("%+v: weight %v, want %v", b, weight, want)
+			}
+			reflected.Add(&weight)
+		}
+		// Surfaces do not reflect more light than they receive
+		reflected.Scale(1.0 / n)
+		for i, c := range reflected {
+			if c > 1.02 || c <= 0 {
+				t.Errorf("%+v: reflects %v of channel %d", b, c, i)
+			}
+		}
+	}
+}
+
+func TestBRDFEvaluate(t *testing.T) {
+	normal := vec3.T{0, 0, 1}
+	a, c := vec3.T{0.6, 0, 0.8}, vec3.T{0, -0.8, 0.6}
+	below := vec3.T{0, 0.6, -0.8}
+	for _, b := range testBRDFs {
+		// Light paths can be reversed
+		if ac, ca := b.Evaluate(normal, a, c), b.Evaluate(normal, c, a); !vecApproxEqual(ac, ca, 1e-5) {
+			t.Errorf("%+v: %v one way and %v the other", b, ac, ca)
+		}
+		if f := b.Evaluate(normal, a, below); !f.IsZero() {
+			t.Errorf("%+v: light from below the surface reflects %v", b, f)
+		}
+		if _, _, _, ok := b.Sample(normal, below, 0.5, 0.5); ok {
+			t.Errorf("%+v: sampled from below the surface", b)
+		}
+	}
+
+	// Smooth surfaces reflect the mirror direction far more than others
+	smooth := BRDF{BaseColor: vec3.T{1, 1, 1}, Metallic: 1, Roughness: 0.1, Diffuse: 1}
+	mirror := vec3.T{-0.6, 0, 0.8}
+	if m, o := smooth.Evaluate(normal, a, mirror), smooth.Evaluate(normal, a, c); m[0] < 100*o[0] {
+		t.Errorf("mirror direction reflects %v, another %v", m[0], o[0])
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/ungerik/go3d/vec3"
+)
+
+// bvhLeafSize is the largest number of primitives kept in a leaf.
+const bvhLeafSize = 4
+
+// BVH is a bounding volume hierarchy over primitives that are only known by
+// their index and bounds. Geometries made of many small parts use it to
+// test a ray against the few parts near it only.
+type BVH struct {
+	nodes   []bvhNode
+	indices []int
+}
+
+// bvhNode is an inner node if count is 0, with its children at left and
+// left+1. Otherwise it is a leaf with the primitives indices[first:first+count].
+type bvhNode struct {
+	bounds vec3.Box
+	left   int
+	first  int
+	count  int
+}
+
+// BuildBVH builds a hierarchy over primitives with the given bounds. Nodes are
+// split at the median of the primitive centers along their longest axis.
+func BuildBVH(bounds []vec3.Box) *BVH {
+	b := &BVH{indices: make([]int, len(bounds))}
+	for i := range b.indices {
+		b.indices[i] = i
+	}
+	if len(bounds) == 0 {
+		return b
+	}
+	centers := make([]vec3.T, len(bounds))
+	for i := range bounds {
+		centers[i] = vec3.Interpolate(&bounds[i].Min, &bounds[i].Max, 0.5)
+	}
+	b.nodes = append(b.nodes, bvhNode{})
+	b.build(0, 0, len(bounds), bounds, centers)
+	return b
+}
+
+func (b *BVH) build(node, first, count int, bounds []vec3.Box, centers []vec3.T) {
+	box := bounds[b.indices[first]]
+	centerBox := vec3.Box{Min: centers[b.indices[first]], Max: centers[b.indices[first]]}
+	for _, idx := range b.indices[first+1 : first+count] {
+		box.Join(&bounds[idx])
+		centerBox.Min = vec3.Min(&centerBox.Min, &centers[idx])
+		centerBox.Max = vec3.Max(&centerBox.Max, &centers[idx])
+	}
+	b.nodes[node].bounds = box
+
+	size := centerBox.Diagonal()
+	if count <= bvhLeafSize || size.IsZero() {
+		b.nodes[node].first = first
+		b.nodes[node].count = count
+		return
+	}
+
+	axis := 0
+	if size[1] > size[axis] {
+		axis = 1
+	}
+	if size[2] > size[axis] {
+		axis = 2
+	}
+	half := count / 2
+	selectNth(b.indices[first:first+count], half, func(idx int) float32 { return centers[idx][axis] })
+
+	left := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{}, bvhNode{})
+	b.nodes[node].left = left
+	b.build(left, first, half, bounds, centers)
+	b.build(left+1, first+half, count-half, bounds, centers)
+}
+
+// selectNth reorders indices so that the element at n is the one that would
+// be there if they were sorted by key, with no larger keys before it and no
+// smaller ones after it.
+func selectNth(indices []int, n int, key func(int) float32) {
+	lo, hi := 0, len(indices)-1
+	for lo < hi {
+		pivot := key(indices[(lo+hi)/2])
+		i, j := lo, hi
+		for i <= j {
+			for key(indices[i]) < pivot {
+				i++
+			}
+			for key(indices[j]) > pivot {
+				j--
+			}
+			if i <= j {
+				indices[i], indices[j] = indices[j], indices[i]
+				i++
+				j--
+			}
+		}
+		if n <= j {
+			hi = j
+		} else if n >= i {
+			lo = i
+		} else {
+			return
+		}
+	}
+}
+
+// Bounds returns the bounds of all primitives.
+func (b *BVH) Bounds() vec3.Box {
+	if len(b.nodes) == 0 {
+		return vec3.Box{}
+	}
+	return b.nodes[0].bounds
+}
+
+// Intersect finds the closest primitive hit by the ray. intersect is called
+// for the primitives whose bounds the ray passes through and returns the
+// distance of the hit, which must be within [ray.TMin, ray.TMax].
+func (b *BVH) Intersect(ray Ray, intersect func(index int, ray Ray) (float32, bool)) (int, float32, bool) {
+	closest, closestDistance := -1, ray.TMax
+	if len(b.nodes) == 0 {
+		return closest, closestDistance, false
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if _, _, ok := ray.intersectBox(node.bounds); !ok {
+			continue
+		}
+
+		if node.count > 0 {
+			for _, idx := range b.indices[node.first : node.first+node.count] {
+				if t, ok := intersect(idx, ray); ok {
+					closest, closestDistance = idx, t
+					ray.TMax = t
+				}
+			}
+			continue
+		}
+
+		// Visit the nearer child first so that hits there cut off the other
+		near, far := node.left, node.left+1
+		nearEntry, _, nearOk := ray.intersectBox(b.nodes[near].bounds)
+		farEntry, _, farOk := ray.intersectBox(b.nodes[far].bounds)
+		if !nearOk && !farOk {
+			continue
+		}
+		if nearOk && farOk && farEntry < nearEntry {
+			near, far = far, near
+		}
+		stack = append(stack, far, near)
+	}
+	return closest, closestDistance, closest >= 0
+}
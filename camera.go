@@ -61,53 +61,142 @@ func (c *Camera) CalculatePixelPosition(x, y int) vec3.T {
 }
 
 type RayFaceIntersection struct {
+	Ray                  Ray // the ray that hit the surface
 	ReflectionRay        Ray
 	IntersectionPoint    vec3.T
+	ObjectPoint          vec3.T // intersection point in the space of the geometry
 	IntersectionDistance float32
-	Face                 Face
+	Normal               vec3.T // interpolated normal used for shading
+	GeometricNormal      vec3.T // true normal of the surface
+	TextureCoordinate    TextureCoordinate
+	Tangent              vec3.T // direction of curves, or of U on meshes with texture coordinates
+	Bitangent            vec3.T // direction of V on meshes with texture coordinates
+	Face                 Face   // only set for meshes
 	Geometry             Geometry
 	Material             Material
+
+	// DpDu and DpDv are the change of the point with the texture
+	// coordinate, and DnDu and DnDv that of the shading normal. Zero if
+	// the geometry does not know them.
+	DpDu, DpDv vec3.T
+	DnDu, DnDv vec3.T
+	// The differentials are the change of the point and the texture
+	// coordinate from one pixel to the next, see computeDifferentials.
+	DpDx, DpDy   vec3.T
+	DuvDx, DuvDy TextureCoordinate
+}
+
+// newIntersection creates the intersection of ray at distance t. Normals and
+// material are left to the geometry that was hit.
+func newIntersection(ray Ray, t float32) RayFaceIntersection {
+	return RayFaceIntersection{
+		Ray:                  ray,
+		IntersectionPoint:    ray.At(t),
+		ObjectPoint:          ray.At(t),
+		IntersectionDistance: t,
+	}
+}
+
+// flipNormals turns the intersection into one with the inside of the surface.
+func (i *RayFaceIntersection) flipNormals() {
+	i.Normal.Invert()
+	i.GeometricNormal.Invert()
 }
 
 func (c Camera) CalculateFocalLength(sensorWidth, fov float64) float64 {
 	return (sensorWidth / 2) / math.Tan(fov/2*(math.Pi/180))
 }
 
+// ProjectedSize returns the approximate size in pixels of a sphere in the
+// image of the camera.
+func (c Camera) ProjectedSize(center vec3.T, radius float32) float32 {
+	distance := vec3.Distance(&center, &c.Origin)
+	if distance <= radius {
+		return float32(math.Max(float64(c.ResolutionX), float64(c.ResolutionY)))
+	}
+	// Pixels are 2*FocalLength/ResolutionY wide on the image plane, which is
+	// FocalLength*|Direction| away from the camera
+	return 2 * radius / distance * c.Direction.Length() * float32(c.ResolutionY) / 2
+}
+
+// cameraDependent is implemented by geometries that adapt to the camera
+// before rendering, like LOD.
+type cameraDependent interface {
+	SelectLevel(c Camera)
+}
+
 func (c Camera) Render(s *Space) {
 	img := image.NewRGBA(image.Rect(0, 0, c.ResolutionX, c.ResolutionY))
 	rays := c.CreateRays()
-	for i, ray := range rays {
-		var intersections = []RayFaceIntersection{}
-		for _, geometry := range s.Geometries {
-			Faces := (*geometry).GetGeometryData().Faces
-			for _, Face := range Faces {
-				intersects, dis, point := Face.Intersects(ray, (*geometry).GetGeometryData().Vertices)
-				if intersects {
-					intersection := RayFaceIntersection{IntersectionPoint: point, IntersectionDistance: dis, Face: Face, Material: ((*geometry).GetGeometryData().Material), Geometry: *geometry}
-					intersections = append(intersections, intersection)
-				}
-			}
-
+	for _, geometry := range s.Geometries {
+		if g, ok := (*geometry).(cameraDependent); ok {
+			g.SelectLevel(c)
 		}
-		if len(intersections) > 0 {
-			var finalColor color.RGBA
-			intersection := intersections[0]
-			for _, i := range intersections {
-				if i.IntersectionDistance < intersection.IntersectionDistance {
-					intersection = i
-				}
-			}
-			for _, light := range s.Lights {
-				lightContribution := light.CalculateColorContribution(intersection.Geometry, intersection.IntersectionPoint, intersection.Face)
-				finalColor = AddColors(finalColor, lightContribution)
-			}
-			finalColor = AddColors(finalColor, intersection.Material.Color)
+	}
+	s.UpdateBounds()
+	for i, ray := range rays {
+		if finalColor, ok := s.trace(ray, 0); ok {
 			img.Set(i%c.ResolutionX, i/c.ResolutionY, finalColor)
 		}
 	}
 	SaveImage(img, "test.png")
 }
 
+// maxTraceDepth limits how many times a ray is reflected or refracted.
+const maxTraceDepth = 4
+
+// trace returns the colour of the first surface the ray hits. Materials with
+// a Reflectivity blend in what they reflect, and materials with an Opacity
+// between 0 and 1 what they refract, up to maxTraceDepth times. An Opacity
+// of 0 counts as unset, so materials are opaque unless they ask otherwise.
+// The reflected and refracted rays carry differentials, so textures seen in
+// mirrors and through glass are filtered too.
+func (s *Space) trace(ray Ray, depth int) (color.RGBA, bool) {
+	intersection, ok := s.Intersect(ray)
+	if !ok {
+		return color.RGBA{}, false
+	}
+	intersection.computeDifferentials()
+	intersection.applyTextures()
+	var finalColor color.RGBA
+	for _, light := range s.Lights {
+		lightContribution := light.CalculateColorContribution(intersection)
+		finalColor = AddColors(finalColor, lightContribution)
+	}
+	for _, light := range s.AreaLights {
+		finalColor = AddColors(finalColor, light.CalculateColorContribution(intersection, s))
+	}
+	if intersection.Material.IsEmissive() {
+		finalColor = AddColors(finalColor, intersection.Material.EmittedColor())
+	}
+	finalColor = AddColors(finalColor, intersection.Material.Color)
+
+	m := intersection.Material
+	if depth >= maxTraceDepth {
+		return finalColor, true
+	}
+	if m.Reflectivity > 0 {
+		reflected, _ := s.trace(intersection.Reflect(), depth+1)
+		finalColor = blendColors(finalColor, reflected, m.Reflectivity)
+	}
+	if m.Opacity > 0 && m.Opacity < 1 {
+		if ray, ok := intersection.Refract(); ok {
+			refracted, _ := s.trace(ray, depth+1)
+			finalColor = blendColors(finalColor, refracted, 1-m.Opacity)
+		}
+	}
+	return finalColor, true
+}
+
+// blendColors returns a with the fraction f of it replaced by b.
+func blendColors(a, b color.RGBA, f float32) color.RGBA {
+	f = float32(math.Max(0, math.Min(1, float64(f))))
+	blend := func(x, y uint8) uint8 {
+		return uint8(clampColorComponent(float32(x)*(1-f) + float32(y)*f + 0.5))
+	}
+	return color.RGBA{R: blend(a.R, b.R), G: blend(a.G, b.G), B: blend(a.B, b.B), A: 255}
+}
+
 func CreateCamera(origin, direction vec3.T, up vec3.T, fov, aspectRatio float32, resolutionX, resolutionY int) Camera {
 	c := Camera{}
 	c.Origin = origin
@@ -128,7 +217,11 @@ func (camera Camera) CreateRays() []Ray {
 	for y := 0; y < camera.ResolutionY; y++ {
 		for x := 0; x < camera.ResolutionX; x++ {
 			direction := calculateRayDirection(camera, x, y)
-			rays = append(rays, Ray{Origin: camera.Origin, Direction: direction})
+			ray := CreateRay(camera.Origin, direction)
+			ray.HasDifferentials = true
+			ray.RxOrigin, ray.RxDirection = camera.Origin, calculateRayDirection(camera, x+1, y)
+			ray.RyOrigin, ray.RyDirection = camera.Origin, calculateRayDirection(camera, x, y+1)
+			rays = append(rays, ray)
 		}
 	}
 	return rays
@@ -139,8 +232,3 @@ func calculateRayDirection(camera Camera, pixelX, pixelY int) vec3.T {
 	direction := vec3.Sub(&pixelPosition, &camera.Origin)
 	return direction
 }
-
-type Ray struct {
-	Origin    vec3.T
-	Direction vec3.T
-}
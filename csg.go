@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// Solid is a geometry with a well defined inside, which makes it possible to
+// combine it with other solids using constructive solid geometry.
+type Solid interface {
+	Geometry
+	// Spans returns the parts of the whole line of the ray, ignoring TMin and
+	// TMax, that are inside the solid, sorted by distance. A span that is
+	// unbounded on one side has its distance there set to ±math.MaxFloat32.
+	Spans(ray Ray) []Span
+}
+
+// Span is an interval along a ray that lies inside a solid, given by the
+// intersections where the ray enters and exits it.
+type Span struct {
+	Enter RayFaceIntersection
+	Exit  RayFaceIntersection
+}
+
+// spansFromBoundaries pairs up the intersections of a ray with the surface of
+// a solid. Whether the ray enters or exits the solid is decided by the
+// direction of the geometric normal.
+func spansFromBoundaries(hits []RayFaceIntersection) []Span {
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].IntersectionDistance < hits[j].IntersectionDistance
+	})
+
+	var spans []Span
+	var current Span
+	depth := 0
+	lastDistance, lastEntering := float32(math.NaN()), false
+	for _, hit := range hits {
+		entering := vec3.Dot(&hit.Ray.Direction, &hit.GeometricNormal) < 0
+
+		// A ray through a shared edge hits both faces at the same distance
+		if hit.IntersectionDistance == lastDistance && entering == lastEntering {
+			continue
+		}
+		lastDistance, lastEntering = hit.IntersectionDistance, entering
+
+		if entering {
+			if depth == 0 {
+				current.Enter = hit
+			}
+			depth++
+			continue
+		}
+		if depth == 0 {
+			// The ray started inside a solid whose entry was not found
+			current.Enter = unboundedIntersection(hit.Ray, -math.MaxFloat32)
+			depth = 1
+		}
+		depth--
+		if depth == 0 {
+			current.Exit = hit
+			spans = append(spans, current)
+		}
+	}
+	if depth > 0 {
+		current.Exit = unboundedIntersection(current.Enter.Ray, math.MaxFloat32)
+		spans = append(spans, current)
+	}
+	return spans
+}
+
+// unboundedIntersection marks the open end of a span.
+func unboundedIntersection(ray Ray, t float32) RayFaceIntersection {
+	return RayFaceIntersection{Ray: ray, IntersectionDistance: t}
+}
+
+// intersectSpans returns the first boundary of the spans within
+// [ray.TMin, ray.TMax].
+func intersectSpans(ray Ray, spans []Span) (RayFaceIntersection, bool) {
+	inRange := func(i RayFaceIntersection) bool {
+		return math.Abs(float64(i.IntersectionDistance)) < math.MaxFloat32 &&
+			i.IntersectionDistance >= ray.TMin && i.IntersectionDistance <= ray.TMax
+	}
+	for _, span := range spans {
+		if inRange(span.Enter) {
+			return span.Enter, true
+		}
+		if inRange(span.Exit) {
+			return span.Exit, true
+		}
+		if span.Enter.IntersectionDistance > ray.TMax {
+			break
+		}
+	}
+	return RayFaceIntersection{}, false
+}
+
+type CSGOperation int
+
+const (
+	CSGUnion CSGOperation = iota
+	CSGIntersection
+	CSGDifference
+)
+
+// CSG combines two solids. The result is a solid too, so CSG nodes can be
+// nested to build more complex shapes.
+type CSG struct {
+	Operation CSGOperation
+	Left      Solid
+	Right     Solid
+}
+
+func CreateCSG(operation CSGOperation, left, right Solid) *CSG {
+	return &CSG{Operation: operation, Left: left, Right: right}
+}
+
+func Union(left, right Solid) *CSG {
+	return CreateCSG(CSGUnion, left, right)
+}
+
+func Intersection(left, right Solid) *CSG {
+	return CreateCSG(CSGIntersection, left, right)
+}
+
+// Difference returns left with right cut out of it.
+func Difference(left, right Solid) *CSG {
+	return CreateCSG(CSGDifference, left, right)
+}
+
+func (c *CSG) GetGeometryData() GeometryData {
+	return GeometryData{Material: c.Left.GetGeometryData().Material}
+}
+
+// SetMaterial sets the material of both operands. Surfaces keep the material
+// of the operand they come from otherwise.
+func (c *CSG) SetMaterial(material Material) {
+	c.Left.SetMaterial(material)
+	c.Right.SetMaterial(material)
+}
+
+func (c *CSG) Bounds() vec3.Box {
+	left, right := c.Left.Bounds(), c.Right.Bounds()
+	switch c.Operation {
+	case CSGIntersection:
+		return vec3.Box{Min: vec3.Max(&left.Min, &right.Min), Max: vec3.Min(&left.Max, &right.Max)}
+	case CSGDifference:
+		return left
+	}
+	return vec3.Joined(&left, &right)
+}
+
+func (c *CSG) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return intersectSpans(ray, c.Spans(ray))
+}
+
+func (c *CSG) inside(inLeft, inRight bool) bool {
+	switch c.Operation {
+	case CSGIntersection:
+		return inLeft && inRight
+	case CSGDifference:
+		return inLeft && !inRight
+	}
+	return inLeft || inRight
+}
+
+func (c *CSG) Spans(ray Ray) []Span {
+	// Walk along the boundaries of both operands in order and keep the ones
+	// where being inside the result changes
+	type boundary struct {
+		intersection RayFaceIntersection
+		left, enter  bool
+	}
+	var boundaries []boundary
+	for _, span := range c.Left.Spans(ray) {
+		boundaries = append(boundaries, boundary{span.Enter, true, true}, boundary{span.Exit, true, false})
+	}
+	for _, span := range c.Right.Spans(ray) {
+		boundaries = append(boundaries, boundary{span.Enter, false, true}, boundary{span.Exit, false, false})
+	}
+	sort.SliceStable(boundaries, func(i, j int) bool {
+		return boundaries[i].intersection.IntersectionDistance < boundaries[j].intersection.IntersectionDistance
+	})
+
+	var spans []Span
+	var current Span
+	inLeft, inRight := false, false
+	for _, b := range boundaries {
+		wasInside := c.inside(inLeft, inRight)
+		if b.left {
+			inLeft = b.enter
+		} else {
+			inRight = b.enter
+		}
+		isInside := c.inside(inLeft, inRight)
+		if wasInside == isInside {
+			continue
+		}
+
+		// The surface of the subtracted solid faces the other way
+		intersection := b.intersection
+		if c.Operation == CSGDifference && !b.left {
+			intersection.flipNormals()
+		}
+		if isInside {
+			current.Enter = intersection
+		} else {
+			current.Exit = intersection
+			spans = append(spans, current)
+		}
+	}
+	return spans
+}
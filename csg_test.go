@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+type spanDistances [2]float32
+
+func distancesOf(spans []Span) []spanDistances {
+	var distances []spanDistances
+	for _, s := range spans {
+		distances = append(distances, spanDistances{s.Enter.IntersectionDistance, s.Exit.IntersectionDistance})
+	}
+	return distances
+}
+
+func spansApproxEqual(a, b []spanDistances) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		for j := range a[i] {
+			if math.Abs(float64(a[i][j]-b[i][j])) > 1e-4 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCSGSpans(t *testing.T) {
+	// Along the X axis the left sphere covers -1 to 1, the right sphere 0 to
+	// 2 and the box 1.5 to 3
+	left := CreateSphere(1, vec3.T{0, 0, 0})
+	right := CreateSphere(1, vec3.T{1, 0, 0})
+	box := CreateBox(1.5, 1, 1, vec3.T{2.25, 0, 0})
+	ray := CreateRay(vec3.T{-5, 0, 0}, vec3.T{1, 0, 0})
+
+	tests := []struct {
+		name  string
+		solid Solid
+		want  []spanDistances
+	}{
+		{"union", Union(&left, &right), []spanDistances{{4, 7}}},
+		{"intersection", Intersection(&left, &right), []spanDistances{{5, 6}}},
+		{"difference", Difference(&left, &right), []spanDistances{{4, 5}}},
+		{"difference from the right", Difference(&right, &left), []spanDistances{{6, 7}}},
+		{"disjoint union", Union(&left, &box), []spanDistances{{4, 6}, {6.5, 8}}},
+		{"disjoint intersection", Intersection(&left, &box), nil},
+		{"nested", Difference(Union(&left, &right), &box), []spanDistances{{4, 6.5}}},
+		{"mesh", Intersection(cubeObj(), &right), []spanDistances{{5, 6}}},
+		{"hole through mesh", Difference(cubeObj(), &right), []spanDistances{{4, 5}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := distancesOf(test.solid.Spans(ray)); !spansApproxEqual(got, test.want) {
+				t.Errorf("spans %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCSGDifferenceNormals(t *testing.T) {
+	left := CreateSphere(1, vec3.T{0, 0, 0})
+	right := CreateSphere(1, vec3.T{1, 0, 0})
+	ray := CreateRay(vec3.T{-5, 0, 0}, vec3.T{1, 0, 0})
+	spans := Difference(&left, &right).Spans(ray)
+	if len(spans) != 1 {
+		t.Fatalf("%d spans, want 1", len(spans))
+	}
+	// The exit is on the subtracted sphere, whose normal has to point out
+	// of the result
+	if n := spans[0].Exit.Normal; !vecApproxEqual(n, vec3.T{1, 0, 0}, 1e-5) {
+		t.Errorf("exit normal %v, want %v", n, vec3.T{1, 0, 0})
+	}
+	if n := spans[0].Enter.Normal; !vecApproxEqual(n, vec3.T{-1, 0, 0}, 1e-5) {
+		t.Errorf("enter normal %v, want %v", n, vec3.T{-1, 0, 0})
+	}
+}
+
+func TestCSGIntersectRespectsRayInterval(t *testing.T) {
+	left := CreateSphere(1, vec3.T{0, 0, 0})
+	right := CreateSphere(1, vec3.T{1, 0, 0})
+	union := Union(&left, &right)
+
+	// Starting inside the union the first boundary is the far side
+	hit, ok := union.Intersect(CreateRay(vec3.T{0.5, 0, 0}, vec3.T{1, 0, 0}))
+	if !ok || math.Abs(float64(hit.IntersectionDistance-1.5)) > 1e-4 {
+		t.Errorf("hit %v at %v, want a hit at 1.5", ok, hit.IntersectionDistance)
+	}
+
+	ray := CreateRay(vec3.T{-5, 0, 0}, vec3.T{1, 0, 0})
+	ray.TMax = 3.9
+	if _, ok := union.Intersect(ray); ok {
+		t.Error("hit beyond TMax")
+	}
+}
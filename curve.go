@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+type CurveBasis int
+
+const (
+	// CurveBezier uses 3n+1 control points for n segments, each segment
+	// ending on the first control point of the next.
+	CurveBezier CurveBasis = iota
+	// CurveBSpline uses n+3 control points for n segments and is smooth
+	// across segments. It does not pass through its control points.
+	CurveBSpline
+)
+
+type CurveType int
+
+const (
+	// CurveRibbon is a flat strip that always faces the ray.
+	CurveRibbon CurveType = iota
+	// CurveRound is intersected like a ribbon but shaded like a tube, which
+	// is indistinguishable for curves a few pixels wide.
+	CurveRound
+)
+
+// Curve is a chain of cubic segments with a radius that is interpolated
+// between the control points, for cables, wires and hair that are too thin to
+// model with triangles. It is intersected like the curves of pbrt: the curve
+// is transformed to a space where the ray runs along +Z and then split until
+// its pieces are straight enough to be tested as line segments.
+type Curve struct {
+	ControlPoints []vec3.T
+	Radii         []float32 // one per control point, or one for the whole curve
+	Basis         CurveBasis
+	Type          CurveType
+	Material      Material
+
+	segments []curveSegment
+}
+
+// curveSegment is a single cubic Bézier segment of a curve.
+type curveSegment struct {
+	points [4]vec3.T
+	radii  [4]float32
+	bounds vec3.Box
+}
+
+// CreateCurve creates a curve. radii has one radius for every control point,
+// or a single radius for the whole curve.
+func CreateCurve(points []vec3.T, radii []float32, basis CurveBasis, curveType CurveType) (*Curve, error) {
+	c := &Curve{ControlPoints: points, Radii: radii, Basis: basis, Type: curveType}
+	if err := c.Update(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update converts the control points into segments again after they or the
+// radii were changed.
+func (c *Curve) Update() error {
+	if len(c.Radii) != 1 && len(c.Radii) != len(c.ControlPoints) {
+		return fmt.Errorf("curve has %d control points but %d radii", len(c.ControlPoints), len(c.Radii))
+	}
+	radii := c.Radii
+	if len(radii) == 1 {
+		radii = make([]float32, len(c.ControlPoints))
+		for i := range radii {
+			radii[i] = c.Radii[0]
+		}
+	}
+
+	c.segments = nil
+	if c.Basis == CurveBSpline {
+		for i := 0; i+3 < len(c.ControlPoints); i++ {
+			var segment curveSegment
+			p, r := c.ControlPoints[i:i+4], radii[i:i+4]
+			segment.points = [4]vec3.T{
+				weightedSum(p, []int{0, 1, 2}, []float32{1.0 / 6, 4.0 / 6, 1.0 / 6}),
+				weightedSum(p, []int{1, 2}, []float32{2.0 / 3, 1.0 / 3}),
+				weightedSum(p, []int{1, 2}, []float32{1.0 / 3, 2.0 / 3}),
+				weightedSum(p, []int{1, 2, 3}, []float32{1.0 / 6, 4.0 / 6, 1.0 / 6}),
+			}
+			segment.radii = [4]float32{
+				(r[0] + 4*r[1] + r[2]) / 6,
+				(2*r[1] + r[2]) / 3,
+				(r[1] + 2*r[2]) / 3,
+				(r[1] + 4*r[2] + r[3]) / 6,
+			}
+			c.segments = append(c.segments, segment)
+		}
+	} else {
+		for i := 0; i+3 < len(c.ControlPoints); i += 3 {
+			var segment curveSegment
+			copy(segment.points[:], c.ControlPoints[i:i+4])
+			copy(segment.radii[:], radii[i:i+4])
+			c.segments = append(c.segments, segment)
+		}
+	}
+
+	for i := range c.segments {
+		s := &c.segments[i]
+		maxRadius := maxOf(s.radii[:])
+		s.bounds = boundsOf(s.points[:])
+		r := vec3.T{maxRadius, maxRadius, maxRadius}
+		s.bounds.Min.Sub(&r)
+		s.bounds.Max.Add(&r)
+	}
+	return nil
+}
+
+func (c *Curve) GetGeometryData() GeometryData {
+	return GeometryData{Material: c.Material}
+}
+
+func (c *Curve) SetMaterial(material Material) {
+	c.Material = material
+}
+
+func (c *Curve) Bounds() vec3.Box {
+	if len(c.segments) == 0 {
+		return vec3.Box{}
+	}
+	box := c.segments[0].bounds
+	for _, s := range c.segments[1:] {
+		box.Join(&s.bounds)
+	}
+	return box
+}
+
+// curveHit is a hit in the ray space of one segment.
+type curveHit struct {
+	z, u, v float32
+}
+
+func (c *Curve) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	length := ray.Direction.Length()
+	if length == 0 {
+		return RayFaceIntersection{}, false
+	}
+
+	// Ray space: the ray starts at the origin and runs along +Z, distances
+	// are in world units
+	zAxis := ray.Direction.Scaled(1 / length)
+	xAxis, yAxis := coordinateSystem(zAxis)
+	toRaySpace := func(p vec3.T) vec3.T {
+		d := vec3.Sub(&p, &ray.Origin)
+		return vec3.T{vec3.Dot(&d, &xAxis), vec3.Dot(&d, &yAxis), vec3.Dot(&d, &zAxis)}
+	}
+
+	best := curveHit{z: float32(math.Min(float64(ray.TMax)*float64(length), math.MaxFloat32))}
+	bestSegment := -1
+	zMin := ray.TMin * length
+	for i, s := range c.segments {
+		if _, _, ok := ray.intersectBox(s.bounds); !ok {
+			continue
+		}
+		var points [4]vec3.T
+		for j, p := range s.points {
+			points[j] = toRaySpace(p)
+		}
+		if intersectCurveSegment(points, s.radii, 0, 1, curveDepth(points, s.radii), zMin, &best) {
+			bestSegment = i
+		}
+	}
+	if bestSegment < 0 {
+		return RayFaceIntersection{}, false
+	}
+
+	segment := c.segments[bestSegment]
+	intersection := newIntersection(ray, best.z/length)
+	_, tangent := bezierPoint(segment.points, best.u)
+	intersection.Tangent = tangent.Normalized()
+
+	// The flat normal faces the ray, round curves bend it around the tangent
+	// across their width
+	facing := ray.Direction.Scaled(-1)
+	along := intersection.Tangent.Scaled(vec3.Dot(&facing, &intersection.Tangent))
+	flat := vec3.Sub(&facing, &along)
+	flat.Normalize()
+	intersection.GeometricNormal = flat
+	intersection.Normal = flat
+	if c.Type == CurveRound {
+		angle := float64(best.v-0.5) * math.Pi
+		side := vec3.Cross(&intersection.Tangent, &flat)
+		normal := flat.Scaled(float32(math.Cos(angle)))
+		side.Scale(float32(math.Sin(angle)))
+		normal.Add(&side)
+		intersection.Normal = normal
+	}
+	intersection.TextureCoordinate = TextureCoordinate{
+		U: (float64(bestSegment) + float64(best.u)) / float64(len(c.segments)),
+		V: float64(best.v),
+	}
+	intersection.Material = c.Material
+	return intersection, true
+}
+
+// curveDepth returns how often a segment in ray space has to be split in half
+// for its pieces to be approximated by lines, see pbrt's Curve::Intersect.
+func curveDepth(points [4]vec3.T, radii [4]float32) int {
+	var l0 float32
+	for i := 0; i < 2; i++ {
+		for k := 0; k < 2; k++ {
+			d := abs32(points[i][k] - 2*points[i+1][k] + points[i+2][k])
+			l0 = float32(math.Max(float64(l0), float64(d)))
+		}
+	}
+	eps := maxOf(radii[:]) * 2 / 20
+	if eps <= 0 || l0 <= 0 {
+		return 0
+	}
+	r0 := math.Log2(math.Sqrt2*6*float64(l0)/(8*float64(eps))) / 2
+	return int(math.Max(0, math.Min(10, r0)))
+}
+
+// intersectCurveSegment intersects a segment in ray space, updating best if
+// a closer hit is found.
+func intersectCurveSegment(points [4]vec3.T, radii [4]float32, u0, u1 float32, depth int, zMin float32, best *curveHit) bool {
+	// Skip pieces whose bounds do not contain the ray
+	box := boundsOf(points[:])
+	r := maxOf(radii[:])
+	if box.Max[0]+r < 0 || box.Min[0]-r > 0 || box.Max[1]+r < 0 || box.Min[1]-r > 0 ||
+		box.Max[2]+r < zMin || box.Min[2]-r > best.z {
+		return false
+	}
+
+	if depth > 0 {
+		first, second := splitBezier(points)
+		firstRadii, secondRadii := splitBezierRadii(radii)
+		mid := (u0 + u1) / 2
+		hit := intersectCurveSegment(first, firstRadii, u0, mid, depth-1, zMin, best)
+		return intersectCurveSegment(second, secondRadii, mid, u1, depth-1, zMin, best) || hit
+	}
+
+	// The ray has to pass between the lines perpendicular to the piece at
+	// its two ends
+	edge := (points[1][1]-points[0][1])*-points[0][1] + points[0][0]*(points[0][0]-points[1][0])
+	if edge < 0 {
+		return false
+	}
+	edge = (points[2][1]-points[3][1])*-points[3][1] + points[3][0]*(points[3][0]-points[2][0])
+	if edge < 0 {
+		return false
+	}
+
+	// Closest point of the line through the ends to the ray
+	sx, sy := points[3][0]-points[0][0], points[3][1]-points[0][1]
+	denominator := sx*sx + sy*sy
+	if denominator == 0 {
+		return false
+	}
+	w := (-points[0][0]*sx - points[0][1]*sy) / denominator
+	w = float32(math.Max(0, math.Min(1, float64(w))))
+
+	point, derivative := bezierPoint(points, w)
+	radius := bezierRadius(radii, w)
+	distanceSqr := point[0]*point[0] + point[1]*point[1]
+	if distanceSqr > radius*radius || point[2] < zMin || point[2] > best.z {
+		return false
+	}
+
+	// v runs from 0 to 1 across the width of the curve
+	distance := float32(math.Sqrt(float64(distanceSqr)))
+	v := 0.5 - distance/(2*radius)
+	if derivative[0]*-point[1]+point[0]*derivative[1] > 0 {
+		v = 0.5 + distance/(2*radius)
+	}
+	*best = curveHit{z: point[2], u: u0 + w*(u1-u0), v: v}
+	return true
+}
+
+// bezierPoint evaluates a cubic Bézier curve and its derivative.
+func bezierPoint(points [4]vec3.T, t float32) (vec3.T, vec3.T) {
+	b, db := bernstein(t)
+	return weightedSum(points[:], []int{0, 1, 2, 3}, b[:]), weightedSum(points[:], []int{0, 1, 2, 3}, db[:])
+}
+
+func bezierRadius(radii [4]float32, t float32) float32 {
+	b, _ := bernstein(t)
+	return radii[0]*b[0] + radii[1]*b[1] + radii[2]*b[2] + radii[3]*b[3]
+}
+
+// splitBezier splits a cubic Bézier curve in half with de Casteljau's
+// algorithm.
+func splitBezier(p [4]vec3.T) ([4]vec3.T, [4]vec3.T) {
+	mid := func(a, b vec3.T) vec3.T {
+		return vec3.Interpolate(&a, &b, 0.5)
+	}
+	p01, p12, p23 := mid(p[0], p[1]), mid(p[1], p[2]), mid(p[2], p[3])
+	p012, p123 := mid(p01, p12), mid(p12, p23)
+	center := mid(p012, p123)
+	return [4]vec3.T{p[0], p01, p012, center}, [4]vec3.T{center, p123, p23, p[3]}
+}
+
+func splitBezierRadii(r [4]float32) ([4]float32, [4]float32) {
+	r01, r12, r23 := (r[0]+r[1])/2, (r[1]+r[2])/2, (r[2]+r[3])/2
+	r012, r123 := (r01+r12)/2, (r12+r23)/2
+	center := (r012 + r123) / 2
+	return [4]float32{r[0], r01, r012, center}, [4]float32{center, r123, r23, r[3]}
+}
+
+// coordinateSystem returns two unit vectors that are perpendicular to the
+// unit vector v and to each other.
+func coordinateSystem(v vec3.T) (vec3.T, vec3.T) {
+	var x vec3.T
+	if abs32(v[0]) > abs32(v[1]) {
+		x = vec3.T{-v[2], 0, v[0]}
+	} else {
+		x = vec3.T{0, v[2], -v[1]}
+	}
+	x.Normalize()
+	y := vec3.Cross(&v, &x)
+	return x, y
+}
+
+func maxOf(values []float32) float32 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// straightPoints are evenly spaced along X from 0 to 3.
+var straightPoints = []vec3.T{{0, 0, 0}, {1, 0, 0}, {2, 0, 0}, {3, 0, 0}}
+
+func TestCreateCurveRadii(t *testing.T) {
+	if _, err := CreateCurve(straightPoints, []float32{0.1}, CurveBezier, CurveRibbon); err != nil {
+		t.Errorf("single radius: %v", err)
+	}
+	if _, err := CreateCurve(straightPoints, []float32{0.1, 0.1, 0.1, 0.1}, CurveBSpline, CurveRibbon); err != nil {
+		t.Errorf("radius per control point: %v", err)
+	}
+	for _, radii := range [][]float32{nil, {0.1, 0.1}, {0.1, 0.1, 0.1, 0.1, 0.1}} {
+		if _, err := CreateCurve(straightPoints, radii, CurveBezier, CurveRibbon); err == nil {
+			t.Errorf("%d radii for 4 control points are accepted", len(radii))
+		}
+	}
+
+	c, err := CreateCurve(straightPoints, []float32{0.1}, CurveBezier, CurveRibbon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Radii = []float32{0.1, 0.2}
+	if err := c.Update(); err == nil {
+		t.Error("Update accepts 2 radii for 4 control points")
+	}
+}
+
+func TestCurveIntersect(t *testing.T) {
+	c, err := CreateCurve(straightPoints, []float32{0.1}, CurveBezier, CurveRibbon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin vec3.T
+		hit    bool
+		u      float64
+	}{
+		{vec3.T{1.5, 0.05, 5}, true, 0.5},
+		{vec3.T{0.3, -0.05, 5}, true, 0.1},
+		{vec3.T{1.5, 0.15, 5}, false, 0},
+		{vec3.T{3.5, 0, 5}, false, 0},
+	}
+	for _, test := range tests {
+		hit, ok := c.Intersect(CreateRay(test.origin, vec3.T{0, 0, -1}))
+		if ok != test.hit {
+			t.Errorf("ray from %v: hit %v, want %v", test.origin, ok, test.hit)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(float64(hit.IntersectionDistance-5)) > 1e-4 {
+			t.Errorf("ray from %v: distance %v, want 5", test.origin, hit.IntersectionDistance)
+		}
+		if math.Abs(hit.TextureCoordinate.U-test.u) > 1e-3 {
+			t.Errorf("ray from %v: U %v, want %v", test.origin, hit.TextureCoordinate.U, test.u)
+		}
+		if !vecApproxEqual(hit.Normal, vec3.T{0, 0, 1}, 1e-5) {
+			t.Errorf("ray from %v: normal %v, want it to face the ray", test.origin, hit.Normal)
+		}
+		if !vecApproxEqual(hit.Tangent, vec3.T{1, 0, 0}, 1e-5) {
+			t.Errorf("ray from %v: tangent %v, want %v", test.origin, hit.Tangent, vec3.T{1, 0, 0})
+		}
+	}
+}
+
+func TestBSplineCurveSkipsEnds(t *testing.T) {
+	// The only segment of a uniform B-spline with four control points runs
+	// from the second to the third
+	c, err := CreateCurve(straightPoints, []float32{0.1}, CurveBSpline, CurveRound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Intersect(CreateRay(vec3.T{0.5, 0, 5}, vec3.T{0, 0, -1})); ok {
+		t.Error("ray hits before the start of the curve")
+	}
+	hit, ok := c.Intersect(CreateRay(vec3.T{1.5, 0.05, 5}, vec3.T{0, 0, -1}))
+	if !ok {
+		t.Fatal("ray misses the curve")
+	}
+	// Round curves bend the normal across their width
+	if hit.Normal[1] <= 0 || hit.Normal[2] <= 0 {
+		t.Errorf("normal %v, want it tilted towards +Y", hit.Normal)
+	}
+}
+
+func TestHairShading(t *testing.T) {
+	intersection := RayFaceIntersection{
+		Ray:      CreateRay(vec3.T{0, 0, 5}, vec3.T{0, 0, -1}),
+		Tangent:  vec3.T{1, 0, 0},
+		Material: Material{Roughness: 0.3},
+	}
+	// Light perpendicular to the fibre is reflected most, light along it
+	// not at all
+	across := hairShading(intersection, vec3.T{0, 0, 1})
+	along := hairShading(intersection, vec3.T{1, 0, 0})
+	if across <= 1 || along > 1e-3 {
+		t.Errorf("shading %v across and %v along the fibre", across, along)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+type DecimationOptions struct {
+	// TargetFaces stops decimation once the mesh has no more than this many
+	// triangles. 0 means no limit.
+	TargetFaces int
+	// MaxError stops decimation before a collapse that would move the surface
+	// further than this from the planes of the original faces it replaces,
+	// measured as the mean squared distance to those planes, weighted by the
+	// areas of the faces. 0 means no limit.
+	MaxError float32
+	// CreaseAngle is used to generate the normals of the result, see
+	// GenerateMissingNormals.
+	CreaseAngle float64
+}
+
+// Decimate returns a triangulated copy of the mesh with fewer faces. Edges
+// are collapsed in the order of the quadric error metric of Garland and
+// Heckbert, which removes vertices where the surface is flat first. Vertices
+// on borders and on seams of texture coordinates stay where they are, so the
+// outline and texture layout of the mesh are kept. Collapses that would fold
+// faces over or make the mesh non-manifold are skipped. The normals of the
+// result are generated again from the smoothing groups of the faces.
+func (o *Obj) Decimate(options DecimationOptions) *Obj {
+	result := o.clone()
+	result.triangulate()
+	result.Normals = nil
+	for i := range result.Faces {
+		result.Faces[i].NormalIndices = nil
+	}
+	if options.TargetFaces <= 0 && options.MaxError <= 0 {
+		result.GenerateMissingNormals(options.CreaseAngle)
+		result.GenerateTangents()
+		return result
+	}
+
+	d := newDecimation(result)
+	for d.faceCount > options.TargetFaces && d.queue.Len() > 0 {
+		c := heap.Pop(&d.queue).(collapse)
+		if !d.current(c) {
+			continue
+		}
+		if options.MaxError > 0 && c.cost > float64(options.MaxError) {
+			break
+		}
+		if d.allowed(c) {
+			d.apply(c)
+		}
+	}
+	result = d.mesh()
+	result.GenerateMissingNormals(options.CreaseAngle)
+	result.GenerateTangents()
+	return result
+}
+
+// quadric is a symmetric 4x4 matrix, stored as its upper triangle, that sums
+// the squared distances of a point to a set of planes.
+type quadric [10]float64
+
+func planeQuadric(normal vec3.T, point vec3.T, weight float64) quadric {
+	a, b, c := float64(normal[0]), float64(normal[1]), float64(normal[2])
+	dd := -(a*float64(point[0]) + b*float64(point[1]) + c*float64(point[2]))
+	return quadric{
+		weight * a * a, weight * a * b, weight * a * c, weight * a * dd,
+		weight * b * b, weight * b * c, weight * b * dd,
+		weight * c * c, weight * c * dd,
+		weight * dd * dd,
+	}
+}
+
+func (q quadric) added(other quadric) quadric {
+	for i := range q {
+		q[i] += other[i]
+	}
+	return q
+}
+
+func (q quadric) error(p vec3.T) float64 {
+	x, y, z := float64(p[0]), float64(p[1]), float64(p[2])
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z +
+		q[9]
+}
+
+// minimum returns the point with the smallest error, if it is well defined.
+func (q quadric) minimum() (vec3.T, bool) {
+	det := q[0]*(q[4]*q[7]-q[5]*q[5]) - q[1]*(q[1]*q[7]-q[5]*q[2]) + q[2]*(q[1]*q[5]-q[4]*q[2])
+	scale := math.Abs(q[0]) + math.Abs(q[4]) + math.Abs(q[7])
+	if math.Abs(det) <= 1e-9*scale*scale*scale {
+		return vec3.T{}, false
+	}
+	// Cramer's rule for A p = -(q3, q6, q8)
+	bx, by, bz := -q[3], -q[6], -q[8]
+	x := (bx*(q[4]*q[7]-q[5]*q[5]) - q[1]*(by*q[7]-q[5]*bz) + q[2]*(by*q[5]-q[4]*bz)) / det
+	y := (q[0]*(by*q[7]-bz*q[5]) - bx*(q[1]*q[7]-q[5]*q[2]) + q[2]*(q[1]*bz-by*q[2])) / det
+	z := (q[0]*(q[4]*bz-q[5]*by) - q[1]*(q[1]*bz-by*q[2]) + bx*(q[1]*q[5]-q[4]*q[2])) / det
+	return vec3.T{float32(x), float32(y), float32(z)}, true
+}
+
+// collapse merges the vertex remove into keep, which is moved to target.
+type collapse struct {
+	cost           float64
+	keep, remove   int
+	target         vec3.T
+	versions       [2]int
+	interpolate    bool    // whether texture coordinates are interpolated
+	interpolationT float32 // position of target between keep and remove
+}
+
+type collapseQueue []collapse
+
+func (q collapseQueue) Len() int            { return len(q) }
+func (q collapseQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q collapseQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *collapseQueue) Push(x interface{}) { *q = append(*q, x.(collapse)) }
+func (q *collapseQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// decimation is the state of Decimate. Faces are never moved, faces that
+// collapse are only marked as removed.
+type decimation struct {
+	obj         *Obj
+	removed     []bool
+	faceCount   int
+	vertexFaces [][]int
+	quadrics    []quadric
+	weights     []float64 // sum of the areas of the faces in each quadric
+	locked      []bool
+	versions    []int
+	queue       collapseQueue
+	hasColors   bool
+}
+
+func newDecimation(o *Obj) *decimation {
+	d := &decimation{
+		obj:         o,
+		removed:     make([]bool, len(o.Faces)),
+		faceCount:   len(o.Faces),
+		vertexFaces: make([][]int, len(o.Vertices)),
+		quadrics:    make([]quadric, len(o.Vertices)),
+		weights:     make([]float64, len(o.Vertices)),
+		locked:      make([]bool, len(o.Vertices)),
+		versions:    make([]int, len(o.Vertices)),
+		hasColors:   o.hasVertexColors(),
+	}
+	for i, f := range o.Faces {
+		v := f.VertexIndices
+		cross := triangleCross(o.Vertices[v[0]], o.Vertices[v[1]], o.Vertices[v[2]])
+		area := cross.Length() / 2
+		if area > 0 {
+			cross.Scale(1 / (2 * area))
+		}
+		q := planeQuadric(cross, o.Vertices[v[0]], float64(area))
+		for _, idx := range v {
+			d.vertexFaces[idx] = append(d.vertexFaces[idx], i)
+			d.quadrics[idx] = d.quadrics[idx].added(q)
+			d.weights[idx] += float64(area)
+		}
+	}
+
+	// Lock the ends of border edges, non-manifold edges and edges across
+	// which the faces disagree on texture coordinates
+	for e, faces := range o.edgeFaces() {
+		if len(faces) != 2 || !sameTextureCoordinates(o.Faces[faces[0]], o.Faces[faces[1]], e) {
+			d.locked[e.a] = true
+			d.locked[e.b] = true
+		}
+	}
+
+	for _, e := range o.orderedEdges() {
+		d.push(e.a, e.b)
+	}
+	return d
+}
+
+// sameTextureCoordinates reports whether two faces use the same texture
+// coordinates at both ends of the edge they share.
+func sameTextureCoordinates(f, g Face, e meshEdge) bool {
+	if len(f.TextureCoordinateIndices) != len(g.TextureCoordinateIndices) {
+		return false
+	}
+	if len(f.TextureCoordinateIndices) != 3 {
+		return true
+	}
+	for _, v := range []int{e.a, e.b} {
+		if f.TextureCoordinateIndices[f.corner(v)] != g.TextureCoordinateIndices[g.corner(v)] {
+			return false
+		}
+	}
+	return true
+}
+
+func triangleCross(v0, v1, v2 vec3.T) vec3.T {
+	e1 := vec3.Sub(&v1, &v0)
+	e2 := vec3.Sub(&v2, &v0)
+	return vec3.Cross(&e1, &e2)
+}
+
+// push queues the collapse of the edge between a and b, unless both ends are
+// locked.
+func (d *decimation) push(a, b int) {
+	if d.locked[a] && d.locked[b] {
+		return
+	}
+	if d.locked[b] {
+		a, b = b, a
+	}
+	c := collapse{keep: a, remove: b, versions: [2]int{d.versions[a], d.versions[b]}}
+	q := d.quadrics[a].added(d.quadrics[b])
+	va, vb := d.obj.Vertices[a], d.obj.Vertices[b]
+	if d.locked[a] {
+		c.target = va
+	} else {
+		// The best point on the edge or, if it is well defined, anywhere
+		candidates := []vec3.T{va, vb, vec3.Interpolate(&va, &vb, 0.5)}
+		if p, ok := q.minimum(); ok {
+			candidates = append(candidates, p)
+		}
+		c.cost = math.Inf(1)
+		for _, p := range candidates {
+			if cost := q.error(p); cost < c.cost {
+				c.cost, c.target = cost, p
+			}
+		}
+		c.interpolate = true
+		edge := vec3.Sub(&vb, &va)
+		toTarget := vec3.Sub(&c.target, &va)
+		if lengthSqr := edge.LengthSqr(); lengthSqr > 0 {
+			c.interpolationT = float32(math.Max(0, math.Min(1, float64(vec3.Dot(&toTarget, &edge)/lengthSqr))))
+		}
+	}
+	// The quadrics are weighted by area, dividing by the total area turns
+	// their error into a squared distance
+	c.cost = math.Max(0, q.error(c.target))
+	if weight := d.weights[a] + d.weights[b]; weight > 0 {
+		c.cost /= weight
+	}
+	heap.Push(&d.queue, c)
+}
+
+// current reports whether neither end of the collapse changed since it was
+// queued.
+func (d *decimation) current(c collapse) bool {
+	return d.versions[c.keep] == c.versions[0] && d.versions[c.remove] == c.versions[1]
+}
+
+func (d *decimation) liveFaces(v int) []int {
+	var faces []int
+	for _, f := range d.vertexFaces[v] {
+		if !d.removed[f] {
+			faces = append(faces, f)
+		}
+	}
+	d.vertexFaces[v] = faces
+	return faces
+}
+
+// allowed checks that the collapse keeps the mesh manifold and does not flip
+// any of the faces that remain.
+func (d *decimation) allowed(c collapse) bool {
+	neighbours := func(v int) map[int]bool {
+		n := make(map[int]bool)
+		for _, f := range d.liveFaces(v) {
+			for _, u := range d.obj.Faces[f].VertexIndices {
+				if u != v {
+					n[u] = true
+				}
+			}
+		}
+		return n
+	}
+
+	// The ends of the edge may only share the vertices opposite to the edge
+	shared := 0
+	edgeFaces := 0
+	keepNeighbours := neighbours(c.keep)
+	for v := range neighbours(c.remove) {
+		if keepNeighbours[v] {
+			shared++
+		}
+	}
+	for _, f := range d.liveFaces(c.remove) {
+		if d.obj.Faces[f].corner(c.keep) >= 0 {
+			edgeFaces++
+		}
+	}
+	if edgeFaces == 0 || shared != edgeFaces {
+		return false
+	}
+
+	for _, v := range []int{c.keep, c.remove} {
+		for _, f := range d.liveFaces(v) {
+			face := d.obj.Faces[f]
+			if face.corner(c.keep) >= 0 && face.corner(c.remove) >= 0 {
+				continue
+			}
+			var corners, moved [3]vec3.T
+			for j, u := range face.VertexIndices {
+				corners[j] = d.obj.Vertices[u]
+				moved[j] = corners[j]
+				if u == v {
+					moved[j] = c.target
+				}
+			}
+			before := triangleCross(corners[0], corners[1], corners[2])
+			after := triangleCross(moved[0], moved[1], moved[2])
+			if vec3.Dot(&before, &after) <= 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (d *decimation) apply(c collapse) {
+	o := d.obj
+
+	// Texture coordinate of the merged vertex. If keep is locked, the faces of
+	// remove take the one keep has in the faces along the edge.
+	uv := -1
+	for _, f := range d.liveFaces(c.remove) {
+		face := o.Faces[f]
+		j, k := face.corner(c.keep), face.corner(c.remove)
+		if j < 0 {
+			continue
+		}
+		if len(face.TextureCoordinateIndices) == 3 {
+			uv = face.TextureCoordinateIndices[j]
+			if c.interpolate {
+				a, b := o.TextureCoordinates[uv], o.TextureCoordinates[face.TextureCoordinateIndices[k]]
+				t := float64(c.interpolationT)
+				o.TextureCoordinates = append(o.TextureCoordinates, TextureCoordinate{U: a.U + (b.U-a.U)*t, V: a.V + (b.V-a.V)*t})
+				uv = len(o.TextureCoordinates) - 1
+			}
+		}
+		break
+	}
+
+	setCorner := func(f, j int) {
+		face := &o.Faces[f]
+		if uv >= 0 && len(face.TextureCoordinateIndices) == 3 {
+			face.TextureCoordinateIndices[j] = uv
+		}
+	}
+
+	for _, f := range d.liveFaces(c.remove) {
+		face := &o.Faces[f]
+		if face.corner(c.keep) >= 0 {
+			d.removed[f] = true
+			d.faceCount--
+			continue
+		}
+		j := face.corner(c.remove)
+		face.VertexIndices[j] = c.keep
+		setCorner(f, j)
+		d.vertexFaces[c.keep] = append(d.vertexFaces[c.keep], f)
+	}
+	if c.interpolate {
+		for _, f := range d.liveFaces(c.keep) {
+			setCorner(f, o.Faces[f].corner(c.keep))
+		}
+	}
+
+	o.Vertices[c.keep] = c.target
+	if c.interpolate && d.hasColors {
+		o.VertexColors[c.keep] = vec3.Interpolate(&o.VertexColors[c.keep], &o.VertexColors[c.remove], c.interpolationT)
+	}
+	d.quadrics[c.keep] = d.quadrics[c.keep].added(d.quadrics[c.remove])
+	d.weights[c.keep] += d.weights[c.remove]
+	d.vertexFaces[c.remove] = nil
+	d.versions[c.keep]++
+	d.versions[c.remove]++
+
+	// Moving keep changes the cost of every edge of its neighbours
+	ring := []int{c.keep}
+	inRing := map[int]bool{c.keep: true}
+	for _, f := range d.liveFaces(c.keep) {
+		for _, v := range o.Faces[f].VertexIndices {
+			if !inRing[v] {
+				inRing[v] = true
+				ring = append(ring, v)
+				d.versions[v]++
+			}
+		}
+	}
+	pushed := make(map[meshEdge]bool)
+	for _, v := range ring {
+		for _, f := range d.liveFaces(v) {
+			for _, u := range o.Faces[f].VertexIndices {
+				e := newMeshEdge(u, v)
+				if u != v && !pushed[e] {
+					pushed[e] = true
+					d.push(e.a, e.b)
+				}
+			}
+		}
+	}
+}
+
+// mesh returns the remaining faces with the vertices, vertex colours and
+// texture coordinates they use.
+func (d *decimation) mesh() *Obj {
+	o := d.obj
+	result := &Obj{Origin: o.Origin, Material: o.Material}
+	vertices := make(map[int]int)
+	uvs := make(map[int]int)
+	remap := func(indices []int, used map[int]int, add func(int)) []int {
+		if len(indices) == 0 {
+			return nil
+		}
+		mapped := make([]int, len(indices))
+		for j, idx := range indices {
+			n, ok := used[idx]
+			if !ok {
+				n = len(used)
+				used[idx] = n
+				add(idx)
+			}
+			mapped[j] = n
+		}
+		return mapped
+	}
+	for i, f := range o.Faces {
+		if d.removed[i] {
+			continue
+		}
+		f.VertexIndices = remap(f.VertexIndices, vertices, func(idx int) {
+			result.Vertices = append(result.Vertices, o.Vertices[idx])
+			if d.hasColors {
+				result.VertexColors = append(result.VertexColors, o.VertexColors[idx])
+			}
+		})
+		f.TextureCoordinateIndices = remap(f.TextureCoordinateIndices, uvs, func(idx int) {
+			result.TextureCoordinates = append(result.TextureCoordinates, o.TextureCoordinates[idx])
+		})
+		result.Faces = append(result.Faces, f)
+	}
+	return result
+}
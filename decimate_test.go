@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// gridObj is an n by n grid of quads over the square from -1 to 1 in X and Y
+// with the height given by z.
+func gridObj(n int, z func(x, y float32) float32) *Obj {
+	o := &Obj{}
+	for i := 0; i <= n; i++ {
+		for j := 0; j <= n; j++ {
+			x, y := float32(j)*2/float32(n)-1, float32(i)*2/float32(n)-1
+			o.Vertices = append(o.Vertices, vec3.T{x, y, z(x, y)})
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			a := i*(n+1) + j
+			o.Faces = append(o.Faces, Face{VertexIndices: []int{a, a + 1, a + n + 2, a + n + 1}, SmoothingGroup: 1})
+		}
+	}
+	return o
+}
+
+func TestDecimateTargetFaces(t *testing.T) {
+	sphere := cubeObj().Subdivide(SubdivisionOptions{Levels: 3})
+	for _, target := range []int{400, 100, 20} {
+		result := sphere.Decimate(DecimationOptions{TargetFaces: target, CreaseAngle: DefaultCreaseAngle})
+		if len(result.Faces) > target || len(result.Faces) < target-2 {
+			t.Errorf("target %d: %d faces", target, len(result.Faces))
+		}
+		for e, faces := range result.edgeFaces() {
+			if len(faces) != 2 {
+				t.Fatalf("target %d: edge %v has %d faces", target, e, len(faces))
+			}
+		}
+		if report := result.Validate(); len(report.Problems) != 0 {
+			t.Errorf("target %d: problems %v", target, report.Problems)
+		}
+	}
+	if len(sphere.Faces) != 384 {
+		t.Errorf("the original mesh has %d faces, want 384", len(sphere.Faces))
+	}
+}
+
+func TestDecimateFlatAreasFirst(t *testing.T) {
+	flat := func(x, y float32) float32 { return 0 }
+	result := gridObj(8, flat).Decimate(DecimationOptions{MaxError: 1e-9})
+	// Only the locked border vertices are left, around as few triangles as
+	// possible
+	if len(result.Faces) > 32 {
+		t.Errorf("flat grid decimated to %d faces", len(result.Faces))
+	}
+	for i, v := range result.Vertices {
+		if v[2] != 0 {
+			t.Errorf("vertex %d at %v left the plane", i, v)
+		}
+	}
+
+	// A bump is kept while the flat area around it is removed
+	bump := func(x, y float32) float32 {
+		if x == 0 && y == 0 {
+			return 0.5
+		}
+		return 0
+	}
+	result = gridObj(8, bump).Decimate(DecimationOptions{MaxError: 1e-4})
+	if bounds := boundsOf(result.Vertices); bounds.Max[2] != 0.5 {
+		t.Errorf("the bump was flattened to %v", bounds.Max[2])
+	}
+	if len(result.Faces) > 48 {
+		t.Errorf("grid with a bump decimated to %d faces", len(result.Faces))
+	}
+}
+
+func TestDecimateMaxErrorIsSquaredDistance(t *testing.T) {
+	wave := func(x, y float32) float32 {
+		return float32(0.1 * math.Sin(3*float64(x)) * math.Cos(2*float64(y)))
+	}
+	mesh := gridObj(16, wave)
+	scaled := gridObj(16, wave)
+	for i := range scaled.Vertices {
+		scaled.Vertices[i].Scale(10)
+	}
+	// Scaling the mesh by 10 scales squared distances by 100, whatever the
+	// area of the faces
+	for _, maxError := range []float32{1e-5, 1e-4, 1e-3} {
+		a := mesh.Decimate(DecimationOptions{MaxError: maxError})
+		b := scaled.Decimate(DecimationOptions{MaxError: maxError * 100})
+		if len(a.Faces) != len(b.Faces) {
+			t.Errorf("max error %v: %d faces, but %d faces for the mesh scaled by 10", maxError, len(a.Faces), len(b.Faces))
+		}
+	}
+}
+
+func TestLODSelectsLevelBySize(t *testing.T) {
+	sphere := cubeObj().Subdivide(SubdivisionOptions{Levels: 3})
+	l := CreateLOD(sphere, 4, 0.25)
+	if len(l.Levels) != 4 {
+		t.Fatalf("%d levels, want 4", len(l.Levels))
+	}
+	for i := 1; i < len(l.Levels); i++ {
+		if len(l.Levels[i].Faces) >= len(l.Levels[i-1].Faces) {
+			t.Errorf("level %d has %d faces, level %d %d", i, len(l.Levels[i].Faces), i-1, len(l.Levels[i-1].Faces))
+		}
+	}
+
+	level := func(distance float32) int {
+		c := CreateCamera(vec3.T{0, 0, distance}, vec3.T{0, 0, -1}, vec3.T{0, 1, 0}, 60, 1, 200, 200)
+		l.SelectLevel(c)
+		return l.Level()
+	}
+	near, far := level(3), level(300)
+	if near != 0 || far != len(l.Levels)-1 {
+		t.Errorf("level %d near and %d far, want 0 and %d", near, far, len(l.Levels)-1)
+	}
+	if middle := level(30); middle < near || middle > far {
+		t.Errorf("level %d in between", middle)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// maxDerivative keeps the texture footprint finite at grazing angles.
+const maxDerivative = 1e8
+
+// computeDifferentials estimates how the point and the texture coordinate
+// of the intersection change from one pixel to the next. The differential
+// rays of the ray are intersected with the tangent plane of the surface, and
+// the offsets of the points are expressed with DpDu and DpDv. Everything is
+// left zero if the ray has no differentials or the geometry no DpDu and DpDv.
+func (i *RayFaceIntersection) computeDifferentials() {
+	i.DpDx, i.DpDy = vec3.T{}, vec3.T{}
+	i.DuvDx, i.DuvDy = TextureCoordinate{}, TextureCoordinate{}
+	ray := i.Ray
+	if !ray.HasDifferentials {
+		return
+	}
+
+	n := i.GeometricNormal
+	cosX := vec3.Dot(&n, &ray.RxDirection)
+	cosY := vec3.Dot(&n, &ray.RyDirection)
+	if cosX == 0 || cosY == 0 {
+		return
+	}
+	d := vec3.Dot(&n, &i.IntersectionPoint)
+	tx := (d - vec3.Dot(&n, &ray.RxOrigin)) / cosX
+	ty := (d - vec3.Dot(&n, &ray.RyOrigin)) / cosY
+	dx, dy := ray.RxDirection.Scaled(tx), ray.RyDirection.Scaled(ty)
+	px, py := vec3.Add(&ray.RxOrigin, &dx), vec3.Add(&ray.RyOrigin, &dy)
+	i.DpDx = vec3.Sub(&px, &i.IntersectionPoint)
+	i.DpDy = vec3.Sub(&py, &i.IntersectionPoint)
+
+	// Solve DpDx = DpDu * du + DpDv * dv by least squares, since the offset
+	// is only approximately in the plane of DpDu and DpDv
+	uu := vec3.Dot(&i.DpDu, &i.DpDu)
+	uv := vec3.Dot(&i.DpDu, &i.DpDv)
+	vv := vec3.Dot(&i.DpDv, &i.DpDv)
+	det := uu*vv - uv*uv
+	if det == 0 {
+		return
+	}
+	solve := func(dp vec3.T) TextureCoordinate {
+		a, b := vec3.Dot(&i.DpDu, &dp), vec3.Dot(&i.DpDv, &dp)
+		return TextureCoordinate{
+			U: clampDerivative((vv*a - uv*b) / det),
+			V: clampDerivative((uu*b - uv*a) / det),
+		}
+	}
+	i.DuvDx, i.DuvDy = solve(i.DpDx), solve(i.DpDy)
+}
+
+func clampDerivative(d float32) float64 {
+	return math.Max(-maxDerivative, math.Min(maxDerivative, float64(d)))
+}
+
+// normalDifferentials returns how the shading normal changes from one pixel
+// to the next.
+func (i *RayFaceIntersection) normalDifferentials() (vec3.T, vec3.T) {
+	derivative := func(duv TextureCoordinate) vec3.T {
+		du, dv := i.DnDu.Scaled(float32(duv.U)), i.DnDv.Scaled(float32(duv.V))
+		return vec3.Add(&du, &dv)
+	}
+	return derivative(i.DuvDx), derivative(i.DuvDy)
+}
+
+// Reflect returns the ray that is reflected at the intersection around the
+// shading normal. If the incoming ray has differentials, so does the
+// reflected one, which keeps textures seen in mirrors filtered.
+func (i *RayFaceIntersection) Reflect() Ray {
+	n := i.Normal.Normalized()
+	wo := i.Ray.Direction.Normalized()
+	wo.Invert()
+	wi := n.Scaled(2 * vec3.Dot(&wo, &n))
+	wi.Sub(&wo)
+
+	ray := SpawnRay(i.IntersectionPoint, i.GeometricNormal, wi)
+	if !i.Ray.HasDifferentials {
+		return ray
+	}
+	dndx, dndy := i.normalDifferentials()
+	differential := func(dp, rayDirection, dndx vec3.T) (vec3.T, vec3.T) {
+		dwo := i.directionDifferential(rayDirection, wo)
+		dDN := vec3.Dot(&dwo, &n) + vec3.Dot(&wo, &dndx)
+		// Derivative of 2 (wo . n) n - wo
+		a, b := dndx.Scaled(vec3.Dot(&wo, &n)), n.Scaled(dDN)
+		change := vec3.Add(&a, &b)
+		change.Scale(2)
+		change.Sub(&dwo)
+		// The differential rays start as far off the surface as the ray
+		return vec3.Add(&ray.Origin, &dp), vec3.Add(&wi, &change)
+	}
+	ray.HasDifferentials = true
+	ray.RxOrigin, ray.RxDirection = differential(i.DpDx, i.Ray.RxDirection, dndx)
+	ray.RyOrigin, ray.RyDirection = differential(i.DpDy, i.Ray.RyDirection, dndy)
+	return ray
+}
+
+// Refract returns the ray that is refracted into or out of the surface by
+// Snell's law with the IOR of the material, and its differentials like
+// Reflect. ok is false for total internal reflection.
+func (i *RayFaceIntersection) Refract() (Ray, bool) {
+	n := i.Normal.Normalized()
+	wo := i.Ray.Direction.Normalized()
+	wo.Invert()
+	dndx, dndy := i.normalDifferentials()
+
+	// eta is the ratio of the indices of refraction from the side of wo to
+	// the other, and n has to be on the side of wo
+	ior := i.Material.IOR
+	if ior <= 0 {
+		ior = 1
+	}
+	eta := 1 / ior
+	if vec3.Dot(&wo, &n) < 0 {
+		eta = ior
+		n.Invert()
+		dndx.Invert()
+		dndy.Invert()
+	}
+
+	cosI := vec3.Dot(&wo, &n)
+	sin2T := eta * eta * (1 - cosI*cosI)
+	if sin2T >= 1 {
+		return Ray{}, false
+	}
+	cosT := float32(math.Sqrt(float64(1 - sin2T)))
+	// wi = eta w - mu n with the incoming direction w = -wo
+	w := wo.Scaled(-1)
+	mu := eta*vec3.Dot(&w, &n) + cosT
+	wi := w.Scaled(eta)
+	offset := n.Scaled(mu)
+	wi.Sub(&offset)
+	wi.Normalize()
+
+	ray := SpawnRay(i.IntersectionPoint, i.GeometricNormal, wi)
+	if !i.Ray.HasDifferentials {
+		return ray, true
+	}
+	cosWiN := vec3.Dot(&wi, &n)
+	differential := func(dp, rayDirection, dndx vec3.T) (vec3.T, vec3.T) {
+		dwo := i.directionDifferential(rayDirection, wo)
+		dDN := vec3.Dot(&dwo, &n) + vec3.Dot(&wo, &dndx)
+		// d(w . n) is -dDN, and d(wi . n) follows from |wi| = 1
+		dmu := -(eta - eta*eta*vec3.Dot(&w, &n)/cosWiN) * dDN
+		a, b, c := dwo.Scaled(-eta), dndx.Scaled(-mu), n.Scaled(-dmu)
+		change := vec3.Add(&a, &b)
+		change.Add(&c)
+		return vec3.Add(&ray.Origin, &dp), vec3.Add(&wi, &change)
+	}
+	ray.HasDifferentials = true
+	ray.RxOrigin, ray.RxDirection = differential(i.DpDx, i.Ray.RxDirection, dndx)
+	ray.RyOrigin, ray.RyDirection = differential(i.DpDy, i.Ray.RyDirection, dndy)
+	return ray, true
+}
+
+// directionDifferential returns the change of the unit vector wo, which
+// points back along the ray, towards a differential ray.
+func (i *RayFaceIntersection) directionDifferential(rayDirection, wo vec3.T) vec3.T {
+	d := rayDirection.Normalized()
+	d.Invert()
+	return vec3.Sub(&d, &wo)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// checkerImage is a size by size checker of black and white texels.
+func checkerImage(size int) *ImageTexture {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.RGBA{255, 255, 255, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	return CreateImageTexture(img, WrapRepeat)
+}
+
+// quad is a square with the corners a, b, c and d in counterclockwise order
+// seen from its front, with U from a to b and V from a to d.
+func quad(a, b, c, d vec3.T, material Material) *Obj {
+	return &Obj{
+		Vertices:           []vec3.T{a, b, c, d},
+		TextureCoordinates: []TextureCoordinate{{U: 0, V: 0}, {U: 1, V: 0}, {U: 1, V: 1}, {U: 0, V: 1}},
+		Faces:              []Face{{VertexIndices: []int{0, 1, 2, 3}, TextureCoordinateIndices: []int{0, 1, 2, 3}}},
+		Material:           material,
+	}
+}
+
+// footprintLevel returns the level of the mip pyramid of a size by size
+// texture that trilinear filtering reads for the footprint of the
+// intersection.
+func footprintLevel(i RayFaceIntersection, size int) float64 {
+	width := math.Max(
+		math.Max(math.Abs(i.DuvDx.U), math.Abs(i.DuvDy.U)),
+		math.Max(math.Abs(i.DuvDx.V), math.Abs(i.DuvDy.V))) * float64(size)
+	return math.Log2(width)
+}
+
+func TestTrilinearSelectsMipLevel(t *testing.T) {
+	texture := checkerImage(4)
+	grey := vec4.T{0.5, 0.5, 0.5, 1}
+	uv := TextureCoordinate{U: 0.125, V: 0.875}
+	tests := []struct {
+		footprint float64
+		want      vec4.T
+	}{
+		// Footprints up to a texel read the full image
+		{0, white},
+		{0.01, white},
+		{0.25, white},
+		// Two texels read the next level, where black and white average
+		{0.5, grey},
+		{2, grey},
+		// Half way between the two
+		{0.25 * math.Sqrt2, vec4.T{0.75, 0.75, 0.75, 1}},
+	}
+	for _, test := range tests {
+		d := TextureCoordinate{U: test.footprint, V: test.footprint}
+		if got := texture.SampleFiltered(uv, d, d); !colorApproxEqual(got, test.want, 1e-4) {
+			t.Errorf("footprint %v: got %v, want %v", test.footprint, got, test.want)
+		}
+	}
+}
+
+func TestReflectedDifferentials(t *testing.T) {
+	ray := CreateRay(vec3.Zero, vec3.T{0, 0, -1})
+	ray.HasDifferentials = true
+	ray.RxDirection = vec3.T{0.001, 0, -1}
+	ray.RyDirection = vec3.T{0, 0.001, -1}
+
+	// The checker seen straight on at distance 5
+	direct := quad(vec3.T{-1, -1, -5}, vec3.T{1, -1, -5}, vec3.T{1, 1, -5}, vec3.T{-1, 1, -5}, Material{})
+	hit, ok := direct.Intersect(ray)
+	if !ok {
+		t.Fatal("ray misses the checker")
+	}
+	hit.computeDifferentials()
+	directLevel := footprintLevel(hit, 256)
+
+	// The same checker seen at distance 10 by a mirror at 45 degrees half
+	// way, which reflects the ray along +X
+	mirror := quad(vec3.T{-1, -1, -4}, vec3.T{1, -1, -6}, vec3.T{1, 1, -6}, vec3.T{-1, 1, -4}, Material{})
+	hit, ok = mirror.Intersect(ray)
+	if !ok {
+		t.Fatal("ray misses the mirror")
+	}
+	hit.computeDifferentials()
+	reflected := hit.Reflect()
+	if !reflected.HasDifferentials || !vecApproxEqual(reflected.Direction.Normalized(), vec3.T{1, 0, 0}, 1e-5) {
+		t.Fatalf("reflected ray %+v", reflected)
+	}
+	mirrored := quad(vec3.T{5, -1, -4}, vec3.T{5, -1, -6}, vec3.T{5, 1, -6}, vec3.T{5, 1, -4}, Material{})
+	hit, ok = mirrored.Intersect(reflected)
+	if !ok {
+		t.Fatal("reflected ray misses the checker")
+	}
+	hit.computeDifferentials()
+
+	// Twice the distance gives twice the footprint, one level coarser
+	if level := footprintLevel(hit, 256); math.Abs(level-directLevel-1) > 0.05 {
+		t.Errorf("mirrored checker reads level %v, seen directly %v", level, directLevel)
+	}
+}
+
+func TestTraceReflectsAndRefracts(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	wall := quad(vec3.T{4, -10, 0}, vec3.T{4, -10, -10}, vec3.T{4, 10, -10}, vec3.T{4, 10, 0}, Material{Color: red})
+	ray := CreateRay(vec3.Zero, vec3.T{0, 0, -1})
+
+	s := &Space{}
+	s.AddGeometry(wall)
+	s.AddGeometry(quad(vec3.T{-1, -1, -4}, vec3.T{1, -1, -6}, vec3.T{1, 1, -6}, vec3.T{-1, 1, -4}, Material{Reflectivity: 1}))
+	if c, ok := s.trace(ray, 0); !ok || c != red {
+		t.Errorf("mirror shows %v, want the red wall", c)
+	}
+
+	// Half transparent glass in front of the wall lets half of it through,
+	// straight on without bending it
+	s = &Space{}
+	s.AddGeometry(quad(vec3.T{-10, -10, -8}, vec3.T{10, -10, -8}, vec3.T{10, 10, -8}, vec3.T{-10, 10, -8}, Material{Color: red}))
+	s.AddGeometry(quad(vec3.T{-1, -1, -2}, vec3.T{1, -1, -2}, vec3.T{1, 1, -2}, vec3.T{-1, 1, -2}, Material{Opacity: 0.5, IOR: 1.5}))
+	if c, ok := s.trace(ray, 0); !ok || c.R < 126 || c.R > 129 || c.G != 0 {
+		t.Errorf("glass shows %v, want half of the red wall", c)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// DisplacementMap returns how far the surface is moved along its normal at a
+// texture coordinate, before scaling.
+type DisplacementMap func(uv TextureCoordinate) float32
+
+// ImageDisplacementMap samples the brightness of an image, between 0 and 1,
+// with bilinear filtering. The image repeats outside [0, 1] and V runs from
+// the bottom of the image to the top like in OBJ files.
+func ImageDisplacementMap(img image.Image) DisplacementMap {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	values := make([]float32, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+			values[y*width+x] = float32(gray.Y) / 0xffff
+		}
+	}
+
+	wrap := func(i, n int) int {
+		return ((i % n) + n) % n
+	}
+	return func(uv TextureCoordinate) float32 {
+		x := uv.U*float64(width) - 0.5
+		y := (1-uv.V)*float64(height) - 0.5
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := float32(x-x0), float32(y-y0)
+		ix, iy := int(x0), int(y0)
+		at := func(dx, dy int) float32 {
+			return values[wrap(iy+dy, height)*width+wrap(ix+dx, width)]
+		}
+		top := at(0, 0)*(1-fx) + at(1, 0)*fx
+		bottom := at(0, 1)*(1-fx) + at(1, 1)*fx
+		return top*(1-fy) + bottom*fy
+	}
+}
+
+func LoadDisplacementMap(filename string) (DisplacementMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return ImageDisplacementMap(img), nil
+}
+
+// Displace returns a copy of the mesh that is first tessellated to
+// targetEdgeLength and then has every vertex moved along its normal by the
+// displacement at its texture coordinate times scale. Where a vertex has
+// several normals or texture coordinates, for example on seams, their average
+// is used so that the surface does not tear. The normals of the result are
+// recalculated from the displaced surface.
+func (o *Obj) Displace(displacement DisplacementMap, scale, targetEdgeLength float32) *Obj {
+	result := o.Tessellate(targetEdgeLength)
+	creases := result.creaseEdges(0)
+
+	offsets := make([]float32, len(result.Vertices))
+	directions := make([]vec3.T, len(result.Vertices))
+	corners := make([]int, len(result.Vertices))
+	for _, f := range result.Faces {
+		hasUV := len(f.TextureCoordinateIndices) == len(f.VertexIndices)
+		hasNormals := len(f.NormalIndices) == len(f.VertexIndices)
+		faceNormal := polygonNormal(result.Vertices, f.VertexIndices)
+		for j, v := range f.VertexIndices {
+			normal := faceNormal
+			if hasNormals {
+				normal = result.Normals[f.NormalIndices[j]].ToVec3()
+			}
+			directions[v].Add(&normal)
+			if hasUV {
+				offsets[v] += displacement(result.TextureCoordinates[f.TextureCoordinateIndices[j]])
+			}
+			corners[v]++
+		}
+	}
+	for v := range result.Vertices {
+		if corners[v] == 0 {
+			continue
+		}
+		direction := directions[v].Normalized()
+		direction.Scale(offsets[v] / float32(corners[v]) * scale)
+		result.Vertices[v].Add(&direction)
+	}
+
+	result.generateCreasedNormals(creases)
+	result.GenerateTangents()
+	return result
+}
+
+// Tessellate returns a triangulated copy of the mesh in which no edge is
+// longer than targetEdgeLength. Long edges are split in half, and the faces
+// around them into two, three or four triangles, until all edges are short
+// enough. Since the decision only depends on the edge, neighbouring faces
+// always agree and no cracks appear. Texture coordinates, normals and vertex
+// colours are interpolated.
+func (o *Obj) Tessellate(targetEdgeLength float32) *Obj {
+	const maxPasses = 32
+
+	result := o.clone()
+	result.triangulate()
+	if targetEdgeLength <= 0 {
+		return result
+	}
+
+	hasColors := result.hasVertexColors()
+	for pass := 0; pass < maxPasses; pass++ {
+		split := make(map[meshEdge]int)
+		for _, e := range result.orderedEdges() {
+			if vec3.Distance(&result.Vertices[e.a], &result.Vertices[e.b]) > targetEdgeLength {
+				split[e] = len(result.Vertices)
+				mid := weightedSum(result.Vertices, []int{e.a, e.b}, []float32{0.5, 0.5})
+				result.Vertices = append(result.Vertices, mid)
+				if hasColors {
+					result.VertexColors = append(result.VertexColors, weightedSum(result.VertexColors, []int{e.a, e.b}, []float32{0.5, 0.5}))
+				}
+			}
+		}
+		if len(split) == 0 {
+			break
+		}
+
+		uvs := &texturePoints{obj: result, shared: make(map[meshEdge]int)}
+		normals := &normalPoints{obj: result, shared: make(map[meshEdge]int)}
+		var faces []Face
+		for _, f := range result.Faces {
+			faces = append(faces, f.splitEdges(split, uvs, normals)...)
+		}
+		result.Faces = faces
+	}
+	result.GenerateTangents()
+	return result
+}
+
+// normalPoints creates normals halfway between two others, like
+// texturePoints does for texture coordinates.
+type normalPoints struct {
+	obj    *Obj
+	shared map[meshEdge]int
+}
+
+func (n *normalPoints) midpoint(a, b int) int {
+	key := newMeshEdge(a, b)
+	if idx, ok := n.shared[key]; ok {
+		return idx
+	}
+	na, nb := n.obj.Normals[a].ToVec3(), n.obj.Normals[b].ToVec3()
+	mid := vec3.Add(&na, &nb)
+	mid.Normalize()
+	n.obj.Normals = append(n.obj.Normals, Normal{X: mid[0], Y: mid[1], Z: mid[2]})
+	idx := len(n.obj.Normals) - 1
+	n.shared[key] = idx
+	return idx
+}
+
+// splitEdges splits a triangle at the midpoints of those of its edges that
+// are in split.
+func (f Face) splitEdges(split map[meshEdge]int, uvs *texturePoints, normals *normalPoints) []Face {
+	hasUV := len(f.TextureCoordinateIndices) == 3
+	hasNormals := len(f.NormalIndices) == 3
+
+	// Corners as vertex, texture coordinate and normal indices
+	type corner [3]int
+	var corners [3]corner
+	for j := 0; j < 3; j++ {
+		corners[j][0] = f.VertexIndices[j]
+		if hasUV {
+			corners[j][1] = f.TextureCoordinateIndices[j]
+		}
+		if hasNormals {
+			corners[j][2] = f.NormalIndices[j]
+		}
+	}
+
+	// Midpoints of the edges that are split, mids[j] is on the edge from
+	// corner j to corner j+1
+	var mids [3]*corner
+	count := 0
+	for j := 0; j < 3; j++ {
+		a, b := corners[j], corners[(j+1)%3]
+		v, ok := split[newMeshEdge(a[0], b[0])]
+		if !ok {
+			continue
+		}
+		mid := corner{v, 0, 0}
+		if hasUV {
+			mid[1] = uvs.midpoint(a[1], b[1])
+		}
+		if hasNormals {
+			mid[2] = normals.midpoint(a[2], b[2])
+		}
+		mids[j] = &mid
+		count++
+	}
+
+	build := func(triangles ...[3]corner) []Face {
+		faces := make([]Face, len(triangles))
+		for i, triangle := range triangles {
+			face := f
+			face.VertexIndices = []int{triangle[0][0], triangle[1][0], triangle[2][0]}
+			face.TextureCoordinateIndices = nil
+			face.NormalIndices = nil
+			if hasUV {
+				face.TextureCoordinateIndices = []int{triangle[0][1], triangle[1][1], triangle[2][1]}
+			}
+			if hasNormals {
+				face.NormalIndices = []int{triangle[0][2], triangle[1][2], triangle[2][2]}
+			}
+			faces[i] = face
+		}
+		return faces
+	}
+
+	switch count {
+	case 0:
+		return []Face{f}
+	case 3:
+		m0, m1, m2 := *mids[0], *mids[1], *mids[2]
+		return build(
+			[3]corner{corners[0], m0, m2},
+			[3]corner{m0, corners[1], m1},
+			[3]corner{m2, m1, corners[2]},
+			[3]corner{m0, m1, m2},
+		)
+	}
+
+	// Rotate the corners so that the first edge is split, and for two split
+	// edges the second one as well
+	start := 0
+	for j := 0; j < 3; j++ {
+		if mids[j] != nil && (count == 1 || mids[(j+1)%3] != nil) {
+			start = j
+			break
+		}
+	}
+	c0, c1, c2 := corners[start], corners[(start+1)%3], corners[(start+2)%3]
+	m0 := *mids[start]
+	if count == 1 {
+		return build([3]corner{c0, m0, c2}, [3]corner{m0, c1, c2})
+	}
+	m1 := *mids[(start+1)%3]
+	return build([3]corner{m0, c1, m1}, [3]corner{c0, m0, m1}, [3]corner{c0, m1, c2})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// texturedSquare is the square of fanObj with texture coordinates from 0 to 1.
+func texturedSquare() *Obj {
+	o := fanObj()
+	for _, v := range o.Vertices {
+		o.TextureCoordinates = append(o.TextureCoordinates, TextureCoordinate{U: float64(v[0]+1) / 2, V: float64(v[1]+1) / 2})
+	}
+	for i := range o.Faces {
+		o.Faces[i].TextureCoordinateIndices = append([]int(nil), o.Faces[i].VertexIndices...)
+	}
+	return o
+}
+
+func TestTessellateEdgeLengths(t *testing.T) {
+	result := cubeObj().Tessellate(0.3)
+	for e, faces := range result.edgeFaces() {
+		if length := vec3.Distance(&result.Vertices[e.a], &result.Vertices[e.b]); length > 0.3 {
+			t.Fatalf("edge %v is %v long", e, length)
+		}
+		// Neighbouring faces split shared edges the same way
+		if len(faces) != 2 {
+			t.Fatalf("edge %v has %d faces, the mesh has cracks", e, len(faces))
+		}
+	}
+	for i, f := range result.Faces {
+		if len(f.VertexIndices) != 3 {
+			t.Fatalf("face %d has %d vertices", i, len(f.VertexIndices))
+		}
+	}
+}
+
+func TestDisplace(t *testing.T) {
+	constant := func(TextureCoordinate) float32 { return 0.5 }
+	result := texturedSquare().Displace(constant, 2, 0.5)
+	for i, v := range result.Vertices {
+		if math.Abs(float64(v[2]-1)) > 1e-5 {
+			t.Fatalf("vertex %d at %v, want it moved to z = 1", i, v)
+		}
+	}
+
+	// A slope in U tilts the square
+	slope := func(uv TextureCoordinate) float32 { return float32(uv.U) }
+	result = texturedSquare().Displace(slope, 1, 0.5)
+	for i, v := range result.Vertices {
+		if want := (v[0] + 1) / 2; math.Abs(float64(v[2]-want)) > 1e-5 {
+			t.Fatalf("vertex %d at %v, want z = %v", i, v, want)
+		}
+	}
+	for i, n := range result.Normals {
+		want := vec3.T{-1, 0, 2}
+		want.Normalize()
+		if !vecApproxEqual(n.ToVec3(), want, 1e-5) {
+			t.Fatalf("normal %d is %v, want %v", i, n, want)
+		}
+	}
+}
+
+func TestImageDisplacementMap(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.Gray{Y: 0})
+	img.Set(1, 0, color.Gray{Y: 255})
+	displacement := ImageDisplacementMap(img)
+	tests := []struct {
+		u    float64
+		want float32
+	}{
+		{0.25, 0},
+		{0.75, 1},
+		{0.5, 0.5},
+		// The image repeats
+		{1.25, 0},
+		{0, 0.5},
+	}
+	for _, test := range tests {
+		if got := displacement(TextureCoordinate{U: test.u, V: 0.5}); math.Abs(float64(got-test.want)) > 1e-5 {
+			t.Errorf("displacement at U %v is %v, want %v", test.u, got, test.want)
+		}
+	}
+}
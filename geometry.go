@@ -1,16 +1,25 @@
 package main
 
 import (
+	"image/color"
 	"math"
+	"os"
+	"path/filepath"
 
 	"github.com/thegreatdaniad/go-tracer/obj_parser"
 	"github.com/ungerik/go3d/mat3"
 	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
 )
 
 type Geometry interface {
 	GetGeometryData() GeometryData
 	SetMaterial(material Material)
+	// Intersect returns the closest intersection of the ray within
+	// [ray.TMin, ray.TMax].
+	Intersect(ray Ray) (RayFaceIntersection, bool)
+	// Bounds returns an axis aligned box that contains the whole geometry.
+	Bounds() vec3.Box
 }
 
 type GeometryData struct {
@@ -37,7 +46,10 @@ type Face struct {
 	VertexIndices            []int
 	TextureCoordinateIndices []int
 	NormalIndices            []int
-	Material                 Material
+	TangentIndices           []int     // indices into Obj.Tangents, see Obj.GenerateTangents
+	Material                 *Material // nil if the face has no material of its own
+	SmoothingGroup           int       // 0 means the face is shaded flat
+	Line                     int       // line in the OBJ file the face was read from, 0 if unknown
 }
 
 type Obj struct {
@@ -46,9 +58,52 @@ type Obj struct {
 	Normals            []Normal
 	Faces              []Face
 	Origin             vec3.T
+	// Material is used for the faces without a material of their own.
+	Material Material
+	// VertexColors holds the red, green and blue of every vertex between 0
+	// and 1, or is empty if the mesh has no vertex colours.
+	VertexColors []vec3.T
+	// Tangents holds the directions of U of the corners of the faces, with
+	// the handedness of V in W, see GenerateTangents.
+	Tangents []vec4.T
+}
+
+func (o *Obj) hasVertexColors() bool {
+	return len(o.VertexColors) > 0 && len(o.VertexColors) == len(o.Vertices)
 }
 
 func (o *Obj) Rotate(degX, degY, degZ float64) {
+	rotation := rotationMatrix(degX, degY, degZ)
+
+	for i, vertex := range o.Vertices {
+		// Translate vertex to origin
+		translated := vec3.Sub(&vertex, &o.Origin)
+
+		rotated := rotation.MulVec3(&translated)
+
+		// Translate vertex back
+		o.Vertices[i] = vec3.Add(&rotated, &o.Origin)
+	}
+
+	for i, normal := range o.Normals {
+		normalVec := vec3.T{float32(normal.X), float32(normal.Y), float32(normal.Z)}
+
+		// Apply rotations
+		rotatedNormal := rotation.MulVec3(&normalVec)
+
+		o.Normals[i] = Normal{(rotatedNormal[0]), (rotatedNormal[1]), (rotatedNormal[2])}
+	}
+
+	for i, tangent := range o.Tangents {
+		direction := tangent.Vec3()
+		rotated := rotation.MulVec3(&direction)
+		o.Tangents[i] = vec4.T{rotated[0], rotated[1], rotated[2], tangent[3]}
+	}
+}
+
+// rotationMatrix returns the rotation around the X, Y and Z axes, applied in
+// this order, by the given angles in degrees.
+func rotationMatrix(degX, degY, degZ float64) mat3.T {
 	// Convert degrees to radians
 	radX := degX * math.Pi / 180
 	radY := degY * math.Pi / 180
@@ -71,52 +126,208 @@ func (o *Obj) Rotate(degX, degY, degZ float64) {
 		vec3.T{0, 0, 1},
 	}
 
-	for i, vertex := range o.Vertices {
-		// Translate vertex to origin
-		translated := vec3.Sub(&vertex, &o.Origin)
+	rotation := rotX
+	rotation.AssignMul(&rotY, &rotation)
+	rotation.AssignMul(&rotZ, &rotation)
+	return rotation
+}
 
-		rotated := rotX.MulVec3(&translated)
-		rotated = rotY.MulVec3(&rotated)
-		rotated = rotZ.MulVec3(&rotated)
+func (o *Obj) GetGeometryData() GeometryData {
+	return GeometryData{
+		Vertices:           o.Vertices,
+		Faces:              o.Faces,
+		Material:           o.Material,
+		TextureCoordinates: o.TextureCoordinates,
+		Normals:            o.Normals,
+	}
+}
 
-		// Translate vertex back
-		o.Vertices[i] = vec3.Add(&rotated, &o.Origin)
+// SetMaterial gives every face the same material, replacing the materials
+// of the faces.
+func (o *Obj) SetMaterial(material Material) {
+	o.Material = material
+	for i := range o.Faces {
+		o.Faces[i].Material = nil
 	}
+}
 
-	for i, normal := range o.Normals {
-		normalVec := vec3.T{float32(normal.X), float32(normal.Y), float32(normal.Z)}
+// faceMaterial returns the material of a face, or the material of the mesh
+// if the face has none.
+func (o *Obj) faceMaterial(f Face) Material {
+	if f.Material != nil {
+		return *f.Material
+	}
+	return o.Material
+}
 
-		// Apply rotations
-		rotatedNormal := rotX.MulVec3(&normalVec)
-		rotatedNormal = rotY.MulVec3(&rotatedNormal)
-		rotatedNormal = rotZ.MulVec3(&rotatedNormal)
+func (o *Obj) Bounds() vec3.Box {
+	return boundsOf(o.Vertices)
+}
 
-		o.Normals[i] = Normal{(rotatedNormal[0]), (rotatedNormal[1]), (rotatedNormal[2])}
+func (o *Obj) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	var closest faceHit
+	closestFace := -1
+	for i, f := range o.Faces {
+		hit, ok := f.intersect(ray, o.Vertices)
+		if ok {
+			closest = hit
+			closestFace = i
+			ray.TMax = hit.Distance
+		}
 	}
+	if closestFace < 0 {
+		return RayFaceIntersection{}, false
+	}
+	return o.faceIntersection(ray, closestFace, closest), true
 }
 
-func (o *Obj) GetGeometryData() GeometryData {
-	return GeometryData{
-		Vertices: o.Vertices,
-		Faces:    o.Faces,
-		Normals:  o.Normals,
+// Spans treats the mesh as a closed solid whose faces point outwards, see
+// Repair to fix meshes with inconsistent winding.
+func (o *Obj) Spans(ray Ray) []Span {
+	ray.TMin, ray.TMax = -math.MaxFloat32, math.MaxFloat32
+	var hits []RayFaceIntersection
+	for i, f := range o.Faces {
+		hit, ok := f.intersect(ray, o.Vertices)
+		if ok {
+			hits = append(hits, o.faceIntersection(ray, i, hit))
+		}
 	}
+	return spansFromBoundaries(hits)
 }
-func (o *Obj) SetMaterial(material Material) {
-	for i := range o.Faces {
-		o.Faces[i].Material = material
+
+// faceIntersection completes the intersection of a ray with a face.
+func (o *Obj) faceIntersection(ray Ray, faceIndex int, hit faceHit) RayFaceIntersection {
+	f := o.Faces[faceIndex]
+	intersection := newIntersection(ray, hit.Distance)
+	intersection.Face = f
+	intersection.Material = o.faceMaterial(f)
+
+	// Corners of the triangle of the fan that was hit
+	corners := [3]int{0, hit.Triangle + 1, hit.Triangle + 2}
+	v0 := o.Vertices[f.VertexIndices[corners[0]]]
+	v1 := o.Vertices[f.VertexIndices[corners[1]]]
+	v2 := o.Vertices[f.VertexIndices[corners[2]]]
+	intersection.GeometricNormal = calculateFaceNormal(v0, v1, v2)
+
+	// Interpolate the normal using the barycentric coordinates
+	if len(f.NormalIndices) == len(f.VertexIndices) {
+		for j, corner := range corners {
+			normal := o.Normals[f.NormalIndices[corner]].ToVec3()
+			normal.Scale(hit.Barycentric[j])
+			intersection.Normal.Add(&normal)
+		}
+		intersection.Normal.Normalize()
+	} else {
+		intersection.Normal = intersection.GeometricNormal
 	}
+
+	// Interpolate the tangent too and keep it perpendicular to the normal
+	if len(f.TangentIndices) == len(f.VertexIndices) {
+		var tangent vec3.T
+		for j, corner := range corners {
+			t := o.Tangents[f.TangentIndices[corner]].Vec3()
+			t.Scale(hit.Barycentric[j])
+			tangent.Add(&t)
+		}
+		along := intersection.Normal.Scaled(vec3.Dot(&intersection.Normal, &tangent))
+		tangent.Sub(&along)
+		if !tangent.IsZero() {
+			intersection.Tangent = tangent.Normalized()
+			intersection.Bitangent = vec3.Cross(&intersection.Normal, &intersection.Tangent)
+			intersection.Bitangent.Scale(o.Tangents[f.TangentIndices[0]][3])
+		}
+	}
+
+	// Vertex colours replace the colour of materials that ask for them
+	if intersection.Material.UseVertexColors && o.hasVertexColors() {
+		b0, b1, b2 := ComputeBarycentricCoordinates(intersection.IntersectionPoint, v0, v1, v2)
+		c := weightedSum(o.VertexColors, []int{
+			f.VertexIndices[corners[0]], f.VertexIndices[corners[1]], f.VertexIndices[corners[2]],
+		}, []float32{b0, b1, b2})
+		intersection.Material.Color = color.RGBA{
+			R: uint8(clampColorComponent(c[0] * 255)),
+			G: uint8(clampColorComponent(c[1] * 255)),
+			B: uint8(clampColorComponent(c[2] * 255)),
+			A: intersection.Material.Color.A,
+		}
+	}
+
+	// Interpolate the texture coordinate the same way
+	if len(f.TextureCoordinateIndices) == len(f.VertexIndices) {
+		var uvs [3]TextureCoordinate
+		for j, corner := range corners {
+			tc := o.TextureCoordinates[f.TextureCoordinateIndices[corner]]
+			uvs[j] = tc
+			intersection.TextureCoordinate.U += tc.U * float64(hit.Barycentric[j])
+			intersection.TextureCoordinate.V += tc.V * float64(hit.Barycentric[j])
+		}
+
+		// How the point and normal change with the texture coordinate, for
+		// texture filtering
+		intersection.DpDu, intersection.DpDv, _ = triangleDerivatives([3]vec3.T{v0, v1, v2}, uvs)
+		if len(f.NormalIndices) == len(f.VertexIndices) {
+			var normals [3]vec3.T
+			for j, corner := range corners {
+				normals[j] = o.Normals[f.NormalIndices[corner]].ToVec3()
+			}
+			intersection.DnDu, intersection.DnDv, _ = triangleDerivatives(normals, uvs)
+		}
+	}
+	return intersection
+}
+
+// ObjOptions controls how an OBJ file is turned into an Obj.
+type ObjOptions struct {
+	// CreaseAngle is the angle in degrees above which the edge between two
+	// faces is treated as hard when normals have to be generated.
+	CreaseAngle float64
+	// Repair, if set, is applied to the mesh after validation. Without it a
+	// mesh with problems that would break rendering is rejected.
+	Repair *RepairOptions
+}
+
+// DefaultCreaseAngle is the crease angle used by ParseObjFile.
+const DefaultCreaseAngle = 60
+
+func DefaultObjOptions() ObjOptions {
+	return ObjOptions{CreaseAngle: DefaultCreaseAngle}
 }
 
 func ParseObjFile(filename string) (*Obj, error) {
+	o, _, err := ParseObjFileWithOptions(filename, DefaultObjOptions())
+	return o, err
+}
+
+// ParseObjFileWithOptions parses an OBJ file and validates the resulting mesh.
+// The returned report lists the problems that were found and, if
+// options.Repair is set, what was changed to fix them. Faces get the
+// materials of the MTL files the OBJ file names, libraries that do not exist
+// are skipped.
+func ParseObjFileWithOptions(filename string, options ObjOptions) (*Obj, MeshReport, error) {
 	o, err := obj_parser.ParseObjFile(filename)
 	if err != nil {
-		return nil, err
+		return nil, MeshReport{}, err
+	}
+
+	materials := make(map[string]*Material)
+	for _, library := range o.MaterialLibraries {
+		path := filepath.Join(filepath.Dir(filename), library)
+		libraryMaterials, err := LoadMaterialLibrary(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, MeshReport{}, err
+		}
+		for name := range libraryMaterials {
+			m := libraryMaterials[name]
+			materials[name] = &m
+		}
 	}
 
 	newObj := &Obj{
 		Vertices:           o.Vertices,
-		Normals:            make([]Normal, len(o.Vertices)), // Allocate space for vertex normals
+		Normals:            make([]Normal, len(o.Normals)),
 		TextureCoordinates: make([]TextureCoordinate, len(o.TextureCoordinates)),
 		Faces:              make([]Face, len(o.Faces)),
 	}
@@ -129,34 +340,16 @@ func ParseObjFile(filename string) (*Obj, error) {
 		}
 	}
 
-	// Initialize a slice to hold the accumulated normals for each vertex
-	accumulatedNormals := make([]vec3.T, len(o.Vertices))
-
-	// Initialize a counter for each vertex to count how many faces share the vertex
-	sharedFaces := make([]int, len(o.Vertices))
-
-	// Iterate over all faces to calculate face normals and accumulate them
-	for _, f := range o.Faces {
-		V1 := newObj.Vertices[f.VertexIndices[0]]
-		V2 := newObj.Vertices[f.VertexIndices[1]]
-		V3 := newObj.Vertices[f.VertexIndices[2]]
-
-		// Calculate the face normal
-		normal := calculateFaceNormal(V1, V2, V3)
-
-		// Accumulate the face normal to each vertex normal and increment the shared face count
-		for _, vIdx := range f.VertexIndices {
-			accumulatedNormals[vIdx] = *accumulatedNormals[vIdx].Add(&normal)
-			sharedFaces[vIdx]++
-		}
+	// Copy the normals given in the file
+	for i, n := range o.Normals {
+		newObj.Normals[i] = Normal{X: float32(n.X), Y: float32(n.Y), Z: float32(n.Z)}
 	}
 
-	// Average the accumulated normals by the number of shared faces and assign them to newObj.Normals
-	for i := range newObj.Normals {
-		if sharedFaces[i] > 0 {
-			accumulatedNormals[i].Scale(1.0 / float32(sharedFaces[i]))
-			accumulatedNormals[i].Normalize() // Normalize the averaged normal
-			newObj.Normals[i] = Normal{X: accumulatedNormals[i][0], Y: accumulatedNormals[i][1], Z: accumulatedNormals[i][2]}
+	// Vertex colours, the parser has already scaled them between 0 and 1
+	if len(o.VertexColors) > 0 {
+		newObj.VertexColors = make([]vec3.T, len(o.VertexColors))
+		for i, c := range o.VertexColors {
+			newObj.VertexColors[i] = vec3.T{float32(c.R), float32(c.G), float32(c.B)}
 		}
 	}
 
@@ -165,19 +358,41 @@ func ParseObjFile(filename string) (*Obj, error) {
 		newFace := Face{
 			VertexIndices:            make([]int, len(f.VertexIndices)),
 			TextureCoordinateIndices: make([]int, len(f.TextureCoordinateIndices)),
-			NormalIndices:            make([]int, len(f.VertexIndices)), // Initialize with the same length as VertexIndices
+			Material:                 materials[f.Material],
+			SmoothingGroup:           f.SmoothingGroup,
+			Line:                     f.Line,
 		}
 		copy(newFace.VertexIndices, f.VertexIndices)
 		copy(newFace.TextureCoordinateIndices, f.TextureCoordinateIndices)
 
-		// Now, assign the corresponding vertex normal indices to the face
-		for j := range newFace.VertexIndices {
-			newFace.NormalIndices[j] = newFace.VertexIndices[j]
+		// Without any "s" statement the whole mesh is one smoothing group,
+		// so only the crease angle decides which edges are hard.
+		if !o.HasSmoothingGroups {
+			newFace.SmoothingGroup = 1
+		}
+
+		// Keep the normals of the file only if every corner of the face has one
+		if len(f.NormalIndices) == len(f.VertexIndices) {
+			newFace.NormalIndices = make([]int, len(f.NormalIndices))
+			copy(newFace.NormalIndices, f.NormalIndices)
 		}
 		newObj.Faces[i] = newFace
 	}
 
-	return newObj, nil
+	// Normals are generated afterwards, so faces without them are fine here
+	report := newObj.validate(false)
+	if options.Repair != nil {
+		repairReport := newObj.Repair(*options.Repair)
+		repairReport.Problems = report.Problems
+		report = repairReport
+	} else if report.HasFatalProblems() {
+		return nil, report, &MeshError{Filename: filename, Report: report}
+	}
+
+	newObj.GenerateMissingNormals(options.CreaseAngle)
+	newObj.GenerateTangents()
+
+	return newObj, report, nil
 }
 
 // calculateFaceNormal assumes that the vertices are in counter-clockwise order
@@ -207,64 +422,160 @@ func calculateFaceNormal(V1, V2, V3 vec3.T) vec3.T {
 
 //		return [3]float32{normalVec[0], normalVec[1], normalVec[2]}
 //	}
+
+// Intersects tests the ray against the face, which is treated as a triangle
+// fan for faces with more than three vertices. Only hits inside the interval
+// [ray.TMin, ray.TMax] are reported.
 func (f Face) Intersects(ray Ray, vertices []vec3.T) (bool, float32, vec3.T) {
-	var intersectionPoint vec3.T // Declare the intersection point variable
+	hit, ok := f.intersect(ray, vertices)
+	if !ok {
+		return false, 0, vec3.T{}
+	}
+	return true, hit.Distance, ray.At(hit.Distance)
+}
+
+// faceHit describes where a ray hits a face: the distance along the ray, the
+// triangle of the fan (vertices 0, Triangle+1 and Triangle+2) and the
+// barycentric weights of those three vertices.
+type faceHit struct {
+	Distance    float32
+	Triangle    int
+	Barycentric [3]float32
+}
 
+func (f Face) intersect(ray Ray, vertices []vec3.T) (faceHit, bool) {
 	if len(f.VertexIndices) < 3 {
-		return false, 0, intersectionPoint
+		return faceHit{}, false
 	}
 
-	v0 := vertices[f.VertexIndices[0]]
-	v1 := vertices[f.VertexIndices[1]]
-	v2 := vertices[f.VertexIndices[2]]
-
-	// Edge vectors
-	e1 := vec3.Sub(&v1, &v0)
-	e2 := vec3.Sub(&v2, &v0)
+	shear, ok := ray.shear()
+	if !ok {
+		return faceHit{}, false
+	}
+	closest := faceHit{Distance: ray.TMax}
+	found := false
+	for i := 1; i+1 < len(f.VertexIndices); i++ {
+		t, b, ok := shear.intersectTriangle(ray, closest.Distance,
+			vertices[f.VertexIndices[0]], vertices[f.VertexIndices[i]], vertices[f.VertexIndices[i+1]])
+		if ok {
+			closest = faceHit{Distance: t, Triangle: i - 1, Barycentric: b}
+			found = true
+		}
+	}
+	return closest, found
+}
 
-	// Begin calculating determinant - also used to calculate u parameter
-	pvec := vec3.Cross(&ray.Direction, &e2)
+// rayShear holds the per-ray constants of the watertight ray-triangle test
+// by Woop, Benthin and Wald: the ray is transformed so that it runs along the
+// +Z axis, and the triangle is then tested in 2D with edge functions that give
+// consistent results for edges shared by neighbouring triangles.
+type rayShear struct {
+	kx, ky, kz int
+	sx, sy, sz float32
+}
 
-	// If determinant is near zero, ray lies in plane of triangle
-	det := vec3.Dot(&e1, &pvec)
+// shear returns false for rays without a direction, which hit nothing.
+func (r Ray) shear() (rayShear, bool) {
+	// Use the dimension where the ray direction is maximal as Z
+	kz := 0
+	if abs32(r.Direction[1]) > abs32(r.Direction[kz]) {
+		kz = 1
+	}
+	if abs32(r.Direction[2]) > abs32(r.Direction[kz]) {
+		kz = 2
+	}
+	kx := (kz + 1) % 3
+	ky := (kx + 1) % 3
 
-	// NOT CULLING
-	if det > -float32(math.SmallestNonzeroFloat32) && det < float32(math.SmallestNonzeroFloat32) {
-		return false, 0, intersectionPoint
+	if r.Direction[kz] == 0 {
+		return rayShear{}, false
 	}
-	invDet := 1.0 / det
 
-	// Calculate distance from V0 to ray origin
-	tvec := vec3.Sub(&ray.Origin, &v0)
+	// Swap X and Y to preserve the winding direction of triangles
+	if r.Direction[kz] < 0 {
+		kx, ky = ky, kx
+	}
 
-	// Calculate u parameter and test bound
-	u := vec3.Dot(&tvec, &pvec) * invDet
+	return rayShear{
+		kx: kx, ky: ky, kz: kz,
+		sx: r.Direction[kx] / r.Direction[kz],
+		sy: r.Direction[ky] / r.Direction[kz],
+		sz: 1 / r.Direction[kz],
+	}, true
+}
 
-	// The intersection lies outside of the triangle
-	if u < 0.0 || u > 1.0 {
-		return false, 0, intersectionPoint
+// intersectTriangle returns the distance of the hit and the barycentric
+// weights of v0, v1 and v2 if the ray hits the triangle within
+// [ray.TMin, tMax].
+func (s rayShear) intersectTriangle(ray Ray, tMax float32, v0, v1, v2 vec3.T) (float32, [3]float32, bool) {
+	var b [3]float32
+
+	// Vertices relative to the ray origin
+	a := vec3.Sub(&v0, &ray.Origin)
+	bb := vec3.Sub(&v1, &ray.Origin)
+	c := vec3.Sub(&v2, &ray.Origin)
+
+	// Shear and scale the vertices
+	ax := a[s.kx] - s.sx*a[s.kz]
+	ay := a[s.ky] - s.sy*a[s.kz]
+	bx := bb[s.kx] - s.sx*bb[s.kz]
+	by := bb[s.ky] - s.sy*bb[s.kz]
+	cx := c[s.kx] - s.sx*c[s.kz]
+	cy := c[s.ky] - s.sy*c[s.kz]
+
+	// Scaled barycentric coordinates
+	u := cx*by - cy*bx
+	v := ax*cy - ay*cx
+	w := bx*ay - by*ax
+
+	// Fall back to double precision when the ray hits an edge exactly
+	if u == 0 || v == 0 || w == 0 {
+		u = float32(float64(cx)*float64(by) - float64(cy)*float64(bx))
+		v = float32(float64(ax)*float64(cy) - float64(ay)*float64(cx))
+		w = float32(float64(bx)*float64(ay) - float64(by)*float64(ax))
 	}
 
-	// Prepare to test v parameter
-	qvec := vec3.Cross(&tvec, &e1)
+	// The ray passes outside of one of the edges
+	if (u < 0 || v < 0 || w < 0) && (u > 0 || v > 0 || w > 0) {
+		return 0, b, false
+	}
 
-	// Calculate V parameter and test bound
-	v := vec3.Dot(&ray.Direction, &qvec) * invDet
+	// The ray lies in the plane of the triangle
+	det := u + v + w
+	if det == 0 {
+		return 0, b, false
+	}
 
-	// The intersection lies outside of the triangle
-	if v < 0.0 || u+v > 1.0 {
-		return false, 0, intersectionPoint
+	// Scaled Z coordinates of the vertices give the hit distance
+	az := s.sz * a[s.kz]
+	bz := s.sz * bb[s.kz]
+	cz := s.sz * c[s.kz]
+	t := (u*az + v*bz + w*cz) / det
+	if !(t >= ray.TMin && t <= tMax) {
+		return 0, b, false
 	}
 
-	t := vec3.Dot(&e2, &qvec) * invDet
+	invDet := 1 / det
+	b[0], b[1], b[2] = u*invDet, v*invDet, w*invDet
+	return t, b, true
+}
 
-	// Calculate the exact point of intersection
-	if t > float32(math.SmallestNonzeroFloat32) {
-		rs := ray.Direction.Scaled(t)
-		ip := ray.Origin.Add(&rs)
-		intersectionPoint = *ip
-		return true, t, intersectionPoint
+func abs32(x float32) float32 {
+	if x < 0 {
+		return -x
 	}
+	return x
+}
 
-	return false, 0, intersectionPoint
+// boundsOf returns the smallest axis aligned box containing all points.
+func boundsOf(points []vec3.T) vec3.Box {
+	if len(points) == 0 {
+		return vec3.Box{}
+	}
+	box := vec3.Box{Min: points[0], Max: points[0]}
+	for _, p := range points[1:] {
+		box.Min = vec3.Min(&box.Min, &p)
+		box.Max = vec3.Max(&box.Max, &p)
+	}
+	return box
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// fanObj is a unit square in the XY plane around the origin, split into four
+// triangles that share the vertex in its centre.
+func fanObj() *Obj {
+	return &Obj{
+		Vertices: []vec3.T{{0, 0, 0}, {-1, -1, 0}, {1, -1, 0}, {1, 1, 0}, {-1, 1, 0}},
+		Faces: []Face{
+			{VertexIndices: []int{0, 1, 2}},
+			{VertexIndices: []int{0, 2, 3}},
+			{VertexIndices: []int{0, 3, 4}},
+			{VertexIndices: []int{0, 4, 1}},
+		},
+	}
+}
+
+func TestIntersectSharedEdgesAndVertices(t *testing.T) {
+	o := fanObj()
+	// Rays through the centre vertex and along the diagonals, which are the
+	// shared edges, from origins in many directions
+	for i := 0; i < 100; i++ {
+		angle := float64(i) * 2 * math.Pi / 100
+		origin := vec3.T{float32(math.Cos(angle)) * 3, float32(math.Sin(angle)) * 3, 5}
+		for _, target := range []vec3.T{{0, 0, 0}, {0.3, 0.3, 0}, {-0.7, 0.7, 0}, {0.123456, -0.123456, 0}} {
+			ray := CreateRay(origin, vec3.Sub(&target, &origin))
+			hit, ok := o.Intersect(ray)
+			if !ok {
+				t.Fatalf("ray from %v to %v misses", origin, target)
+			}
+			if !vecApproxEqual(hit.IntersectionPoint, target, 1e-5) {
+				t.Fatalf("ray from %v to %v hits %v", origin, target, hit.IntersectionPoint)
+			}
+		}
+	}
+}
+
+func TestIntersectRayInterval(t *testing.T) {
+	o := fanObj()
+	tests := []struct {
+		tMin, tMax float32
+		hit        bool
+	}{
+		{0, math.MaxFloat32, true},
+		{0, 5, true},
+		{0, 4.9, false},
+		{5, 6, true},
+		{5.1, math.MaxFloat32, false},
+	}
+	for _, test := range tests {
+		ray := CreateRay(vec3.T{0.2, 0.1, 5}, vec3.T{0, 0, -1})
+		ray.TMin, ray.TMax = test.tMin, test.tMax
+		hit, ok := o.Intersect(ray)
+		if ok != test.hit {
+			t.Errorf("[%v, %v]: hit %v, want %v", test.tMin, test.tMax, ok, test.hit)
+		}
+		if ok && hit.IntersectionDistance != 5 {
+			t.Errorf("[%v, %v]: distance %v, want 5", test.tMin, test.tMax, hit.IntersectionDistance)
+		}
+	}
+}
+
+func TestIntersectDegenerateRay(t *testing.T) {
+	// A ray without a direction hits nothing, and leaves the closest hit
+	// of a space alone
+	for _, ray := range []Ray{{}, CreateRay(vec3.T{0.2, 0.1, 5}, vec3.Zero)} {
+		if hit, ok := fanObj().Intersect(ray); ok {
+			t.Errorf("%+v: hit at distance %v", ray, hit.IntersectionDistance)
+		}
+	}
+	s := &Space{}
+	s.AddGeometry(fanObj())
+	if _, ok := s.Intersect(Ray{}); ok {
+		t.Error("ray without a direction hits the space")
+	}
+}
+
+func TestSpawnRayDoesNotHitItsSurface(t *testing.T) {
+	// Far from the origin the error of the hit point is large
+	o := fanObj()
+	for i := range o.Vertices {
+		o.Vertices[i].Scale(1000)
+		o.Vertices[i].Add(&vec3.T{5000, -3000, 0})
+	}
+	for i := 0; i < 100; i++ {
+		origin := vec3.T{5000 + float32(i)*7.3, -3000 + float32(i)*3.1, 10}
+		hit, ok := o.Intersect(CreateRay(origin, vec3.T{0.3, 0.2, -1}))
+		if !ok {
+			t.Fatalf("ray %d misses", i)
+		}
+		reflected := SpawnRay(hit.IntersectionPoint, hit.GeometricNormal, vec3.T{0.3, 0.2, 1})
+		if _, ok := o.Intersect(reflected); ok {
+			t.Fatalf("reflected ray %d hits the surface it starts on", i)
+		}
+		shadow := SpawnRayTo(hit.IntersectionPoint, hit.GeometricNormal, vec3.T{5500, -2500, 100})
+		if _, ok := o.Intersect(shadow); ok {
+			t.Fatalf("shadow ray %d hits the surface it starts on", i)
+		}
+	}
+}
+
+func TestSpawnRayToStopsBeforeTarget(t *testing.T) {
+	// The shadow ray ends on the square, which must not count as a hit
+	o := fanObj()
+	ray := SpawnRayTo(vec3.T{0, 0, 5}, vec3.T{0, 0, 1}, vec3.T{0.2, 0.3, 0})
+	if _, ok := o.Intersect(ray); ok {
+		t.Error("shadow ray hits the surface of its target")
+	}
+	ray = SpawnRayTo(vec3.T{0, 0, 5}, vec3.T{0, 0, 1}, vec3.T{0.2, 0.3, -1})
+	if _, ok := o.Intersect(ray); !ok {
+		t.Error("shadow ray misses the surface in front of its target")
+	}
+}
+
+func TestFaceMaterials(t *testing.T) {
+	red := Material{Name: "red", Color: color.RGBA{255, 0, 0, 255}}
+	o := fanObj()
+	o.Material = Material{Name: "default"}
+	o.Faces[1].Material = &red
+
+	s := &Space{}
+	s.AddGeometry(o)
+	s.AddGeometry(CreateTransformedGeometry(o, mat4.Ident))
+	tests := []struct {
+		origin vec3.T
+		want   string
+	}{
+		{vec3.T{0, -0.5, 1}, "default"},
+		{vec3.T{0.5, 0, 1}, "red"},
+		{vec3.T{0, 0.5, 1}, "default"},
+	}
+	for _, test := range tests {
+		ray := CreateRay(test.origin, vec3.T{0, 0, -1})
+		for i, g := range s.Geometries {
+			hit, ok := (*g).Intersect(ray)
+			if !ok {
+				t.Fatalf("geometry %d: ray from %v misses", i, test.origin)
+			}
+			if hit.Material.Name != test.want {
+				t.Errorf("geometry %d: ray from %v hits material %q, want %q", i, test.origin, hit.Material.Name, test.want)
+			}
+		}
+	}
+
+	// SetMaterial replaces the materials of the faces
+	o.SetMaterial(Material{Name: "blue"})
+	if hit, ok := o.Intersect(CreateRay(vec3.T{0.5, 0, 1}, vec3.T{0, 0, -1})); !ok || hit.Material.Name != "blue" {
+		t.Errorf("after SetMaterial the ray hits material %q, want %q", hit.Material.Name, "blue")
+	}
+	if m := o.GetGeometryData().Material; m.Name != "blue" {
+		t.Errorf("geometry data has material %q, want %q", m.Name, "blue")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// Heightfield is a terrain given by a grid of height samples. Sample (x, z)
+// of the grid lies at Origin + (x*SizeX/(Width-1), height, z*SizeZ/(Depth-1)).
+// Each grid cell is rendered as two triangles, and rays step through the
+// cells they cross instead of testing every triangle.
+type Heightfield struct {
+	Origin      vec3.T
+	SizeX       float32
+	SizeZ       float32
+	HeightScale float32
+	Width       int // number of samples along X
+	Depth       int // number of samples along Z
+	Heights     []float32
+	Material    Material
+
+	normals  []vec3.T  // smooth normal of every sample
+	cellMin  []float32 // lowest height of every cell
+	cellMax  []float32 // highest height of every cell
+	boundsY  [2]float32
+	cellSize [2]float32
+}
+
+// LoadHeightfield reads a grayscale image, usually a 16-bit PNG, as a
+// heightfield.
+func LoadHeightfield(filename string, origin vec3.T, sizeX, sizeZ, heightScale float32) (*Heightfield, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return CreateHeightfield(img, origin, sizeX, sizeZ, heightScale), nil
+}
+
+// CreateHeightfield creates a heightfield from the brightness of the image.
+// Black is at the height of origin and white is heightScale above it. The
+// image X axis runs along world X and the image Y axis along world Z.
+func CreateHeightfield(img image.Image, origin vec3.T, sizeX, sizeZ, heightScale float32) *Heightfield {
+	bounds := img.Bounds()
+	h := &Heightfield{
+		Origin:      origin,
+		SizeX:       sizeX,
+		SizeZ:       sizeZ,
+		HeightScale: heightScale,
+		Width:       bounds.Dx(),
+		Depth:       bounds.Dy(),
+		Heights:     make([]float32, bounds.Dx()*bounds.Dy()),
+	}
+	for z := 0; z < h.Depth; z++ {
+		for x := 0; x < h.Width; x++ {
+			gray := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+z)).(color.Gray16)
+			h.Heights[z*h.Width+x] = float32(gray.Y) / 0xffff * heightScale
+		}
+	}
+	h.Update()
+	return h
+}
+
+// Update recalculates the normals and the acceleration data after Heights
+// were changed.
+func (h *Heightfield) Update() {
+	h.cellSize = [2]float32{h.SizeX / float32(h.Width-1), h.SizeZ / float32(h.Depth-1)}
+
+	// Central differences give the gradient of the surface at each sample
+	h.normals = make([]vec3.T, len(h.Heights))
+	for z := 0; z < h.Depth; z++ {
+		for x := 0; x < h.Width; x++ {
+			x0, x1 := maxInt(x-1, 0), minInt(x+1, h.Width-1)
+			z0, z1 := maxInt(z-1, 0), minInt(z+1, h.Depth-1)
+			dx := (h.height(x1, z) - h.height(x0, z)) / (float32(x1-x0) * h.cellSize[0])
+			dz := (h.height(x, z1) - h.height(x, z0)) / (float32(z1-z0) * h.cellSize[1])
+			normal := vec3.T{-dx, 1, -dz}
+			h.normals[z*h.Width+x] = *normal.Normalize()
+		}
+	}
+
+	cells := (h.Width - 1) * (h.Depth - 1)
+	h.cellMin = make([]float32, cells)
+	h.cellMax = make([]float32, cells)
+	h.boundsY = [2]float32{float32(math.Inf(1)), float32(math.Inf(-1))}
+	for z := 0; z < h.Depth-1; z++ {
+		for x := 0; x < h.Width-1; x++ {
+			corners := [4]float32{h.height(x, z), h.height(x+1, z), h.height(x, z+1), h.height(x+1, z+1)}
+			min, max := corners[0], corners[0]
+			for _, c := range corners[1:] {
+				min = float32(math.Min(float64(min), float64(c)))
+				max = float32(math.Max(float64(max), float64(c)))
+			}
+			h.cellMin[z*(h.Width-1)+x] = min
+			h.cellMax[z*(h.Width-1)+x] = max
+			h.boundsY[0] = float32(math.Min(float64(h.boundsY[0]), float64(min)))
+			h.boundsY[1] = float32(math.Max(float64(h.boundsY[1]), float64(max)))
+		}
+	}
+}
+
+func (h *Heightfield) height(x, z int) float32 {
+	return h.Heights[z*h.Width+x]
+}
+
+// vertex returns the world position of sample (x, z).
+func (h *Heightfield) vertex(x, z int) vec3.T {
+	return vec3.T{
+		h.Origin[0] + float32(x)*h.cellSize[0],
+		h.Origin[1] + h.height(x, z),
+		h.Origin[2] + float32(z)*h.cellSize[1],
+	}
+}
+
+func (h *Heightfield) GetGeometryData() GeometryData {
+	return GeometryData{Material: h.Material}
+}
+
+func (h *Heightfield) SetMaterial(material Material) {
+	h.Material = material
+}
+
+func (h *Heightfield) Bounds() vec3.Box {
+	return vec3.Box{
+		Min: vec3.T{h.Origin[0], h.Origin[1] + h.boundsY[0], h.Origin[2]},
+		Max: vec3.T{h.Origin[0] + h.SizeX, h.Origin[1] + h.boundsY[1], h.Origin[2] + h.SizeZ},
+	}
+}
+
+func (h *Heightfield) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	if h.Width < 2 || h.Depth < 2 {
+		return RayFaceIntersection{}, false
+	}
+	tNear, tFar, ok := ray.intersectBox(h.Bounds())
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+
+	// Walk through the grid cells with a 2D DDA, starting at the cell where
+	// the ray enters the bounds
+	start := ray.At(tNear)
+	gridPos := [2]float32{(start[0] - h.Origin[0]) / h.cellSize[0], (start[2] - h.Origin[2]) / h.cellSize[1]}
+	cellCount := [2]int{h.Width - 1, h.Depth - 1}
+	direction := [2]float32{ray.Direction[0], ray.Direction[2]}
+
+	var cell, step [2]int
+	var tNext, tDelta [2]float32
+	for i := 0; i < 2; i++ {
+		cell[i] = minInt(maxInt(int(math.Floor(float64(gridPos[i]))), 0), cellCount[i]-1)
+		switch {
+		case direction[i] > 0:
+			step[i] = 1
+			tDelta[i] = h.cellSize[i] / direction[i]
+			tNext[i] = tNear + (float32(cell[i]+1)-gridPos[i])*tDelta[i]
+		case direction[i] < 0:
+			step[i] = -1
+			tDelta[i] = -h.cellSize[i] / direction[i]
+			tNext[i] = tNear + (gridPos[i]-float32(cell[i]))*tDelta[i]
+		default:
+			tDelta[i] = float32(math.Inf(1))
+			tNext[i] = float32(math.Inf(1))
+		}
+	}
+
+	shear, ok := ray.shear()
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+	t0 := tNear
+	for t0 <= tFar {
+		t1 := float32(math.Min(float64(math.Min(float64(tNext[0]), float64(tNext[1]))), float64(tFar)))
+
+		// Skip the cell if the ray passes above or below all of it, with a
+		// margin for rounding, which matters for flat cells
+		y0 := ray.Origin[1] + ray.Direction[1]*t0 - h.Origin[1]
+		y1 := ray.Origin[1] + ray.Direction[1]*t1 - h.Origin[1]
+		low, high := math.Min(float64(y0), float64(y1)), math.Max(float64(y0), float64(y1))
+		margin := RayEpsilon * math.Max(1, math.Max(math.Abs(low), math.Abs(high)))
+		cellIndex := cell[1]*cellCount[0] + cell[0]
+		if low-margin <= float64(h.cellMax[cellIndex]) && high+margin >= float64(h.cellMin[cellIndex]) {
+			if intersection, ok := h.intersectCell(ray, shear, cell[0], cell[1]); ok {
+				return intersection, true
+			}
+		}
+
+		// Advance to the next cell
+		axis := 0
+		if tNext[1] < tNext[0] {
+			axis = 1
+		}
+		cell[axis] += step[axis]
+		if cell[axis] < 0 || cell[axis] >= cellCount[axis] {
+			break
+		}
+		t0 = tNext[axis]
+		tNext[axis] += tDelta[axis]
+	}
+	return RayFaceIntersection{}, false
+}
+
+// intersectCell tests the two triangles of a grid cell.
+func (h *Heightfield) intersectCell(ray Ray, shear rayShear, x, z int) (RayFaceIntersection, bool) {
+	samples := [4][2]int{{x, z}, {x + 1, z}, {x + 1, z + 1}, {x, z + 1}}
+	triangles := [2][3]int{{0, 2, 1}, {0, 3, 2}}
+
+	tMax := ray.TMax
+	var hitSamples [3][2]int
+	var barycentric [3]float32
+	found := false
+	for _, triangle := range triangles {
+		v0 := h.vertex(samples[triangle[0]][0], samples[triangle[0]][1])
+		v1 := h.vertex(samples[triangle[1]][0], samples[triangle[1]][1])
+		v2 := h.vertex(samples[triangle[2]][0], samples[triangle[2]][1])
+		t, b, ok := shear.intersectTriangle(ray, tMax, v0, v1, v2)
+		if ok {
+			tMax = t
+			hitSamples = [3][2]int{samples[triangle[0]], samples[triangle[1]], samples[triangle[2]]}
+			barycentric = b
+			found = true
+		}
+	}
+	if !found {
+		return RayFaceIntersection{}, false
+	}
+
+	intersection := newIntersection(ray, tMax)
+	v0 := h.vertex(hitSamples[0][0], hitSamples[0][1])
+	v1 := h.vertex(hitSamples[1][0], hitSamples[1][1])
+	v2 := h.vertex(hitSamples[2][0], hitSamples[2][1])
+	intersection.GeometricNormal = calculateFaceNormal(v0, v1, v2)
+
+	// Interpolate the normals and the grid position of the samples
+	var gridX, gridZ float32
+	for i, sample := range hitSamples {
+		normal := h.normals[sample[1]*h.Width+sample[0]].Scaled(barycentric[i])
+		intersection.Normal.Add(&normal)
+		gridX += float32(sample[0]) * barycentric[i]
+		gridZ += float32(sample[1]) * barycentric[i]
+	}
+	intersection.Normal.Normalize()
+	intersection.TextureCoordinate = TextureCoordinate{
+		U: float64(gridX) / float64(h.Width-1),
+		V: float64(gridZ) / float64(h.Depth-1),
+	}
+	var uvs [3]TextureCoordinate
+	for i, sample := range hitSamples {
+		uvs[i] = TextureCoordinate{U: float64(sample[0]) / float64(h.Width-1), V: float64(sample[1]) / float64(h.Depth-1)}
+	}
+	intersection.DpDu, intersection.DpDv, _ = triangleDerivatives([3]vec3.T{v0, v1, v2}, uvs)
+	intersection.Material = h.Material
+	return intersection, true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+func TestLoadHeightfield(t *testing.T) {
+	img := image.NewGray16(image.Rect(0, 0, 4, 3))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	path := filepath.Join(t.TempDir(), "terrain.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	h, err := LoadHeightfield(path, vec3.T{-1, 1, -1}, 3, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Width != 4 || h.Depth != 3 {
+		t.Fatalf("%dx%d samples, want 4x3", h.Width, h.Depth)
+	}
+	hit, ok := h.Intersect(CreateRay(vec3.T{0.3, 20, 0.2}, vec3.T{0, -1, 0}))
+	if !ok {
+		t.Fatal("ray misses the terrain")
+	}
+	want := float32(1 + 10*float64(0x8080)/0xffff)
+	if math.Abs(float64(hit.IntersectionPoint[1]-want)) > 1e-4 {
+		t.Errorf("terrain at height %v, want %v", hit.IntersectionPoint[1], want)
+	}
+	if !vecApproxEqual(hit.Normal, vec3.T{0, 1, 0}, 1e-5) {
+		t.Errorf("normal %v, want %v", hit.Normal, vec3.T{0, 1, 0})
+	}
+}
+
+// TestHeightfieldMatchesMesh checks the grid traversal against testing every
+// triangle of the terrain.
+func TestHeightfieldMatchesMesh(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, 9, 7))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(random.Intn(256))
+	}
+	h := CreateHeightfield(img, vec3.T{-2, 0, -1}, 4, 3, 1)
+
+	mesh := &Obj{}
+	for z := 0; z < h.Depth; z++ {
+		for x := 0; x < h.Width; x++ {
+			mesh.Vertices = append(mesh.Vertices, h.vertex(x, z))
+		}
+	}
+	for z := 0; z+1 < h.Depth; z++ {
+		for x := 0; x+1 < h.Width; x++ {
+			i := z*h.Width + x
+			mesh.Faces = append(mesh.Faces,
+				Face{VertexIndices: []int{i, i + h.Width + 1, i + 1}},
+				Face{VertexIndices: []int{i, i + h.Width, i + h.Width + 1}})
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		origin := vec3.T{random.Float32()*8 - 4, random.Float32()*3 - 0.5, random.Float32()*6 - 3}
+		target := vec3.T{random.Float32()*4 - 2, random.Float32(), random.Float32()*3 - 1}
+		ray := CreateRay(origin, vec3.Sub(&target, &origin))
+		want, wantOK := mesh.Intersect(ray)
+		got, gotOK := h.Intersect(ray)
+		if gotOK != wantOK {
+			t.Fatalf("ray %d from %v to %v: hit %v, want %v", i, origin, target, gotOK, wantOK)
+		}
+		if gotOK && math.Abs(float64(got.IntersectionDistance-want.IntersectionDistance)) > 1e-4 {
+			t.Fatalf("ray %d: distance %v, want %v", i, got.IntersectionDistance, want.IntersectionDistance)
+		}
+	}
+}
+
+func TestHeightfieldTextureCoordinates(t *testing.T) {
+	h := CreateHeightfield(image.NewGray(image.Rect(0, 0, 3, 3)), vec3.T{0, 0, 0}, 2, 4, 1)
+	hit, ok := h.Intersect(CreateRay(vec3.T{0.5, 1, 3}, vec3.T{0, -1, 0}))
+	if !ok {
+		t.Fatal("ray misses the terrain")
+	}
+	if math.Abs(hit.TextureCoordinate.U-0.25) > 1e-5 || math.Abs(hit.TextureCoordinate.V-0.75) > 1e-5 {
+		t.Errorf("texture coordinate %v, want {0.25 0.75}", hit.TextureCoordinate)
+	}
+}
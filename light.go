@@ -2,6 +2,7 @@ package main
 
 import (
 	"image/color"
+	"math"
 
 	"github.com/ungerik/go3d/vec3"
 )
@@ -17,43 +18,120 @@ func CreateLight(position vec3.T, color color.RGBA, intensity, attenuation float
 	return Light{position, color, intensity, attenuation}
 }
 
-func (l Light) CalculateColorContribution(geo Geometry, point vec3.T, face Face) color.RGBA {
-	obj := geo.GetGeometryData()
-	lightDir := vec3.Sub(&l.Position, &point)
-	lightDir.Normalize()
-
-	// Retrieve the vertices of the face
-	V1 := obj.Vertices[face.VertexIndices[0]]
-	V2 := obj.Vertices[face.VertexIndices[1]]
-	V3 := obj.Vertices[face.VertexIndices[2]]
+func (l Light) CalculateColorContribution(intersection RayFaceIntersection) color.RGBA {
+	c := l.reflectedLight(intersection)
+	return color.RGBA{
+		R: uint8(clampColorComponent(c[0])),
+		G: uint8(clampColorComponent(c[1])),
+		B: uint8(clampColorComponent(c[2])),
+		A: l.Color.A,
+	}
+}
 
-	u, v, w := ComputeBarycentricCoordinates(point, V1, V2, V3)
+// reflectedLight returns the red, green and blue of the light reflected at
+// the intersection between 0 and 255, without clamping them, so that the
+// light of many dim samples can be summed without rounding it away.
+func (l Light) reflectedLight(intersection RayFaceIntersection) vec3.T {
+	lightDir := vec3.Sub(&l.Position, &intersection.IntersectionPoint)
+	lightDir.Normalize()
 
-	// Interpolate the normal using the barycentric coordinates
-	interpolatedNormal := vec3.T{
-		u*obj.Normals[face.NormalIndices[0]].X + v*obj.Normals[face.NormalIndices[1]].X + w*obj.Normals[face.NormalIndices[2]].X,
-		u*obj.Normals[face.NormalIndices[0]].Y + v*obj.Normals[face.NormalIndices[1]].Y + w*obj.Normals[face.NormalIndices[2]].Y,
-		u*obj.Normals[face.NormalIndices[0]].Z + v*obj.Normals[face.NormalIndices[1]].Z + w*obj.Normals[face.NormalIndices[2]].Z,
-	}
-	interpolatedNormal.Normalize()
+	// The shading normal, already perturbed by normal and bump maps
+	normal := intersection.Normal
 
-	// Dot product to find the cosine of the angle between the light and the interpolated normal
-	cosTheta := vec3.Dot(&interpolatedNormal, &lightDir)
+	// Dot product to find the cosine of the angle between the light and the normal
+	cosTheta := vec3.Dot(&normal, &lightDir)
 	if cosTheta < 0 {
 		cosTheta = 0
 	}
 
+	if intersection.Material.ShadingModel == ShadingHair {
+		cosTheta = hairShading(intersection, lightDir)
+	}
+	if intersection.Material.ShadingModel == ShadingPBR {
+		return l.pbrShading(intersection, normal, lightDir)
+	}
+	if intersection.Material.ShadingModel == ShadingPhong || intersection.Material.ShadingModel == ShadingBlinnPhong {
+		return l.phongShading(intersection, normal, lightDir, cosTheta)
+	}
+
 	r := float32(l.Color.R) * cosTheta * l.Intensity / l.Attenuation
 	g := float32(l.Color.G) * cosTheta * l.Intensity / l.Attenuation
 	b := float32(l.Color.B) * cosTheta * l.Intensity / l.Attenuation
-	a := float32(l.Color.A)
+	return vec3.T{r, g, b}
+}
+
+// hairShading returns the Kajiya-Kay reflectance of a fibre. Light is
+// reflected around the tangent instead of a normal, which gives the
+// highlight that runs across strands of hair.
+func hairShading(intersection RayFaceIntersection, lightDir vec3.T) float32 {
+	tangent := intersection.Tangent.Normalized()
+	viewDir := intersection.Ray.Direction.Normalized()
+	viewDir.Invert()
+
+	cosTL := vec3.Dot(&tangent, &lightDir)
+	cosTV := vec3.Dot(&tangent, &viewDir)
+	sinTL := float32(math.Sqrt(math.Max(0, 1-float64(cosTL*cosTL))))
+	sinTV := float32(math.Sqrt(math.Max(0, 1-float64(cosTV*cosTV))))
+
+	// Roughness is mapped to a Phong exponent
+	roughness := math.Max(float64(intersection.Material.Roughness), 0.01)
+	exponent := 2/(roughness*roughness) - 2
+	specular := math.Pow(math.Max(0, float64(cosTL*cosTV+sinTL*sinTV)), exponent)
+
+	return sinTL + float32(specular)
+}
+
+// pbrShading returns the light reflected by the BRDF of the material. It is
+// scaled by pi so that a white Lambert surface is as bright as with
+// ShadingLambert.
+func (l Light) pbrShading(intersection RayFaceIntersection, normal, lightDir vec3.T) vec3.T {
+	viewDir := intersection.Ray.Direction.Normalized()
+	viewDir.Invert()
+	reflected := CreateBRDF(intersection.Material).Evaluate(normal, viewDir, lightDir)
+	reflected.Scale(vec3.Dot(&normal, &lightDir) * math.Pi * l.Intensity / l.Attenuation)
+
+	return vec3.T{
+		float32(l.Color.R) * reflected[0],
+		float32(l.Color.G) * reflected[1],
+		float32(l.Color.B) * reflected[2],
+	}
+}
 
-	// Clamp the color components to the range [0, 255]
-	r = clampColorComponent(r)
-	g = clampColorComponent(g)
-	b = clampColorComponent(b)
+// phongShading returns the diffuse light scaled by Diffuse plus a highlight
+// of the Specular colour, which follows the direction the surface is seen
+// from. Shininess is the exponent that sets the size of the highlight.
+func (l Light) phongShading(intersection RayFaceIntersection, normal, lightDir vec3.T, cosTheta float32) vec3.T {
+	m := intersection.Material
+	viewDir := intersection.Ray.Direction.Normalized()
+	viewDir.Invert()
+
+	var cosSpecular float32
+	if m.ShadingModel == ShadingBlinnPhong {
+		// Angle between the normal and the half vector
+		half := vec3.Add(&lightDir, &viewDir)
+		half.Normalize()
+		cosSpecular = vec3.Dot(&normal, &half)
+	} else {
+		// Angle between the view and the reflected light
+		reflected := normal.Scaled(2 * vec3.Dot(&normal, &lightDir))
+		reflected.Sub(&lightDir)
+		cosSpecular = vec3.Dot(&reflected, &viewDir)
+	}
+	var specular float32
+	if cosTheta > 0 && cosSpecular > 0 {
+		specular = float32(math.Pow(float64(cosSpecular), math.Max(float64(m.Shininess), 1)))
+	}
 
-	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)}
+	scale := l.Intensity / l.Attenuation
+	channel := func(light, specularColor uint8) float32 {
+		reflected := m.Diffuse*cosTheta + float32(specularColor)/255*specular
+		return float32(light) * reflected * scale
+	}
+	return vec3.T{
+		channel(l.Color.R, m.Specular.R),
+		channel(l.Color.G, m.Specular.G),
+		channel(l.Color.B, m.Specular.B),
+	}
 }
 
 func clampColorComponent(value float32) float32 {
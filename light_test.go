@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// phongHit is a hit at the origin of a surface facing +Z, seen from eye.
+func phongHit(model ShadingModel, eye vec3.T, diffuse, shininess float32) RayFaceIntersection {
+	return RayFaceIntersection{
+		Ray:               CreateRay(eye, vec3.Sub(&vec3.Zero, &eye)),
+		IntersectionPoint: vec3.Zero,
+		Normal:            vec3.T{0, 0, 1},
+		GeometricNormal:   vec3.T{0, 0, 1},
+		Material: Material{
+			ShadingModel: model,
+			Diffuse:      diffuse,
+			Specular:     color.RGBA{255, 255, 255, 255},
+			Shininess:    shininess,
+		},
+	}
+}
+
+func TestPhongShading(t *testing.T) {
+	light := CreateLight(vec3.T{10, 0, 10}, color.RGBA{255, 255, 255, 255}, 1, 1)
+	mirror, above := vec3.T{-1, 0, 1}, vec3.T{0, 0, 1}
+	for _, model := range []ShadingModel{ShadingPhong, ShadingBlinnPhong} {
+		// The highlight is brightest in the mirror direction
+		if c := light.CalculateColorContribution(phongHit(model, mirror, 0, 20)); c.R < 250 {
+			t.Errorf("model %d: highlight in the mirror direction is %v", model, c)
+		}
+		if c := light.CalculateColorContribution(phongHit(model, above, 0, 20)); c.R > 128 {
+			t.Errorf("model %d: highlight away from the mirror direction is %v", model, c)
+		}
+		// Higher exponents give smaller highlights
+		wide := light.CalculateColorContribution(phongHit(model, above, 0, 2))
+		narrow := light.CalculateColorContribution(phongHit(model, above, 0, 50))
+		if wide.R <= narrow.R {
+			t.Errorf("model %d: %v with shininess 2 and %v with 50", model, wide.R, narrow.R)
+		}
+		// Diffuse light follows the cosine of the light
+		hit := phongHit(model, above, 0.5, 20)
+		hit.Material.Specular = color.RGBA{}
+		want := uint8(255 * 0.5 * math.Sqrt(0.5))
+		if c := light.CalculateColorContribution(hit); c.R != want || c.G != want || c.B != want {
+			t.Errorf("model %d: diffuse light %v, want %v", model, c, want)
+		}
+		// Light from behind the surface gives no highlight
+		behind := CreateLight(vec3.T{10, 0, -10}, color.RGBA{255, 255, 255, 255}, 1, 1)
+		if c := behind.CalculateColorContribution(phongHit(model, mirror, 0.5, 20)); c.R != 0 {
+			t.Errorf("model %d: light from behind gives %v", model, c)
+		}
+	}
+
+	// The half vector keeps the Blinn-Phong highlight wider than the Phong
+	// one for the same exponent
+	phong := light.CalculateColorContribution(phongHit(ShadingPhong, above, 0, 10))
+	blinn := light.CalculateColorContribution(phongHit(ShadingBlinnPhong, above, 0, 10))
+	if blinn.R <= phong.R {
+		t.Errorf("Blinn-Phong highlight %v, Phong %v", blinn.R, phong.R)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// DefaultFacesPerPixel is the number of faces per pixel of projected size
+// that LOD aims for.
+const DefaultFacesPerPixel = 0.5
+
+// LOD renders one of several versions of a mesh depending on how large it
+// appears in the camera, so that meshes far away are rendered with fewer
+// faces. The camera picks the level before rendering, see SelectLevel.
+type LOD struct {
+	// Levels holds the versions of the mesh, the most detailed one first.
+	Levels []*Obj
+	// FacesPerPixel is the number of faces per pixel of projected area that
+	// the selected level should at least have.
+	FacesPerPixel float32
+
+	active int
+}
+
+// CreateLOD creates levels of detail for a mesh by decimating it repeatedly,
+// each level with ratio times the faces of the one before.
+func CreateLOD(o *Obj, levels int, ratio float32) *LOD {
+	l := &LOD{Levels: []*Obj{o}, FacesPerPixel: DefaultFacesPerPixel}
+	for i := 1; i < levels; i++ {
+		previous := l.Levels[i-1]
+		target := int(float32(len(previous.Faces)) * ratio)
+		if target < 4 {
+			break
+		}
+		l.Levels = append(l.Levels, previous.Decimate(DecimationOptions{
+			TargetFaces: target,
+			CreaseAngle: DefaultCreaseAngle,
+		}))
+	}
+	return l
+}
+
+// SelectLevel picks the level with the fewest faces that still has
+// FacesPerPixel faces for every pixel the mesh covers in the camera.
+func (l *LOD) SelectLevel(c Camera) {
+	box := l.Levels[0].Bounds()
+	center := vec3.Interpolate(&box.Min, &box.Max, 0.5)
+	radius := vec3.Distance(&box.Min, &box.Max) / 2
+	size := c.ProjectedSize(center, radius)
+	wanted := l.FacesPerPixel * float32(math.Pi/4) * size * size
+
+	l.active = 0
+	for i, level := range l.Levels {
+		if float32(len(level.Faces)) >= wanted {
+			l.active = i
+		}
+	}
+}
+
+// Level returns the index of the level that is rendered.
+func (l *LOD) Level() int {
+	return l.active
+}
+
+func (l *LOD) GetGeometryData() GeometryData {
+	return l.Levels[l.active].GetGeometryData()
+}
+
+func (l *LOD) SetMaterial(material Material) {
+	for _, level := range l.Levels {
+		level.SetMaterial(material)
+	}
+}
+
+func (l *LOD) Bounds() vec3.Box {
+	box := l.Levels[0].Bounds()
+	for _, level := range l.Levels[1:] {
+		levelBox := level.Bounds()
+		box.Join(&levelBox)
+	}
+	return box
+}
+
+func (l *LOD) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return l.Levels[l.active].Intersect(ray)
+}
@@ -1,11 +1,229 @@
 package main
 
-import "image/color"
+import (
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/thegreatdaniad/go-tracer/obj_parser"
+)
 
 type Material struct {
+	Name         string
 	Color        color.RGBA
 	Reflectivity float32
 	Opacity      float32
 	Diffuse      float32
 	Roughness    float32
+	Metallic     float32 // 1 for metals, used by ShadingPBR
+	ShadingModel ShadingModel
+	// UseVertexColors makes meshes with vertex colours use them instead of
+	// Color.
+	UseVertexColors bool
+
+	Specular     color.RGBA
+	Shininess    float32 // exponent of the specular highlight
+	Emission     color.RGBA
+	IOR          float32 // index of refraction
+	Illumination int     // illumination model of MTL files
+	Maps         MaterialMaps
+
+	// EmissionStrength scales Emission. Meshes with emissive materials
+	// light the scene as area lights, see AreaLight.
+	EmissionStrength float32
+
+	// Textures are evaluated at every hit and replace the parameters, see
+	// RayFaceIntersection.applyTextures. ColorTexture, SpecularTexture and
+	// EmissionTexture tint their colour, or are used as the colour when it
+	// is left at zero, so Material{ColorTexture: t} shows t as it is.
+	ColorTexture        Texture
+	SpecularTexture     Texture
+	EmissionTexture     Texture
+	ReflectivityTexture Texture
+	OpacityTexture      Texture
+	DiffuseTexture      Texture
+	RoughnessTexture    Texture
+	MetallicTexture     Texture
+	ShininessTexture    Texture
+
+	// NormalMap holds tangent space normals with X along U, Y along V and Z
+	// away from the surface. BumpMap holds heights in its red channel, which
+	// tilt the normal by BumpScale times their slope. Both replace the
+	// shading normal, see RayFaceIntersection.perturbNormal.
+	NormalMap Texture
+	BumpMap   Texture
+	BumpScale float32
+
+	// Shader, if set, replaces the parameters it outputs before the
+	// textures are applied.
+	Shader *ShaderGraph
 }
+
+// MaterialMaps holds the file names of the textures of a material.
+type MaterialMaps struct {
+	Ambient      string
+	Diffuse      string
+	Specular     string
+	Emission     string
+	Shininess    string
+	Opacity      string
+	Bump         string
+	Normal       string
+	Displacement string
+	Metallic     string
+	Roughness    string
+}
+
+// DefaultBumpScale is the BumpScale of materials of MTL files, where white in
+// the bump map is this fraction of the texture high.
+const DefaultBumpScale = 0.01
+
+// LoadMaterialLibrary reads the materials of an MTL file by name. The file
+// names of their textures are made relative to the working directory, and
+// the colour, specular, emission, opacity, normal, bump, metallic and
+// roughness textures are loaded if they exist.
+func LoadMaterialLibrary(filename string) (map[string]Material, error) {
+	parsed, err := obj_parser.ParseMtlFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filename)
+	path := func(name string) string {
+		if name == "" || filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, name)
+	}
+
+	// Materials often share textures, load each file once
+	textures := make(map[string]*ImageTexture)
+	load := func(name string) (*ImageTexture, error) {
+		if name == "" {
+			return nil, nil
+		}
+		if t, ok := textures[name]; ok {
+			return t, nil
+		}
+		t, err := LoadImageTexture(name, WrapRepeat)
+		if os.IsNotExist(err) {
+			// Like with missing libraries, the material is used without it
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		textures[name] = t
+		return t, nil
+	}
+
+	materials := make(map[string]Material, len(parsed))
+	for _, m := range parsed {
+		material := Material{
+			Name:    m.Name,
+			Color:   mtlColor(m.Diffuse),
+			Opacity: float32(m.Dissolve),
+			Diffuse: 1,
+			// Roughness that gives about the same highlight as the Phong
+			// exponent
+			Roughness:    float32(math.Sqrt(2 / (math.Max(m.Shininess, 0) + 2))),
+			Specular:     mtlColor(m.Specular),
+			Shininess:    float32(m.Shininess),
+			Emission:     mtlColor(m.Emission),
+			IOR:          float32(m.IOR),
+			Illumination: m.Illumination,
+			BumpScale:    DefaultBumpScale * float32(m.BumpMultiplier),
+			Maps: MaterialMaps{
+				Ambient:      path(m.AmbientMap),
+				Diffuse:      path(m.DiffuseMap),
+				Specular:     path(m.SpecularMap),
+				Emission:     path(m.EmissionMap),
+				Shininess:    path(m.ShininessMap),
+				Opacity:      path(m.DissolveMap),
+				Bump:         path(m.BumpMap),
+				Normal:       path(m.NormalMap),
+				Displacement: path(m.DisplacementMap),
+				Metallic:     path(m.MetallicMap),
+				Roughness:    path(m.RoughnessMap),
+			},
+			// Ke is the emitted colour at full strength
+			EmissionStrength: 1,
+		}
+		// Illumination models 2 and up have highlights, and the PBR
+		// extension of MTL asks for the physically based model
+		if m.Illumination >= 2 {
+			material.ShadingModel = ShadingBlinnPhong
+		}
+		if m.Roughness >= 0 {
+			material.Roughness = float32(m.Roughness)
+			material.Metallic = float32(m.Metallic)
+			material.ShadingModel = ShadingPBR
+		}
+		for _, t := range []struct {
+			file    string
+			texture *Texture
+		}{
+			{material.Maps.Diffuse, &material.ColorTexture},
+			{material.Maps.Specular, &material.SpecularTexture},
+			{material.Maps.Emission, &material.EmissionTexture},
+			{material.Maps.Opacity, &material.OpacityTexture},
+			{material.Maps.Normal, &material.NormalMap},
+			{material.Maps.Bump, &material.BumpMap},
+			{material.Maps.Metallic, &material.MetallicTexture},
+			{material.Maps.Roughness, &material.RoughnessTexture},
+		} {
+			texture, err := load(t.file)
+			if err != nil {
+				return nil, err
+			}
+			if texture != nil {
+				*t.texture = texture
+			}
+		}
+		materials[m.Name] = material
+	}
+	return materials, nil
+}
+
+// IsEmissive reports whether the material gives off light.
+func (m Material) IsEmissive() bool {
+	return m.EmissionStrength > 0 && (m.Emission.R > 0 || m.Emission.G > 0 || m.Emission.B > 0)
+}
+
+// EmittedColor returns the colour of the light the material gives off.
+func (m Material) EmittedColor() color.RGBA {
+	scale := func(c uint8) uint8 {
+		return uint8(clampColorComponent(float32(c) * m.EmissionStrength))
+	}
+	return color.RGBA{R: scale(m.Emission.R), G: scale(m.Emission.G), B: scale(m.Emission.B), A: 255}
+}
+
+func mtlColor(c obj_parser.Color) color.RGBA {
+	return color.RGBA{
+		R: uint8(clampColorComponent(float32(c.R * 255))),
+		G: uint8(clampColorComponent(float32(c.G * 255))),
+		B: uint8(clampColorComponent(float32(c.B * 255))),
+		A: 255,
+	}
+}
+
+type ShadingModel int
+
+const (
+	ShadingLambert ShadingModel = iota
+	// ShadingHair lights fibres by their tangent with the Kajiya-Kay model.
+	// Roughness controls the width of the highlight.
+	ShadingHair
+	// ShadingPBR lights surfaces with the metallic-roughness BRDF of glTF,
+	// see BRDF. Color is the base colour and Diffuse weights the diffuse
+	// lobe.
+	ShadingPBR
+	// ShadingPhong adds a highlight of the Specular colour to the diffuse
+	// light, which is scaled by Diffuse. Shininess is the exponent of the
+	// highlight.
+	ShadingPhong
+	// ShadingBlinnPhong is ShadingPhong with the highlight computed from the
+	// half vector between light and view, which is wider and stays round at
+	// grazing angles.
+	ShadingBlinnPhong
+)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestPNG writes a w by h image of colour c to path.
+func writeTestPNG(t *testing.T, path string, w, h int, c color.RGBA) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadMaterialLibrary(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "textures"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(dir, "textures", "wood grain.png"), 2, 2, color.RGBA{200, 100, 0, 255})
+	mtl := filepath.Join(dir, "test.mtl")
+	content := `newmtl wood
+Kd 1 0.5 0
+Ks 0.2 0.2 0.2
+Ns 98
+illum 2
+map_Kd textures/wood grain.png
+map_Ks missing.png
+newmtl metal
+Kd 0.8 0.8 0.8
+Pr 0.3
+Pm 1
+newmtl lamp
+Ke 1 1 0.5
+`
+	if err := os.WriteFile(mtl, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	materials, err := LoadMaterialLibrary(mtl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(materials) != 3 {
+		t.Fatalf("%d materials, want 3", len(materials))
+	}
+
+	wood := materials["wood"]
+	if wood.Color != (color.RGBA{255, 127, 0, 255}) || wood.Shininess != 98 || wood.ShadingModel != ShadingBlinnPhong {
+		t.Errorf("wood has colour %v, shininess %v and shading model %d", wood.Color, wood.Shininess, wood.ShadingModel)
+	}
+	if want := filepath.Join(dir, "textures", "wood grain.png"); wood.Maps.Diffuse != want {
+		t.Errorf("diffuse map %q, want %q", wood.Maps.Diffuse, want)
+	}
+	if wood.ColorTexture == nil {
+		t.Error("colour texture is not loaded")
+	}
+	// Missing textures are left out
+	if wood.SpecularTexture != nil {
+		t.Error("missing specular texture is loaded")
+	}
+
+	metal := materials["metal"]
+	if metal.ShadingModel != ShadingPBR || metal.Roughness != 0.3 || metal.Metallic != 1 {
+		t.Errorf("metal has shading model %d, roughness %v and metallic %v", metal.ShadingModel, metal.Roughness, metal.Metallic)
+	}
+	if lamp := materials["lamp"]; !lamp.IsEmissive() || lamp.Emission != (color.RGBA{255, 255, 127, 255}) {
+		t.Errorf("lamp emits %v", lamp.Emission)
+	}
+}
+
+func TestParseObjFileMaterials(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"colors.mtl": "newmtl red\nKd 1 0 0\nnewmtl green\nKd 0 1 0\n",
+		"mesh.obj": `mtllib colors.mtl missing.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+usemtl red
+f 1 2 3
+usemtl green
+f 1 2 3
+usemtl unknown
+f 1 2 3
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o, err := ParseObjFile(filepath.Join(dir, "mesh.obj"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "red", "green", ""}
+	for i, f := range o.Faces {
+		name := ""
+		if f.Material != nil {
+			name = f.Material.Name
+		}
+		if name != want[i] {
+			t.Errorf("face %d has material %q, want %q", i, name, want[i])
+		}
+	}
+	if o.Faces[1].Material != nil && o.Faces[1].Material.Color != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("red has colour %v", o.Faces[1].Material.Color)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+type MeshProblemKind int
+
+const (
+	VertexIndexOutOfRange MeshProblemKind = iota
+	TextureCoordinateIndexOutOfRange
+	NormalIndexOutOfRange
+	TooFewVertices
+	ZeroAreaFace
+	MissingNormals
+)
+
+func (k MeshProblemKind) String() string {
+	switch k {
+	case VertexIndexOutOfRange:
+		return "vertex index out of range"
+	case TextureCoordinateIndexOutOfRange:
+		return "texture coordinate index out of range"
+	case NormalIndexOutOfRange:
+		return "normal index out of range"
+	case TooFewVertices:
+		return "face has fewer than three vertices"
+	case ZeroAreaFace:
+		return "face has zero area"
+	case MissingNormals:
+		return "face has no normal for every vertex"
+	}
+	return "unknown problem"
+}
+
+// Fatal reports whether a problem of this kind breaks rendering. Zero area
+// faces are merely never hit.
+func (k MeshProblemKind) Fatal() bool {
+	return k != ZeroAreaFace
+}
+
+type MeshProblem struct {
+	Kind    MeshProblemKind
+	Face    int // index into Obj.Faces
+	Line    int // line in the OBJ file, 0 if unknown
+	Message string
+}
+
+func (p MeshProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return fmt.Sprintf("face %d: %s", p.Face, p.Message)
+}
+
+// MeshReport lists the problems found in a mesh and what Repair changed.
+type MeshReport struct {
+	Problems       []MeshProblem
+	DroppedFaces   int
+	ClearedIndices int // faces whose texture coordinate or normal indices were removed
+	WeldedVertices int
+	FlippedFaces   int
+}
+
+func (r MeshReport) HasFatalProblems() bool {
+	for _, p := range r.Problems {
+		if p.Kind.Fatal() {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed reports whether Repair modified the mesh.
+func (r MeshReport) Changed() bool {
+	return r.DroppedFaces > 0 || r.ClearedIndices > 0 || r.WeldedVertices > 0 || r.FlippedFaces > 0
+}
+
+// MeshError is returned when a mesh cannot be rendered as it is.
+type MeshError struct {
+	Filename string
+	Report   MeshReport
+}
+
+func (e *MeshError) Error() string {
+	const maxListed = 5
+	var fatal []string
+	for _, p := range e.Report.Problems {
+		if p.Kind.Fatal() {
+			fatal = append(fatal, p.String())
+		}
+	}
+	msg := fmt.Sprintf("%s: invalid mesh with %d problems", e.Filename, len(fatal))
+	if len(fatal) > maxListed {
+		return msg + ": " + strings.Join(fatal[:maxListed], "; ") + "; ..."
+	}
+	return msg + ": " + strings.Join(fatal, "; ")
+}
+
+type RepairOptions struct {
+	// DropDegenerateFaces removes faces with zero area. Faces with too few
+	// vertices or vertex indices out of range are always removed.
+	DropDegenerateFaces bool
+	// WeldVertices merges vertices closer than WeldTolerance to each other.
+	WeldVertices  bool
+	WeldTolerance float32
+	// FixWinding flips faces so that neighbouring faces agree on their
+	// orientation, and closed meshes face outwards.
+	FixWinding bool
+}
+
+func DefaultRepairOptions() RepairOptions {
+	return RepairOptions{
+		DropDegenerateFaces: true,
+		WeldVertices:        true,
+		WeldTolerance:       1e-6,
+		FixWinding:          true,
+	}
+}
+
+// degenerateAreaRatio is the ratio between the area of a face and the square
+// of its longest edge below which the face counts as having zero area.
+const degenerateAreaRatio = 1e-6
+
+// Validate checks that every face of the mesh can be rendered.
+func (o *Obj) Validate() MeshReport {
+	return o.validate(true)
+}
+
+func (o *Obj) validate(checkNormals bool) MeshReport {
+	var report MeshReport
+	for i, f := range o.Faces {
+		for _, p := range o.faceProblems(f, checkNormals) {
+			p.Face = i
+			p.Line = f.Line
+			report.Problems = append(report.Problems, p)
+		}
+	}
+	return report
+}
+
+func (o *Obj) faceProblems(f Face, checkNormals bool) []MeshProblem {
+	var problems []MeshProblem
+	if len(f.VertexIndices) < 3 {
+		problems = append(problems, MeshProblem{
+			Kind:    TooFewVertices,
+			Message: fmt.Sprintf("face has %d vertices", len(f.VertexIndices)),
+		})
+	}
+
+	verticesValid := true
+	for _, idx := range f.VertexIndices {
+		if idx < 0 || idx >= len(o.Vertices) {
+			problems = append(problems, MeshProblem{
+				Kind:    VertexIndexOutOfRange,
+				Message: fmt.Sprintf("vertex %d does not exist, there are %d vertices", idx+1, len(o.Vertices)),
+			})
+			verticesValid = false
+		}
+	}
+	for _, idx := range f.TextureCoordinateIndices {
+		if idx < 0 || idx >= len(o.TextureCoordinates) {
+			problems = append(problems, MeshProblem{
+				Kind:    TextureCoordinateIndexOutOfRange,
+				Message: fmt.Sprintf("texture coordinate %d does not exist, there are %d texture coordinates", idx+1, len(o.TextureCoordinates)),
+			})
+		}
+	}
+	for _, idx := range f.NormalIndices {
+		if idx < 0 || idx >= len(o.Normals) {
+			problems = append(problems, MeshProblem{
+				Kind:    NormalIndexOutOfRange,
+				Message: fmt.Sprintf("normal %d does not exist, there are %d normals", idx+1, len(o.Normals)),
+			})
+		}
+	}
+
+	if checkNormals && len(f.NormalIndices) != len(f.VertexIndices) {
+		problems = append(problems, MeshProblem{
+			Kind:    MissingNormals,
+			Message: fmt.Sprintf("face has %d vertices but %d normals", len(f.VertexIndices), len(f.NormalIndices)),
+		})
+	}
+
+	if verticesValid && len(f.VertexIndices) >= 3 && o.isZeroArea(f) {
+		problems = append(problems, MeshProblem{Kind: ZeroAreaFace, Message: "face has zero area"})
+	}
+	return problems
+}
+
+func (o *Obj) isZeroArea(f Face) bool {
+	// Twice the area is the length of the unnormalized Newell normal
+	var normal vec3.T
+	var longestEdge float32
+	for i := range f.VertexIndices {
+		current := o.Vertices[f.VertexIndices[i]]
+		next := o.Vertices[f.VertexIndices[(i+1)%len(f.VertexIndices)]]
+		normal[0] += (current[1] - next[1]) * (current[2] + next[2])
+		normal[1] += (current[2] - next[2]) * (current[0] + next[0])
+		normal[2] += (current[0] - next[0]) * (current[1] + next[1])
+		longestEdge = float32(math.Max(float64(longestEdge), float64(vec3.Distance(&current, &next))))
+	}
+	return normal.Length()/2 <= degenerateAreaRatio*longestEdge*longestEdge
+}
+
+// Repair fixes the problems found by Validate. Faces that cannot be rendered
+// are dropped, and texture coordinate or normal indices that are out of range
+// or incomplete are removed from their face. Faces left without normals can
+// be given new ones with GenerateMissingNormals.
+func (o *Obj) Repair(options RepairOptions) MeshReport {
+	var report MeshReport
+
+	o.dropBrokenFaces(&report, false)
+	if options.WeldVertices {
+		report.WeldedVertices = o.weldVertices(options.WeldTolerance)
+		o.dropBrokenFaces(&report, false)
+	}
+	if options.DropDegenerateFaces {
+		o.dropBrokenFaces(&report, true)
+	}
+	if options.FixWinding {
+		report.FlippedFaces = o.fixWinding()
+	}
+	return report
+}
+
+func (o *Obj) dropBrokenFaces(report *MeshReport, dropZeroArea bool) {
+	faces := o.Faces[:0]
+	for _, f := range o.Faces {
+		keep := true
+		clearTextureCoordinates := len(f.TextureCoordinateIndices) != 0 && len(f.TextureCoordinateIndices) != len(f.VertexIndices)
+		clearNormals := len(f.NormalIndices) != 0 && len(f.NormalIndices) != len(f.VertexIndices)
+		for _, p := range o.faceProblems(f, false) {
+			switch p.Kind {
+			case VertexIndexOutOfRange, TooFewVertices:
+				keep = false
+			case ZeroAreaFace:
+				keep = keep && !dropZeroArea
+			case TextureCoordinateIndexOutOfRange:
+				clearTextureCoordinates = true
+			case NormalIndexOutOfRange:
+				clearNormals = true
+			}
+		}
+		if !keep {
+			report.DroppedFaces++
+			continue
+		}
+		if clearTextureCoordinates || clearNormals {
+			report.ClearedIndices++
+		}
+		if clearTextureCoordinates {
+			f.TextureCoordinateIndices = nil
+		}
+		if clearNormals {
+			f.NormalIndices = nil
+		}
+		faces = append(faces, f)
+	}
+	o.Faces = faces
+}
+
+// weldVertices merges vertices that are within tolerance of each other,
+// removes the vertices that are no longer used and returns how many vertices
+// were merged away. Corners that collapse onto the same vertex are removed
+// from their face.
+func (o *Obj) weldVertices(tolerance float32) int {
+	// Hash vertices into cells of the tolerance size and compare each vertex
+	// against the vertices already kept in the neighbouring cells. Without a
+	// tolerance only equal vertices merge, which a map of the positions finds.
+	type cell [3]int64
+	cellSize := float64(tolerance)
+	cellOf := func(v vec3.T) cell {
+		return cell{
+			int64(math.Floor(float64(v[0]) / cellSize)),
+			int64(math.Floor(float64(v[1]) / cellSize)),
+			int64(math.Floor(float64(v[2]) / cellSize)),
+		}
+	}
+
+	grid := make(map[cell][]int)
+	exact := make(map[vec3.T]int)
+	remap := make([]int, len(o.Vertices))
+	var vertices, colors []vec3.T
+	hasColors := o.hasVertexColors()
+	for i, v := range o.Vertices {
+		remap[i] = -1
+		var c cell
+		if tolerance <= 0 {
+			if kept, ok := exact[v]; ok {
+				remap[i] = kept
+			}
+		} else {
+			c = cellOf(v)
+		search:
+			for dx := int64(-1); dx <= 1; dx++ {
+				for dy := int64(-1); dy <= 1; dy++ {
+					for dz := int64(-1); dz <= 1; dz++ {
+						for _, kept := range grid[cell{c[0] + dx, c[1] + dy, c[2] + dz}] {
+							if vec3.Distance(&v, &vertices[kept]) <= tolerance {
+								remap[i] = kept
+								break search
+							}
+						}
+					}
+				}
+			}
+		}
+		if remap[i] < 0 {
+			remap[i] = len(vertices)
+			if tolerance <= 0 {
+				exact[v] = len(vertices)
+			} else {
+				grid[c] = append(grid[c], len(vertices))
+			}
+			vertices = append(vertices, v)
+			if hasColors {
+				colors = append(colors, o.VertexColors[i])
+			}
+		}
+	}
+	welded := len(o.Vertices) - len(vertices)
+	o.Vertices = vertices
+	if hasColors {
+		o.VertexColors = colors
+	}
+
+	for i := range o.Faces {
+		f := &o.Faces[i]
+		hasTextureCoordinates := len(f.TextureCoordinateIndices) == len(f.VertexIndices)
+		hasNormals := len(f.NormalIndices) == len(f.VertexIndices)
+		n := 0
+		for j, idx := range f.VertexIndices {
+			idx = remap[idx]
+			if n > 0 && f.VertexIndices[n-1] == idx {
+				continue
+			}
+			f.VertexIndices[n] = idx
+			if hasTextureCoordinates {
+				f.TextureCoordinateIndices[n] = f.TextureCoordinateIndices[j]
+			}
+			if hasNormals {
+				f.NormalIndices[n] = f.NormalIndices[j]
+			}
+			n++
+		}
+		if n > 1 && f.VertexIndices[n-1] == f.VertexIndices[0] {
+			n--
+		}
+		f.VertexIndices = f.VertexIndices[:n]
+		if hasTextureCoordinates {
+			f.TextureCoordinateIndices = f.TextureCoordinateIndices[:n]
+		}
+		if hasNormals {
+			f.NormalIndices = f.NormalIndices[:n]
+		}
+	}
+	return welded
+}
+
+// fixWinding makes neighbouring faces traverse their shared edge in opposite
+// directions, so that all faces of a connected part of the mesh are
+// oriented the same way. Parts that are closed are then turned to face
+// outwards. It returns the number of flipped faces.
+func (o *Obj) fixWinding() int {
+	type edge struct{ a, b int }
+	edgeFaces := make(map[edge][]int)
+	for i, f := range o.Faces {
+		for j := range f.VertexIndices {
+			a, b := f.VertexIndices[j], f.VertexIndices[(j+1)%len(f.VertexIndices)]
+			if a > b {
+				a, b = b, a
+			}
+			edgeFaces[edge{a, b}] = append(edgeFaces[edge{a, b}], i)
+		}
+	}
+
+	// direction returns +1 if the face traverses the edge from a to b
+	direction := func(f Face, e edge) int {
+		for j := range f.VertexIndices {
+			if f.VertexIndices[j] == e.a && f.VertexIndices[(j+1)%len(f.VertexIndices)] == e.b {
+				return 1
+			}
+		}
+		return -1
+	}
+
+	flipped := make([]bool, len(o.Faces))
+	visited := make([]bool, len(o.Faces))
+	for start := range o.Faces {
+		if visited[start] {
+			continue
+		}
+
+		// Orient the connected part of the mesh like its first face
+		component := []int{start}
+		visited[start] = true
+		closed := true
+		for k := 0; k < len(component); k++ {
+			current := component[k]
+			f := o.Faces[current]
+			for j := range f.VertexIndices {
+				a, b := f.VertexIndices[j], f.VertexIndices[(j+1)%len(f.VertexIndices)]
+				if a > b {
+					a, b = b, a
+				}
+				e := edge{a, b}
+				neighbours := edgeFaces[e]
+				if len(neighbours) != 2 {
+					// Borders and non-manifold edges do not tell the orientation
+					closed = false
+					continue
+				}
+				other := neighbours[0]
+				if other == current {
+					other = neighbours[1]
+				}
+				if visited[other] {
+					continue
+				}
+				visited[other] = true
+				currentDirection := direction(f, e)
+				if flipped[current] {
+					currentDirection = -currentDirection
+				}
+				flipped[other] = direction(o.Faces[other], e) == currentDirection
+				component = append(component, other)
+			}
+		}
+
+		// A closed part with negative volume is inside out
+		if closed {
+			var volume float32
+			for _, i := range component {
+				f := o.Faces[i]
+				sign := float32(1)
+				if flipped[i] {
+					sign = -1
+				}
+				v0 := o.Vertices[f.VertexIndices[0]]
+				for j := 1; j+1 < len(f.VertexIndices); j++ {
+					v1 := o.Vertices[f.VertexIndices[j]]
+					v2 := o.Vertices[f.VertexIndices[j+1]]
+					cross := vec3.Cross(&v1, &v2)
+					volume += sign * vec3.Dot(&v0, &cross) / 6
+				}
+			}
+			if volume < 0 {
+				for _, i := range component {
+					flipped[i] = !flipped[i]
+				}
+			}
+		}
+	}
+
+	count := 0
+	for i := range o.Faces {
+		if flipped[i] {
+			o.Faces[i].flip()
+			count++
+		}
+	}
+	return count
+}
+
+// flip reverses the winding order of the face.
+func (f *Face) flip() {
+	reverseInts(f.VertexIndices)
+	reverseInts(f.TextureCoordinateIndices)
+	reverseInts(f.NormalIndices)
+}
+
+func reverseInts(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// cubeObj is the cube from -1 to 1 with outward facing quads.
+func cubeObj() *Obj {
+	o := &Obj{}
+	for i := 0; i < 8; i++ {
+		o.Vertices = append(o.Vertices, vec3.T{float32(i&1*2 - 1), float32(i>>1&1*2 - 1), float32(i>>2&1*2 - 1)})
+	}
+	for _, f := range [][]int{{0, 2, 3, 1}, {4, 5, 7, 6}, {0, 4, 6, 2}, {1, 3, 7, 5}, {0, 1, 5, 4}, {2, 6, 7, 3}} {
+		o.Faces = append(o.Faces, Face{VertexIndices: f})
+	}
+	return o
+}
+
+func problemKinds(report MeshReport, face int) []MeshProblemKind {
+	var kinds []MeshProblemKind
+	for _, p := range report.Problems {
+		if p.Face == face {
+			kinds = append(kinds, p.Kind)
+		}
+	}
+	return kinds
+}
+
+func TestValidate(t *testing.T) {
+	o := &Obj{
+		Vertices: []vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {2, 0, 0}},
+		Normals:  []Normal{{0, 0, 1}},
+		Faces: []Face{
+			{VertexIndices: []int{0, 1, 2}, NormalIndices: []int{0, 0, 0}},
+			{VertexIndices: []int{0, 1, 7}, NormalIndices: []int{0, 0, 0}},
+			{VertexIndices: []int{0, 1}},
+			{VertexIndices: []int{0, 1, 3}, NormalIndices: []int{0, 0, 0}},
+			{VertexIndices: []int{0, 1, 2}, NormalIndices: []int{0, 0, 4}},
+			{VertexIndices: []int{0, 1, 2}, NormalIndices: []int{0}},
+		},
+	}
+	want := [][]MeshProblemKind{
+		nil,
+		{VertexIndexOutOfRange},
+		{TooFewVertices, MissingNormals},
+		{ZeroAreaFace},
+		{NormalIndexOutOfRange},
+		{MissingNormals},
+	}
+	report := o.Validate()
+	for face, kinds := range want {
+		got := problemKinds(report, face)
+		if len(got) != len(kinds) {
+			t.Errorf("face %d has problems %v, want %v", face, got, kinds)
+			continue
+		}
+		for i := range got {
+			if got[i] != kinds[i] {
+				t.Errorf("face %d has problems %v, want %v", face, got, kinds)
+				break
+			}
+		}
+	}
+	if !report.HasFatalProblems() {
+		t.Error("report has no fatal problems")
+	}
+	if report := cubeObj().validate(false); len(report.Problems) != 0 {
+		t.Errorf("cube has problems %v", report.Problems)
+	}
+}
+
+const brokenObj = `v 0 0 0
+v 1 0 0
+v 0 1 0
+v 2 0 0
+vn 0 0 1
+f 1 2 3
+f 1 2 9
+f 1 2 4
+f 1//1 2//1 3//5
+`
+
+func TestParseObjFileRejectsBrokenMesh(t *testing.T) {
+	path := writeTestFile(t, "broken.obj", brokenObj)
+	_, report, err := ParseObjFileWithOptions(path, DefaultObjOptions())
+	var meshErr *MeshError
+	if !errors.As(err, &meshErr) {
+		t.Fatalf("error %v, want a MeshError", err)
+	}
+	lines := map[int]MeshProblemKind{}
+	for _, p := range report.Problems {
+		lines[p.Line] = p.Kind
+	}
+	want := map[int]MeshProblemKind{7: VertexIndexOutOfRange, 8: ZeroAreaFace, 9: NormalIndexOutOfRange}
+	for line, kind := range want {
+		if lines[line] != kind {
+			t.Errorf("line %d has problem %v, want %v", line, lines[line], kind)
+		}
+	}
+}
+
+func TestParseObjFileRepairsBrokenMesh(t *testing.T) {
+	path := writeTestFile(t, "broken.obj", brokenObj)
+	options := DefaultObjOptions()
+	repair := DefaultRepairOptions()
+	options.Repair = &repair
+	o, report, err := ParseObjFileWithOptions(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DroppedFaces != 2 || report.ClearedIndices != 1 || !report.Changed() {
+		t.Errorf("report %+v, want 2 dropped faces and 1 face with cleared indices", report)
+	}
+	if len(o.Faces) != 2 {
+		t.Errorf("%d faces left, want 2", len(o.Faces))
+	}
+	if report := o.Validate(); len(report.Problems) != 0 {
+		t.Errorf("repaired mesh has problems %v", report.Problems)
+	}
+}
+
+func TestRepairWeldsVertices(t *testing.T) {
+	// Give every face of the cube its own vertices, some of them slightly off
+	cube := cubeObj()
+	o := &Obj{}
+	for _, f := range cube.Faces {
+		face := Face{}
+		for _, idx := range f.VertexIndices {
+			face.VertexIndices = append(face.VertexIndices, len(o.Vertices))
+			v := cube.Vertices[idx]
+			v[0] += float32(len(o.Faces)%2) * 1e-7
+			o.Vertices = append(o.Vertices, v)
+		}
+		o.Faces = append(o.Faces, face)
+	}
+
+	// Without a tolerance only equal vertices merge, which leaves two for
+	// each corner but the two whose faces are all shifted or all unshifted
+	exact := &Obj{Vertices: append([]vec3.T(nil), o.Vertices...), Faces: append([]Face(nil), o.Faces...)}
+	for i := range exact.Faces {
+		exact.Faces[i].VertexIndices = append([]int(nil), exact.Faces[i].VertexIndices...)
+	}
+	if welded := exact.weldVertices(0); welded != 10 || len(exact.Vertices) != 14 {
+		t.Errorf("welded %d vertices to %d without a tolerance, want 10 to 14", welded, len(exact.Vertices))
+	}
+
+	report := o.Repair(DefaultRepairOptions())
+	if report.WeldedVertices != 16 || len(o.Vertices) != 8 {
+		t.Errorf("welded %d vertices to %d, want 16 to 8", report.WeldedVertices, len(o.Vertices))
+	}
+	if report.FlippedFaces != 0 {
+		t.Errorf("flipped %d faces of a consistent cube", report.FlippedFaces)
+	}
+}
+
+func TestRepairFixesWinding(t *testing.T) {
+	o := cubeObj()
+	o.Faces[1].flip()
+	o.Faces[4].flip()
+	report := o.Repair(DefaultRepairOptions())
+	if report.FlippedFaces != 2 {
+		t.Errorf("flipped %d faces, want 2", report.FlippedFaces)
+	}
+
+	// An inside out cube is turned around completely
+	o = cubeObj()
+	for i := range o.Faces {
+		o.Faces[i].flip()
+	}
+	report = o.Repair(DefaultRepairOptions())
+	if report.FlippedFaces != 6 {
+		t.Errorf("flipped %d faces of an inside out cube, want 6", report.FlippedFaces)
+	}
+	for i, f := range o.Faces {
+		normal := polygonNormal(o.Vertices, f.VertexIndices)
+		center := o.Vertices[f.VertexIndices[0]]
+		center.Add(&o.Vertices[f.VertexIndices[2]])
+		if vec3.Dot(&normal, &center) <= 0 {
+			t.Errorf("face %d faces inwards", i)
+		}
+	}
+}
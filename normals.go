@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// GenerateNormals discards all normals of the mesh and generates new ones.
+// See GenerateMissingNormals for how smoothing groups and the crease angle
+// are taken into account.
+func (o *Obj) GenerateNormals(creaseAngle float64) {
+	o.Normals = nil
+	for i := range o.Faces {
+		o.Faces[i].NormalIndices = nil
+	}
+	o.GenerateMissingNormals(creaseAngle)
+}
+
+// GenerateMissingNormals generates vertex normals for the faces that do not
+// reference a normal for every corner. Faces in smoothing group 0 are shaded
+// flat. Otherwise the normal of a corner is the average of the normals of the
+// faces around the vertex that are in the same smoothing group and whose
+// normal differs by no more than creaseAngle degrees, so vertices are split
+// along hard edges.
+func (o *Obj) GenerateMissingNormals(creaseAngle float64) {
+	cosCrease := float32(math.Cos(creaseAngle * math.Pi / 180))
+
+	// Find the faces without normals and the faces around each vertex
+	faceNormals := make(map[int]vec3.T)
+	vertexFaces := make(map[int][]int)
+	for i, f := range o.Faces {
+		if len(f.NormalIndices) == len(f.VertexIndices) {
+			continue
+		}
+		faceNormals[i] = polygonNormal(o.Vertices, f.VertexIndices)
+		for _, vIdx := range f.VertexIndices {
+			vertexFaces[vIdx] = append(vertexFaces[vIdx], i)
+		}
+	}
+
+	// Corners of the same vertex which end up with the same normal share it
+	type cornerNormal struct {
+		vertex int
+		normal vec3.T
+	}
+	normalIndices := make(map[cornerNormal]int)
+
+	for i := range o.Faces {
+		faceNormal, ok := faceNormals[i]
+		if !ok {
+			continue
+		}
+		f := &o.Faces[i]
+		f.NormalIndices = make([]int, len(f.VertexIndices))
+
+		for j, vIdx := range f.VertexIndices {
+			normal := faceNormal
+			if f.SmoothingGroup != 0 && !faceNormal.IsZero() {
+				normal = vec3.T{}
+				for _, other := range vertexFaces[vIdx] {
+					otherNormal := faceNormals[other]
+					if o.Faces[other].SmoothingGroup != f.SmoothingGroup {
+						continue
+					}
+					if vec3.Dot(&faceNormal, &otherNormal) < cosCrease {
+						continue
+					}
+					normal.Add(&otherNormal)
+				}
+				normal.Normalize()
+			}
+
+			key := cornerNormal{vIdx, normal}
+			nIdx, ok := normalIndices[key]
+			if !ok {
+				nIdx = len(o.Normals)
+				o.Normals = append(o.Normals, Normal{X: normal[0], Y: normal[1], Z: normal[2]})
+				normalIndices[key] = nIdx
+			}
+			f.NormalIndices[j] = nIdx
+		}
+	}
+}
+
+// polygonNormal calculates the normal of a polygon with Newell's method, which
+// also gives a sensible result for non-planar polygons with more than three
+// vertices. The result is zero for degenerate polygons.
+func polygonNormal(vertices []vec3.T, vertexIndices []int) vec3.T {
+	var normal vec3.T
+	for i := range vertexIndices {
+		current := vertices[vertexIndices[i]]
+		next := vertices[vertexIndices[(i+1)%len(vertexIndices)]]
+		normal[0] += (current[1] - next[1]) * (current[2] + next[2])
+		normal[1] += (current[2] - next[2]) * (current[0] + next[0])
+		normal[2] += (current[0] - next[0]) * (current[1] + next[1])
+	}
+	normal.Normalize()
+	return normal
+}
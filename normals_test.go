@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// writeTestFile writes content to a file in a temporary directory of the test
+// and returns its path.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func vecApproxEqual(a, b vec3.T, epsilon float32) bool {
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > float64(epsilon) {
+			return false
+		}
+	}
+	return true
+}
+
+func cornerNormal(o *Obj, face, corner int) vec3.T {
+	return o.Normals[o.Faces[face].NormalIndices[corner]].ToVec3()
+}
+
+// Two triangles that share the edge from vertex 1 to 2 and meet at a right
+// angle, the first facing +Z and the second -Y.
+const foldedObj = `v 0 0 0
+v 1 0 0
+v 0 1 0
+v 0 0 -1
+%s
+f 1 2 3
+%s
+f 2 1 4
+`
+
+func parseTestObj(t *testing.T, content string, creaseAngle float64) *Obj {
+	t.Helper()
+	o, _, err := ParseObjFileWithOptions(writeTestFile(t, "test.obj", content), ObjOptions{CreaseAngle: creaseAngle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestGenerateNormalsSmoothingGroups(t *testing.T) {
+	flat := [2]vec3.T{{0, 0, 1}, {0, -1, 0}}
+	smooth := vec3.T{0, -float32(math.Sqrt2) / 2, float32(math.Sqrt2) / 2}
+
+	tests := []struct {
+		name         string
+		first, other string
+		creaseAngle  float64
+		shared       bool
+	}{
+		{"same group", "s 1", "", 180, true},
+		{"different groups", "s 1", "s 2", 180, false},
+		{"smoothing off", "s off", "", 180, false},
+		{"no groups within crease angle", "", "", 120, true},
+		{"no groups beyond crease angle", "", "", 60, false},
+		{"same group beyond crease angle", "s 1", "", 60, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := parseTestObj(t, fmt.Sprintf(foldedObj, test.first, test.other), test.creaseAngle)
+			// Corner 0 of face 0 and corner 1 of face 1 are vertex 1
+			a, b := cornerNormal(o, 0, 0), cornerNormal(o, 1, 1)
+			if test.shared {
+				if !vecApproxEqual(a, smooth, 1e-5) || !vecApproxEqual(b, smooth, 1e-5) {
+					t.Errorf("normals %v and %v, want both %v", a, b, smooth)
+				}
+			} else if !vecApproxEqual(a, flat[0], 1e-5) || !vecApproxEqual(b, flat[1], 1e-5) {
+				t.Errorf("normals %v and %v, want %v and %v", a, b, flat[0], flat[1])
+			}
+			// Vertex 3 only belongs to the first face
+			if n := cornerNormal(o, 0, 2); !vecApproxEqual(n, flat[0], 1e-5) {
+				t.Errorf("normal of unshared vertex %v, want %v", n, flat[0])
+			}
+		})
+	}
+}
+
+func TestParseObjFileKeepsFileNormals(t *testing.T) {
+	o := parseTestObj(t, `v 0 0 0
+v 1 0 0
+v 0 1 0
+v 0 0 -1
+vn 1 0 0
+f 1//1 2//1 3//1
+f 2 1 4
+`, 180)
+	for corner := 0; corner < 3; corner++ {
+		if n := cornerNormal(o, 0, corner); n != (vec3.T{1, 0, 0}) {
+			t.Errorf("corner %d has normal %v, want the normal of the file", corner, n)
+		}
+	}
+	// The face without normals is smoothed only with faces that need
+	// generated normals too
+	if n := cornerNormal(o, 1, 0); !vecApproxEqual(n, vec3.T{0, -1, 0}, 1e-5) {
+		t.Errorf("generated normal %v, want %v", n, vec3.T{0, -1, 0})
+	}
+}
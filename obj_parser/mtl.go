@@ -0,0 +1,232 @@
+package obj_parser
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Material is a material of an MTL file. Texture maps are file names as
+// written in the file, relative to the MTL file.
+type Material struct {
+	Name         string
+	Ambient      Color   // Ka
+	Diffuse      Color   // Kd
+	Specular     Color   // Ks
+	Emission     Color   // Ke
+	Shininess    float64 // Ns, the exponent of the specular highlight
+	IOR          float64 // Ni
+	Dissolve     float64 // d, or 1 - Tr, where 1 is opaque
+	Illumination int     // illum
+	Metallic     float64 // Pm
+	Roughness    float64 // Pr, negative if not given
+
+	AmbientMap      string // map_Ka
+	DiffuseMap      string // map_Kd
+	SpecularMap     string // map_Ks
+	EmissionMap     string // map_Ke
+	ShininessMap    string // map_Ns
+	DissolveMap     string // map_d
+	BumpMap         string // map_bump or bump
+	NormalMap       string // norm
+	DisplacementMap string // disp
+	MetallicMap     string // map_Pm
+	RoughnessMap    string // map_Pr
+
+	BumpMultiplier float64 // -bm option of the bump map
+}
+
+// ParseMtlFile parses a material library.
+func ParseMtlFile(filename string) ([]Material, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var materials []Material
+	var current *Material
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if fields[0] == "newmtl" {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: invalid material definition: %v", filename, lineNumber, fields)
+			}
+			materials = append(materials, Material{
+				Name:           strings.Join(fields[1:], " "),
+				Diffuse:        Color{R: 1, G: 1, B: 1},
+				IOR:            1,
+				Dissolve:       1,
+				Roughness:      -1,
+				BumpMultiplier: 1,
+			})
+			current = &materials[len(materials)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(fields[0]) {
+		case "ka":
+			current.Ambient, err = parseColor(fields)
+		case "kd":
+			current.Diffuse, err = parseColor(fields)
+		case "ks":
+			current.Specular, err = parseColor(fields)
+		case "ke":
+			current.Emission, err = parseColor(fields)
+		case "ns":
+			current.Shininess, err = parseScalar(fields)
+		case "ni":
+			current.IOR, err = parseScalar(fields)
+		case "d":
+			current.Dissolve, err = parseScalar(fields)
+		case "tr":
+			var transparency float64
+			transparency, err = parseScalar(fields)
+			current.Dissolve = 1 - transparency
+		case "pm":
+			current.Metallic, err = parseScalar(fields)
+		case "pr":
+			current.Roughness, err = parseScalar(fields)
+		case "illum":
+			var illum float64
+			illum, err = parseScalar(fields)
+			current.Illumination = int(illum)
+		case "map_ka":
+			current.AmbientMap, err = parseMap(fields)
+		case "map_kd":
+			current.DiffuseMap, err = parseMap(fields)
+		case "map_ks":
+			current.SpecularMap, err = parseMap(fields)
+		case "map_ke":
+			current.EmissionMap, err = parseMap(fields)
+		case "map_ns":
+			current.ShininessMap, err = parseMap(fields)
+		case "map_d":
+			current.DissolveMap, err = parseMap(fields)
+		case "map_bump", "bump":
+			current.BumpMap, err = parseMap(fields)
+			if err == nil {
+				current.BumpMultiplier, err = parseMapOption(fields, "-bm", current.BumpMultiplier)
+			}
+		case "norm":
+			current.NormalMap, err = parseMap(fields)
+		case "disp":
+			current.DisplacementMap, err = parseMap(fields)
+		case "map_pm":
+			current.MetallicMap, err = parseMap(fields)
+		case "map_pr":
+			current.RoughnessMap, err = parseMap(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNumber, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return materials, nil
+}
+
+// parseColor parses "Kd r g b". A single value is used for all three.
+func parseColor(fields []string) (Color, error) {
+	if len(fields) != 2 && len(fields) < 4 {
+		return Color{}, fmt.Errorf("invalid colour definition: %v", fields)
+	}
+	var rgb [3]float64
+	for i := range rgb {
+		field := fields[1]
+		if len(fields) >= 4 {
+			field = fields[1+i]
+		}
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Color{}, err
+		}
+		rgb[i] = value
+	}
+	return Color{R: rgb[0], G: rgb[1], B: rgb[2]}, nil
+}
+
+func parseScalar(fields []string) (float64, error) {
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid definition: %v", fields)
+	}
+	return strconv.ParseFloat(fields[len(fields)-1], 64)
+}
+
+// mapOptionArgs is the number of arguments of each option of a texture
+// statement. -o, -s and -t take up to three.
+var mapOptionArgs = map[string]int{
+	"-blendu": 1, "-blendv": 1, "-bm": 1, "-boost": 1, "-cc": 1, "-clamp": 1,
+	"-imfchan": 1, "-mm": 2, "-o": 3, "-s": 3, "-t": 3, "-texres": 1, "-type": 1,
+}
+
+// splitMap splits a texture statement into its options, like "-bm 0.5" or
+// "-s 1 1 1", and the file name after them, which may contain spaces.
+func splitMap(fields []string) (map[string][]string, string, error) {
+	options := make(map[string][]string)
+	i := 1
+	for i < len(fields) {
+		count, ok := mapOptionArgs[fields[i]]
+		if !ok {
+			break
+		}
+		option := fields[i]
+		i++
+		var args []string
+		for len(args) < count && i < len(fields) {
+			// The optional arguments of -o, -s and -t are numbers
+			if len(args) > 0 && count == 3 {
+				if _, err := strconv.ParseFloat(fields[i], 64); err != nil {
+					break
+				}
+			}
+			args = append(args, fields[i])
+			i++
+		}
+		if len(args) == 0 {
+			return nil, "", fmt.Errorf("missing argument of texture option %s: %v", option, fields)
+		}
+		options[option] = args
+	}
+	if i >= len(fields) {
+		return nil, "", fmt.Errorf("invalid texture definition: %v", fields)
+	}
+	return options, strings.Join(fields[i:], " "), nil
+}
+
+// parseMap returns the file name of a texture statement.
+func parseMap(fields []string) (string, error) {
+	_, name, err := splitMap(fields)
+	return name, err
+}
+
+// parseMapOption returns the value of a single valued option of a texture
+// statement, or fallback if it is not given.
+func parseMapOption(fields []string, option string, fallback float64) (float64, error) {
+	options, _, err := splitMap(fields)
+	if err != nil {
+		return 0, err
+	}
+	args, ok := options[option]
+	if !ok {
+		return fallback, nil
+	}
+	return strconv.ParseFloat(args[0], 64)
+}
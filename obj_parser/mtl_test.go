@@ -0,0 +1,111 @@
+package obj_parser
+
+import "testing"
+
+func TestParseMtlFile(t *testing.T) {
+	materials, err := ParseMtlFile(writeTestFile(t, "test.mtl", `# two materials
+Kd 0 0 0
+newmtl red paint
+Kd 1 0 0
+Ks 0.5
+Ke 0 0.2 0
+Ns 50
+Ni 1.5
+Tr 0.25
+illum 2
+map_Kd textures/red paint.png
+bump -bm 0.3 -s 2 2 bump.png
+Pr 0.4
+Pm 1
+newmtl plain
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(materials) != 2 {
+		t.Fatalf("%d materials, want 2", len(materials))
+	}
+
+	red := materials[0]
+	if red.Name != "red paint" {
+		t.Errorf("name %q, want %q", red.Name, "red paint")
+	}
+	if red.Diffuse != (Color{R: 1}) || red.Specular != (Color{R: 0.5, G: 0.5, B: 0.5}) || red.Emission != (Color{G: 0.2}) {
+		t.Errorf("colours Kd %v, Ks %v, Ke %v", red.Diffuse, red.Specular, red.Emission)
+	}
+	if red.Shininess != 50 || red.IOR != 1.5 || red.Dissolve != 0.75 || red.Illumination != 2 {
+		t.Errorf("Ns %v, Ni %v, d %v, illum %v", red.Shininess, red.IOR, red.Dissolve, red.Illumination)
+	}
+	if red.Roughness != 0.4 || red.Metallic != 1 {
+		t.Errorf("Pr %v, Pm %v", red.Roughness, red.Metallic)
+	}
+	if red.DiffuseMap != "textures/red paint.png" {
+		t.Errorf("map_Kd %q, want %q", red.DiffuseMap, "textures/red paint.png")
+	}
+	if red.BumpMap != "bump.png" || red.BumpMultiplier != 0.3 {
+		t.Errorf("bump map %q with multiplier %v", red.BumpMap, red.BumpMultiplier)
+	}
+
+	// Statements before the first newmtl are ignored, and materials start
+	// out white and opaque
+	plain := materials[1]
+	if plain.Diffuse != (Color{R: 1, G: 1, B: 1}) || plain.Dissolve != 1 || plain.IOR != 1 || plain.Roughness >= 0 || plain.BumpMultiplier != 1 {
+		t.Errorf("defaults %+v", plain)
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	tests := []struct {
+		fields []string
+		name   string
+		bm     float64
+	}{
+		{[]string{"map_Kd", "a.png"}, "a.png", 1},
+		{[]string{"map_Kd", "my", "file.png"}, "my file.png", 1},
+		{[]string{"bump", "-bm", "0.5", "b.png"}, "b.png", 0.5},
+		{[]string{"bump", "-s", "1", "2", "-bm", "2", "b", "c.png"}, "b c.png", 2},
+		{[]string{"bump", "-o", "0.5", "-clamp", "on", "-mm", "0", "1", "d.png"}, "d.png", 1},
+		{[]string{"bump", "-t", "1", "1", "1", "-bm", "3", "2.png"}, "2.png", 3},
+	}
+	for _, test := range tests {
+		name, err := parseMap(test.fields)
+		if err != nil {
+			t.Errorf("%v: %v", test.fields, err)
+			continue
+		}
+		if name != test.name {
+			t.Errorf("%v: file name %q, want %q", test.fields, name, test.name)
+		}
+		if bm, err := parseMapOption(test.fields, "-bm", 1); err != nil || bm != test.bm {
+			t.Errorf("%v: -bm %v, %v, want %v", test.fields, bm, err, test.bm)
+		}
+	}
+
+	for _, fields := range [][]string{{"map_Kd"}, {"map_Kd", "-bm", "0.5"}, {"map_Kd", "-bm"}} {
+		if _, err := parseMap(fields); err == nil {
+			t.Errorf("%v: no error", fields)
+		}
+	}
+}
+
+func TestParseUsemtl(t *testing.T) {
+	obj := parseTestFile(t, `mtllib a.mtl b.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+usemtl red paint
+f 1 2 3
+usemtl plain
+f 1 2 3
+`)
+	if len(obj.MaterialLibraries) != 2 || obj.MaterialLibraries[0] != "a.mtl" || obj.MaterialLibraries[1] != "b.mtl" {
+		t.Errorf("material libraries %v", obj.MaterialLibraries)
+	}
+	want := []string{"", "red paint", "plain"}
+	for i, f := range obj.Faces {
+		if f.Material != want[i] {
+			t.Errorf("face %d has material %q, want %q", i, f.Material, want[i])
+		}
+	}
+}
@@ -16,10 +16,16 @@ type Normal struct {
 type TextureCoordinate struct {
 	U, V float64
 }
+type Color struct {
+	R, G, B float64
+}
 type Face struct {
 	VertexIndices            []int
 	TextureCoordinateIndices []int
 	NormalIndices            []int
+	SmoothingGroup           int    // 0 means smoothing is off for this face
+	Line                     int    // line of the face statement in the file
+	Material                 string // name of the material set by usemtl, if any
 }
 
 type Obj struct {
@@ -27,6 +33,14 @@ type Obj struct {
 	TextureCoordinates []TextureCoordinate
 	Normals            []Normal
 	Faces              []Face
+	HasSmoothingGroups bool // true if the file contained at least one "s" statement
+	// VertexColors has one colour per vertex if any vertex in the file has
+	// one, vertices without a colour are white. The values are between 0 and
+	// 1, files that write them between 0 and 255 are scaled down.
+	VertexColors []Color
+	// MaterialLibraries lists the MTL files named by mtllib statements, as
+	// written in the file.
+	MaterialLibraries []string
 }
 
 func ParseObjFile(filename string) (*Obj, error) {
@@ -38,8 +52,13 @@ func ParseObjFile(filename string) (*Obj, error) {
 
 	scanner := bufio.NewScanner(file)
 	obj := Obj{}
+	smoothingGroup := 0
+	material := ""
+	lineNumber := 0
+	var colored []bool // whether each vertex colour was in the file
 
 	for scanner.Scan() {
+		lineNumber++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue 
@@ -48,11 +67,19 @@ func ParseObjFile(filename string) (*Obj, error) {
 		fields := strings.Fields(line)
 		switch fields[0] {
 		case "v":
-			vertex, err := parseVertex(fields)
+			vertex, color, hasColor, err := parseVertex(fields)
 			if err != nil {
 				return nil, err
 			}
 			obj.Vertices = append(obj.Vertices, vertex)
+			if hasColor {
+				for len(colored) < len(obj.Vertices)-1 {
+					colored = append(colored, false)
+				}
+				colored = append(colored, true)
+				obj.VertexColors = padColors(obj.VertexColors, len(obj.Vertices)-1)
+				obj.VertexColors = append(obj.VertexColors, color)
+			}
 		case "vt": 
 			tc, err := parseTextureCoordinate(fields)
 			if err != nil {
@@ -70,34 +97,92 @@ func ParseObjFile(filename string) (*Obj, error) {
 			if err != nil {
 				return nil, err
 			}
+			face.SmoothingGroup = smoothingGroup
+			face.Line = lineNumber
+			face.Material = material
 			obj.Faces = append(obj.Faces, face)
+		case "s":
+			group, err := parseSmoothingGroup(fields)
+			if err != nil {
+				return nil, err
+			}
+			smoothingGroup = group
+			obj.HasSmoothingGroups = true
+		case "mtllib":
+			obj.MaterialLibraries = append(obj.MaterialLibraries, fields[1:]...)
+		case "usemtl":
+			material = strings.Join(fields[1:], " ")
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	if len(obj.VertexColors) > 0 {
+		scaleColors(obj.VertexColors, colored)
+		obj.VertexColors = padColors(obj.VertexColors, len(obj.Vertices))
+	}
 
 	return &obj, nil
 }
 
-func parseVertex(fields []string) (vec3.T, error) {
+// padColors appends white until there are n colours.
+func padColors(colors []Color, n int) []Color {
+	for len(colors) < n {
+		colors = append(colors, Color{R: 1, G: 1, B: 1})
+	}
+	return colors
+}
+
+// scaleColors scales the colours that were in the file down to between 0
+// and 1 if any of them is above 1, as some exporters write them between 0
+// and 255. The white padding is already between 0 and 1.
+func scaleColors(colors []Color, colored []bool) {
+	scale := 1.0
+	for i, c := range colors {
+		if colored[i] && (c.R > 1 || c.G > 1 || c.B > 1) {
+			scale = 1.0 / 255
+			break
+		}
+	}
+	for i := range colors {
+		if colored[i] {
+			colors[i] = Color{R: colors[i].R * scale, G: colors[i].G * scale, B: colors[i].B * scale}
+		}
+	}
+}
+
+// parseVertex parses "v x y z", optionally followed by a weight w or by the
+// colour "r g b" that many exporters write.
+func parseVertex(fields []string) (vec3.T, Color, bool, error) {
 	if len(fields) < 4 {
-		return vec3.T{}, fmt.Errorf("invalid vertex definition: %v", fields)
+		return vec3.T{}, Color{}, false, fmt.Errorf("invalid vertex definition: %v", fields)
 	}
 	x, err := strconv.ParseFloat(fields[1], 64)
 	if err != nil {
-		return vec3.T{}, err
+		return vec3.T{}, Color{}, false, err
 	}
 	y, err := strconv.ParseFloat(fields[2], 64)
 	if err != nil {
-		return vec3.T{}, err
+		return vec3.T{}, Color{}, false, err
 	}
 	z, err := strconv.ParseFloat(fields[3], 64)
 	if err != nil {
-		return vec3.T{}, err
+		return vec3.T{}, Color{}, false, err
+	}
+	vertex := vec3.T{float32(x), float32(y), float32(z)}
+	if len(fields) < 7 {
+		return vertex, Color{}, false, nil
+	}
+
+	var rgb [3]float64
+	for i := range rgb {
+		rgb[i], err = strconv.ParseFloat(fields[4+i], 64)
+		if err != nil {
+			return vec3.T{}, Color{}, false, err
+		}
 	}
-	return vec3.T{float32(x), float32(y), float32(z)}, nil
+	return vertex, Color{R: rgb[0], G: rgb[1], B: rgb[2]}, true, nil
 }
 
 func parseTextureCoordinate(fields []string) (TextureCoordinate, error) {
@@ -134,6 +219,20 @@ func parseNormal(fields []string) (Normal, error) {
 	return Normal{X: x, Y: y, Z: z}, nil
 }
 
+func parseSmoothingGroup(fields []string) (int, error) {
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid smoothing group definition: %v", fields)
+	}
+	if fields[1] == "off" {
+		return 0, nil
+	}
+	group, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, err
+	}
+	return group, nil
+}
+
 func parseFace(fields []string) (Face, error) {
 	face := Face{
 		VertexIndices:            make([]int, 0, len(fields)-1),
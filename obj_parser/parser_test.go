@@ -0,0 +1,84 @@
+package obj_parser
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFile writes content to a file called name in a temporary
+// directory and returns its path.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func parseTestFile(t *testing.T, content string) *Obj {
+	t.Helper()
+	obj, err := ParseObjFile(writeTestFile(t, "test.obj", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestParseSmoothingGroups(t *testing.T) {
+	obj := parseTestFile(t, `v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+s 2
+f 1 2 3
+s off
+f 1 2 3
+`)
+	if !obj.HasSmoothingGroups {
+		t.Error("HasSmoothingGroups is false")
+	}
+	want := []int{0, 2, 0}
+	for i, f := range obj.Faces {
+		if f.SmoothingGroup != want[i] {
+			t.Errorf("face %d is in smoothing group %d, want %d", i, f.SmoothingGroup, want[i])
+		}
+	}
+
+	obj = parseTestFile(t, "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n")
+	if obj.HasSmoothingGroups {
+		t.Error("HasSmoothingGroups is true without s statements")
+	}
+}
+
+func TestParseVertexColors(t *testing.T) {
+	white := Color{R: 1, G: 1, B: 1}
+	tests := []struct {
+		name    string
+		content string
+		colors  []Color
+	}{
+		{"none", "v 0 0 0\nv 1 0 0\n", nil},
+		{"unit", "v 0 0 0 1 0.5 0\nv 1 0 0 0 0 1\n", []Color{{R: 1, G: 0.5}, {B: 1}}},
+		{"bytes", "v 0 0 0 255 51 0\nv 1 0 0 0 0 255\n", []Color{{R: 1, G: 0.2}, {B: 1}}},
+		// Vertices without a colour stay white when the others are scaled
+		{"padded bytes", "v 0 0 0\nv 1 0 0 255 0 0\nv 2 0 0\n", []Color{white, {R: 1}, white}},
+		{"padded unit", "v 0 0 0 0 0 0.5\nv 1 0 0\n", []Color{{B: 0.5}, white}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := parseTestFile(t, test.content)
+			if len(obj.VertexColors) != len(test.colors) {
+				t.Fatalf("colours %v, want %v", obj.VertexColors, test.colors)
+			}
+			for i, c := range obj.VertexColors {
+				want := test.colors[i]
+				if math.Abs(c.R-want.R) > 1e-9 || math.Abs(c.G-want.G) > 1e-9 || math.Abs(c.B-want.B) > 1e-9 {
+					t.Errorf("colour %d is %v, want %v", i, c, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+type PointShape int
+
+const (
+	PointSphere PointShape = iota
+	// PointDisk renders points as disks, oriented by the normals of the cloud
+	// if it has them and facing the ray otherwise.
+	PointDisk
+)
+
+// PointCloud renders every point as a small sphere or disk. A BVH over the
+// points keeps rendering fast for clouds with millions of points.
+type PointCloud struct {
+	Positions []vec3.T
+	Colors    []color.RGBA // optional, one per point
+	Normals   []vec3.T     // optional, one per point
+	Radius    float32
+	Shape     PointShape
+	Material  Material
+
+	bvh *BVH
+}
+
+func CreatePointCloud(positions []vec3.T, colors []color.RGBA, radius float32, shape PointShape) *PointCloud {
+	p := &PointCloud{Positions: positions, Colors: colors, Radius: radius, Shape: shape}
+	p.Update()
+	return p
+}
+
+// Update builds the BVH again after the points or the radius were changed.
+func (p *PointCloud) Update() {
+	bounds := make([]vec3.Box, len(p.Positions))
+	r := vec3.T{p.Radius, p.Radius, p.Radius}
+	for i, position := range p.Positions {
+		bounds[i] = vec3.Box{Min: vec3.Sub(&position, &r), Max: vec3.Add(&position, &r)}
+	}
+	p.bvh = BuildBVH(bounds)
+}
+
+// LoadPointCloud reads a text file with one point per line: x y z, optionally
+// followed by a colour as r g b, either in [0, 1] or in [0, 255]. Lines
+// starting with # are ignored.
+func LoadPointCloud(filename string, radius float32, shape PointShape) (*PointCloud, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var positions []vec3.T
+	var colors [][3]float64
+	hasColors := true
+	maxComponent := 0.0
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s: line %d: invalid point definition: %v", filename, lineNumber, fields)
+		}
+		values := make([]float64, 0, 6)
+		for _, field := range fields[:int(math.Min(6, float64(len(fields))))] {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %v", filename, lineNumber, err)
+			}
+			values = append(values, value)
+		}
+		positions = append(positions, vec3.T{float32(values[0]), float32(values[1]), float32(values[2])})
+		if len(values) < 6 {
+			hasColors = false
+			continue
+		}
+		colors = append(colors, [3]float64{values[3], values[4], values[5]})
+		maxComponent = math.Max(maxComponent, math.Max(values[3], math.Max(values[4], values[5])))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Colours are only used if every point has one
+	var pointColors []color.RGBA
+	if hasColors && len(colors) > 0 {
+		scale := 255.0
+		if maxComponent > 1 {
+			scale = 1
+		}
+		pointColors = make([]color.RGBA, len(colors))
+		for i, c := range colors {
+			pointColors[i] = color.RGBA{
+				R: uint8(clampColorComponent(float32(c[0] * scale))),
+				G: uint8(clampColorComponent(float32(c[1] * scale))),
+				B: uint8(clampColorComponent(float32(c[2] * scale))),
+				A: 255,
+			}
+		}
+	}
+	return CreatePointCloud(positions, pointColors, radius, shape), nil
+}
+
+func (p *PointCloud) GetGeometryData() GeometryData {
+	return GeometryData{Vertices: p.Positions, Material: p.Material}
+}
+
+func (p *PointCloud) SetMaterial(material Material) {
+	p.Material = material
+}
+
+func (p *PointCloud) Bounds() vec3.Box {
+	return p.bvh.Bounds()
+}
+
+func (p *PointCloud) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	var normal vec3.T
+	index, t, ok := p.bvh.Intersect(ray, func(i int, ray Ray) (float32, bool) {
+		var t float32
+		var n vec3.T
+		var hit bool
+		if p.Shape == PointDisk {
+			t, n, hit = p.intersectDisk(i, ray)
+		} else {
+			t, n, hit = p.intersectSphere(i, ray)
+		}
+		if hit {
+			normal = n
+		}
+		return t, hit
+	})
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+
+	intersection := newIntersection(ray, t)
+	intersection.Normal = normal
+	intersection.GeometricNormal = normal
+	intersection.Material = p.Material
+	if len(p.Colors) == len(p.Positions) {
+		intersection.Material.Color = p.Colors[index]
+	}
+	return intersection, true
+}
+
+func (p *PointCloud) intersectSphere(i int, ray Ray) (float32, vec3.T, bool) {
+	sphere := Sphere{Center: p.Positions[i], Radius: p.Radius}
+	intersection, ok := sphere.Intersect(ray)
+	return intersection.IntersectionDistance, intersection.Normal, ok
+}
+
+func (p *PointCloud) intersectDisk(i int, ray Ray) (float32, vec3.T, bool) {
+	center := p.Positions[i]
+	var normal vec3.T
+	if len(p.Normals) == len(p.Positions) {
+		normal = p.Normals[i].Normalized()
+	} else {
+		normal = ray.Direction.Normalized()
+	}
+
+	// Intersect the plane of the disk, then check the distance to the center
+	denominator := vec3.Dot(&ray.Direction, &normal)
+	if denominator == 0 {
+		return 0, normal, false
+	}
+	toCenter := vec3.Sub(&center, &ray.Origin)
+	t := vec3.Dot(&toCenter, &normal) / denominator
+	if t < ray.TMin || t > ray.TMax {
+		return 0, normal, false
+	}
+	point := ray.At(t)
+	if vec3.SquareDistance(&point, &center) > p.Radius*p.Radius {
+		return 0, normal, false
+	}
+
+	// Face the side the ray came from
+	if denominator > 0 {
+		normal.Invert()
+	}
+	return t, normal, true
+}
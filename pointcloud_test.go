@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// TestPointCloudMatchesBruteForce checks the BVH against testing every point.
+func TestPointCloudMatchesBruteForce(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	positions := make([]vec3.T, 2000)
+	for i := range positions {
+		positions[i] = vec3.T{random.Float32()*10 - 5, random.Float32()*10 - 5, random.Float32()*10 - 5}
+	}
+	for _, shape := range []PointShape{PointSphere, PointDisk} {
+		p := CreatePointCloud(positions, nil, 0.1, shape)
+		for i := 0; i < 200; i++ {
+			origin := vec3.T{random.Float32()*20 - 10, random.Float32()*20 - 10, 20}
+			target := positions[random.Intn(len(positions))]
+			target.Add(&vec3.T{random.Float32()*0.2 - 0.1, random.Float32()*0.2 - 0.1, 0})
+			ray := CreateRay(origin, vec3.Sub(&target, &origin))
+
+			wantT, wantOK := float32(math.MaxFloat32), false
+			for j := range positions {
+				var tHit float32
+				var ok bool
+				if shape == PointDisk {
+					tHit, _, ok = p.intersectDisk(j, ray)
+				} else {
+					tHit, _, ok = p.intersectSphere(j, ray)
+				}
+				if ok && tHit < wantT {
+					wantT, wantOK = tHit, true
+				}
+			}
+			hit, ok := p.Intersect(ray)
+			if ok != wantOK || (ok && hit.IntersectionDistance != wantT) {
+				t.Fatalf("shape %d, ray %d: hit %v at %v, want %v at %v", shape, i, ok, hit.IntersectionDistance, wantOK, wantT)
+			}
+		}
+	}
+}
+
+func TestPointCloudDisks(t *testing.T) {
+	p := CreatePointCloud([]vec3.T{{0, 0, 0}}, []color.RGBA{{255, 0, 0, 255}}, 1, PointDisk)
+	hit, ok := p.Intersect(CreateRay(vec3.T{0.5, 0.5, 3}, vec3.T{0, 0, -1}))
+	if !ok {
+		t.Fatal("ray misses the disk")
+	}
+	if hit.IntersectionDistance != 3 || hit.Normal != (vec3.T{0, 0, 1}) {
+		t.Errorf("hit at %v with normal %v, want 3 and %v", hit.IntersectionDistance, hit.Normal, vec3.T{0, 0, 1})
+	}
+	if hit.Material.Color != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("colour %v, want the colour of the point", hit.Material.Color)
+	}
+
+	// Oriented disks are seen edge-on from the side
+	p.Normals = []vec3.T{{1, 0, 0}}
+	if _, ok := p.Intersect(CreateRay(vec3.T{0, 0.5, 3}, vec3.T{0, 0, -1})); ok {
+		t.Error("ray hits an oriented disk edge-on")
+	}
+	hit, ok = p.Intersect(CreateRay(vec3.T{3, 0.5, 0}, vec3.T{-1, 0, 0}))
+	if !ok || hit.Normal != (vec3.T{1, 0, 0}) {
+		t.Errorf("hit %v with normal %v, want a hit with normal %v", ok, hit.Normal, vec3.T{1, 0, 0})
+	}
+}
+
+func TestLoadPointCloud(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		colors  []color.RGBA
+	}{
+		{"unit colours", "# points\n0 0 0 1 0.5 0\n1,2,3,0,0,1\n", []color.RGBA{{255, 127, 0, 255}, {0, 0, 255, 255}}},
+		{"byte colours", "0 0 0 255 128 0\n\n1 2 3 0 0 10\n", []color.RGBA{{255, 128, 0, 255}, {0, 0, 10, 255}}},
+		{"some colours", "0 0 0 1 1 1\n1 2 3\n", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := LoadPointCloud(writeTestFile(t, "points.xyz", test.content), 0.1, PointSphere)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Positions) != 2 || p.Positions[1] != (vec3.T{1, 2, 3}) {
+				t.Errorf("positions %v", p.Positions)
+			}
+			if len(p.Colors) != len(test.colors) {
+				t.Fatalf("colours %v, want %v", p.Colors, test.colors)
+			}
+			for i := range p.Colors {
+				if p.Colors[i] != test.colors[i] {
+					t.Errorf("colour %d is %v, want %v", i, p.Colors[i], test.colors[i])
+				}
+			}
+		})
+	}
+
+	if _, err := LoadPointCloud(writeTestFile(t, "points.xyz", "0 0\n"), 0.1, PointSphere); err == nil {
+		t.Error("point with two coordinates is accepted")
+	}
+}
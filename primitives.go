@@ -0,0 +1,302 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// Sphere, Box, Cylinder and HalfSpace are analytic solids. They can be
+// rendered on their own or combined with CSG.
+
+type Sphere struct {
+	Center   vec3.T
+	Radius   float32
+	Material Material
+}
+
+func CreateSphere(radius float32, center vec3.T) Sphere {
+	return Sphere{Center: center, Radius: radius}
+}
+
+func (s *Sphere) GetGeometryData() GeometryData {
+	return GeometryData{Material: s.Material}
+}
+
+func (s *Sphere) SetMaterial(material Material) {
+	s.Material = material
+}
+
+func (s *Sphere) Bounds() vec3.Box {
+	r := vec3.T{s.Radius, s.Radius, s.Radius}
+	return vec3.Box{Min: vec3.Sub(&s.Center, &r), Max: vec3.Add(&s.Center, &r)}
+}
+
+func (s *Sphere) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return intersectSpans(ray, s.Spans(ray))
+}
+
+func (s *Sphere) Spans(ray Ray) []Span {
+	// Solve |origin + t*direction - center|^2 = radius^2 for t
+	oc := vec3.Sub(&ray.Origin, &s.Center)
+	a := float64(vec3.Dot(&ray.Direction, &ray.Direction))
+	halfB := float64(vec3.Dot(&oc, &ray.Direction))
+	c := float64(vec3.Dot(&oc, &oc)) - float64(s.Radius)*float64(s.Radius)
+	discriminant := halfB*halfB - a*c
+	if discriminant < 0 || a == 0 {
+		return nil
+	}
+	sqrtD := math.Sqrt(discriminant)
+	t0 := float32((-halfB - sqrtD) / a)
+	t1 := float32((-halfB + sqrtD) / a)
+
+	surface := func(t float32) RayFaceIntersection {
+		intersection := newIntersection(ray, t)
+		intersection.Normal = vec3.Sub(&intersection.IntersectionPoint, &s.Center)
+		intersection.Normal.Normalize()
+		intersection.GeometricNormal = intersection.Normal
+		intersection.Material = s.Material
+		return intersection
+	}
+	return []Span{{Enter: surface(t0), Exit: surface(t1)}}
+}
+
+// Box is aligned to the axes.
+type Box struct {
+	Min, Max vec3.T
+	Material Material
+}
+
+func CreateBox(width, height, depth float32, center vec3.T) Box {
+	halfSize := vec3.T{width / 2, height / 2, depth / 2}
+	return Box{Min: vec3.Sub(&center, &halfSize), Max: vec3.Add(&center, &halfSize)}
+}
+
+func (b *Box) GetGeometryData() GeometryData {
+	return GeometryData{Material: b.Material}
+}
+
+func (b *Box) SetMaterial(material Material) {
+	b.Material = material
+}
+
+func (b *Box) Bounds() vec3.Box {
+	return vec3.Box{Min: b.Min, Max: b.Max}
+}
+
+func (b *Box) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return intersectSpans(ray, b.Spans(ray))
+}
+
+func (b *Box) Spans(ray Ray) []Span {
+	ray.TMin, ray.TMax = -math.MaxFloat32, math.MaxFloat32
+	tNear, tFar, ok := ray.intersectBox(vec3.Box{Min: b.Min, Max: b.Max})
+	if !ok {
+		return nil
+	}
+
+	surface := func(t float32) RayFaceIntersection {
+		intersection := newIntersection(ray, t)
+
+		// The normal is along the axis where the point is closest to a side
+		center := vec3.Interpolate(&b.Min, &b.Max, 0.5)
+		halfSize := vec3.Sub(&b.Max, &center)
+		local := vec3.Sub(&intersection.IntersectionPoint, &center)
+		axis, best := 0, float32(-1)
+		for i := 0; i < 3; i++ {
+			if halfSize[i] == 0 {
+				continue
+			}
+			if d := abs32(local[i]) / halfSize[i]; d > best {
+				axis, best = i, d
+			}
+		}
+		if local[axis] < 0 {
+			intersection.Normal[axis] = -1
+		} else {
+			intersection.Normal[axis] = 1
+		}
+		intersection.GeometricNormal = intersection.Normal
+		intersection.Material = b.Material
+		return intersection
+	}
+	return []Span{{Enter: surface(tNear), Exit: surface(tFar)}}
+}
+
+// Cylinder is a capped cylinder that starts at Base and extends Height along
+// Axis.
+type Cylinder struct {
+	Base     vec3.T
+	Axis     vec3.T
+	Radius   float32
+	Height   float32
+	Material Material
+}
+
+func CreateCylinder(radius, height float32, base, axis vec3.T) Cylinder {
+	return Cylinder{Base: base, Axis: axis.Normalized(), Radius: radius, Height: height}
+}
+
+func (c *Cylinder) GetGeometryData() GeometryData {
+	return GeometryData{Material: c.Material}
+}
+
+func (c *Cylinder) SetMaterial(material Material) {
+	c.Material = material
+}
+
+func (c *Cylinder) Bounds() vec3.Box {
+	top := c.Axis.Scaled(c.Height)
+	top.Add(&c.Base)
+
+	// The extent of the caps along each axis depends on how tilted they are
+	var extent vec3.T
+	for i := 0; i < 3; i++ {
+		extent[i] = c.Radius * float32(math.Sqrt(math.Max(0, 1-float64(c.Axis[i]*c.Axis[i]))))
+	}
+	min := vec3.Min(&c.Base, &top)
+	max := vec3.Max(&c.Base, &top)
+	return vec3.Box{Min: vec3.Sub(&min, &extent), Max: vec3.Add(&max, &extent)}
+}
+
+func (c *Cylinder) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return intersectSpans(ray, c.Spans(ray))
+}
+
+func (c *Cylinder) Spans(ray Ray) []Span {
+	// Split the ray into the parts along and perpendicular to the axis
+	oc := vec3.Sub(&ray.Origin, &c.Base)
+	originAlong := vec3.Dot(&oc, &c.Axis)
+	directionAlong := vec3.Dot(&ray.Direction, &c.Axis)
+	axisOrigin := c.Axis.Scaled(originAlong)
+	axisDirection := c.Axis.Scaled(directionAlong)
+	perpOrigin := vec3.Sub(&oc, &axisOrigin)
+	perpDirection := vec3.Sub(&ray.Direction, &axisDirection)
+
+	// Interval inside the infinite cylinder
+	tSideNear, tSideFar := -math.MaxFloat64, math.MaxFloat64
+	a := float64(vec3.Dot(&perpDirection, &perpDirection))
+	halfB := float64(vec3.Dot(&perpOrigin, &perpDirection))
+	cc := float64(vec3.Dot(&perpOrigin, &perpOrigin)) - float64(c.Radius)*float64(c.Radius)
+	if a == 0 {
+		// Parallel to the axis
+		if cc > 0 {
+			return nil
+		}
+	} else {
+		discriminant := halfB*halfB - a*cc
+		if discriminant < 0 {
+			return nil
+		}
+		sqrtD := math.Sqrt(discriminant)
+		tSideNear, tSideFar = (-halfB-sqrtD)/a, (-halfB+sqrtD)/a
+	}
+
+	// Interval between the caps
+	tCapNear, tCapFar := -math.MaxFloat64, math.MaxFloat64
+	if directionAlong == 0 {
+		if originAlong < 0 || originAlong > c.Height {
+			return nil
+		}
+	} else {
+		tCapNear = float64(-originAlong / directionAlong)
+		tCapFar = float64((c.Height - originAlong) / directionAlong)
+		if tCapNear > tCapFar {
+			tCapNear, tCapFar = tCapFar, tCapNear
+		}
+	}
+
+	tNear := math.Max(tSideNear, tCapNear)
+	tFar := math.Min(tSideFar, tCapFar)
+	if tNear > tFar {
+		return nil
+	}
+
+	surface := func(t float64, onSide bool) RayFaceIntersection {
+		intersection := newIntersection(ray, float32(t))
+		if onSide {
+			p := vec3.Sub(&intersection.IntersectionPoint, &c.Base)
+			along := c.Axis.Scaled(vec3.Dot(&p, &c.Axis))
+			intersection.Normal = vec3.Sub(&p, &along)
+			intersection.Normal.Normalize()
+		} else {
+			intersection.Normal = c.Axis
+			p := vec3.Sub(&intersection.IntersectionPoint, &c.Base)
+			if vec3.Dot(&p, &c.Axis) < c.Height/2 {
+				intersection.Normal.Invert()
+			}
+		}
+		intersection.GeometricNormal = intersection.Normal
+		intersection.Material = c.Material
+		return intersection
+	}
+	return []Span{{Enter: surface(tNear, tSideNear >= tCapNear), Exit: surface(tFar, tSideFar <= tCapFar)}}
+}
+
+// HalfSpace is everything on the side of the plane through Point that Normal
+// points away from. It is mostly useful to cut other solids with CSG.
+type HalfSpace struct {
+	Point    vec3.T
+	Normal   vec3.T
+	Material Material
+}
+
+func CreateHalfSpace(point, normal vec3.T) HalfSpace {
+	return HalfSpace{Point: point, Normal: normal.Normalized()}
+}
+
+func (h *HalfSpace) GetGeometryData() GeometryData {
+	return GeometryData{Material: h.Material}
+}
+
+func (h *HalfSpace) SetMaterial(material Material) {
+	h.Material = material
+}
+
+// Bounds returns a box that covers all of space, except for half-spaces that
+// are bounded along one of the axes.
+func (h *HalfSpace) Bounds() vec3.Box {
+	const inf = math.MaxFloat32
+	box := vec3.Box{Min: vec3.T{-inf, -inf, -inf}, Max: vec3.T{inf, inf, inf}}
+	for i := 0; i < 3; i++ {
+		other1, other2 := h.Normal[(i+1)%3], h.Normal[(i+2)%3]
+		if other1 != 0 || other2 != 0 {
+			continue
+		}
+		if h.Normal[i] > 0 {
+			box.Max[i] = h.Point[i]
+		} else {
+			box.Min[i] = h.Point[i]
+		}
+	}
+	return box
+}
+
+func (h *HalfSpace) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	return intersectSpans(ray, h.Spans(ray))
+}
+
+func (h *HalfSpace) Spans(ray Ray) []Span {
+	toPlane := vec3.Sub(&h.Point, &ray.Origin)
+	distance := vec3.Dot(&toPlane, &h.Normal)
+	directionAlong := vec3.Dot(&ray.Direction, &h.Normal)
+	if directionAlong == 0 {
+		// Parallel to the plane: either always inside or never
+		if distance >= 0 {
+			return []Span{{
+				Enter: unboundedIntersection(ray, -math.MaxFloat32),
+				Exit:  unboundedIntersection(ray, math.MaxFloat32),
+			}}
+		}
+		return nil
+	}
+
+	plane := newIntersection(ray, distance/directionAlong)
+	plane.Normal = h.Normal
+	plane.GeometricNormal = h.Normal
+	plane.Material = h.Material
+	if directionAlong > 0 {
+		return []Span{{Enter: unboundedIntersection(ray, -math.MaxFloat32), Exit: plane}}
+	}
+	return []Span{{Enter: plane, Exit: unboundedIntersection(ray, math.MaxFloat32)}}
+}
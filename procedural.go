@@ -0,0 +1,291 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// TextureContext describes the point at which a texture is evaluated.
+type TextureContext struct {
+	UV TextureCoordinate
+	// Position is the point in the space of the geometry, which does not
+	// change when the geometry is moved with a TransformedGeometry.
+	Position vec3.T
+	// DuvDx and DuvDy are the change of UV from one pixel to the next,
+	// which textures filter over. Zero if unknown.
+	DuvDx, DuvDy TextureCoordinate
+	// Normal is the shading normal and ViewDirection the unit vector
+	// towards the viewer, both in world space.
+	Normal, ViewDirection vec3.T
+}
+
+// Texture returns a colour as red, green, blue and alpha between 0 and 1.
+// Textures for scalar parameters use the red channel.
+type Texture interface {
+	Evaluate(ctx TextureContext) vec4.T
+}
+
+func (t *ImageTexture) Evaluate(ctx TextureContext) vec4.T {
+	return t.SampleFiltered(ctx.UV, ctx.DuvDx, ctx.DuvDy)
+}
+
+// ConstantTexture is the same colour everywhere.
+type ConstantTexture vec4.T
+
+func (t ConstantTexture) Evaluate(ctx TextureContext) vec4.T {
+	return vec4.T(t)
+}
+
+// TextureSpace selects what procedural textures are evaluated on.
+type TextureSpace int
+
+const (
+	// TextureSpaceUV uses the texture coordinate as the point (U, V, 0).
+	TextureSpaceUV TextureSpace = iota
+	// TextureSpaceObject uses the position in the space of the geometry,
+	// which needs no texture coordinates and has no seams.
+	TextureSpaceObject
+)
+
+func (s TextureSpace) point(ctx TextureContext, scale float32) vec3.T {
+	p := ctx.Position
+	if s == TextureSpaceUV {
+		p = vec3.T{float32(ctx.UV.U), float32(ctx.UV.V), 0}
+	}
+	return p.Scaled(scale)
+}
+
+// CheckerTexture alternates between two colours on a grid with Scale cells
+// per unit, squares in UV space and cubes in object space. In UV space it is
+// averaged over the footprint of the pixel, so it does not alias in the
+// distance.
+type CheckerTexture struct {
+	Even, Odd vec4.T
+	Scale     float32
+	Space     TextureSpace
+}
+
+func (t CheckerTexture) Evaluate(ctx TextureContext) vec4.T {
+	p := t.Space.point(ctx, t.Scale)
+	if t.Space == TextureSpaceUV {
+		// Half the size of the footprint in cells
+		ds := math.Max(math.Abs(ctx.DuvDx.U), math.Abs(ctx.DuvDy.U)) * float64(t.Scale)
+		dt := math.Max(math.Abs(ctx.DuvDx.V), math.Abs(ctx.DuvDy.V)) * float64(t.Scale)
+		s, u := float64(p[0]), float64(p[1])
+		if math.Floor(s-ds) != math.Floor(s+ds) || math.Floor(u-dt) != math.Floor(u+dt) {
+			return t.filtered(s, u, ds, dt)
+		}
+	}
+	sum := math.Floor(float64(p[0])) + math.Floor(float64(p[1]))
+	if t.Space == TextureSpaceObject {
+		sum += math.Floor(float64(p[2]))
+	}
+	if math.Mod(sum, 2) == 0 {
+		return t.Even
+	}
+	return t.Odd
+}
+
+// filtered averages the checker over the box of half widths ds and dt
+// around (s, t) in cells, see Physically Based Rendering.
+func (t CheckerTexture) filtered(s, u, ds, dt float64) vec4.T {
+	// Integral of the function that is 1 in odd cells
+	odd := func(x float64) float64 {
+		return math.Floor(x/2) + 2*math.Max(x/2-math.Floor(x/2)-0.5, 0)
+	}
+	oddS, oddT := 0.5, 0.5
+	if ds > 0 && ds <= 1 {
+		oddS = (odd(s+ds) - odd(s-ds)) / (2 * ds)
+	}
+	if dt > 0 && dt <= 1 {
+		oddT = (odd(u+dt) - odd(u-dt)) / (2 * dt)
+	}
+	// Cells are odd where exactly one of the coordinates is
+	fraction := float32(oddS + oddT - 2*oddS*oddT)
+	return vec4.Interpolate(&t.Even, &t.Odd, fraction)
+}
+
+// GradientTexture blends from Start to End along Direction. The blend is
+// Start where the point projected onto Direction is 0 and End where it is
+// the length of Direction.
+type GradientTexture struct {
+	Start, End vec4.T
+	Direction  vec3.T
+	Space      TextureSpace
+}
+
+func (t GradientTexture) Evaluate(ctx TextureContext) vec4.T {
+	p := t.Space.point(ctx, 1)
+	lengthSqr := t.Direction.LengthSqr()
+	if lengthSqr == 0 {
+		return t.Start
+	}
+	s := vec3.Dot(&p, &t.Direction) / lengthSqr
+	s = float32(math.Max(0, math.Min(1, float64(s))))
+	return vec4.Interpolate(&t.Start, &t.End, s)
+}
+
+// NoiseTexture blends between Low and High by fractal Brownian motion, a sum
+// of Octaves layers of Perlin noise, each with Lacunarity times the frequency
+// and Gain times the amplitude of the one before.
+type NoiseTexture struct {
+	Low, High  vec4.T
+	Scale      float32
+	Octaves    int
+	Lacunarity float32
+	Gain       float32
+	Space      TextureSpace
+}
+
+// CreateNoiseTexture creates a noise texture with the usual settings of 2 for
+// Lacunarity and 0.5 for Gain.
+func CreateNoiseTexture(low, high vec4.T, scale float32, octaves int, space TextureSpace) NoiseTexture {
+	return NoiseTexture{Low: low, High: high, Scale: scale, Octaves: octaves, Lacunarity: 2, Gain: 0.5, Space: space}
+}
+
+func (t NoiseTexture) Evaluate(ctx TextureContext) vec4.T {
+	n := fbm(t.Space.point(ctx, t.Scale), t.Octaves, t.Lacunarity, t.Gain)
+	return vec4.Interpolate(&t.Low, &t.High, n*0.5+0.5)
+}
+
+// MarbleTexture is stripes along X that are distorted by turbulence.
+type MarbleTexture struct {
+	Vein, Base vec4.T
+	Scale      float32 // stripes per unit
+	Turbulence float32 // strength of the distortion
+	Octaves    int
+	Space      TextureSpace
+}
+
+func (t MarbleTexture) Evaluate(ctx TextureContext) vec4.T {
+	p := t.Space.point(ctx, t.Scale)
+	s := math.Sin(float64(p[0]+t.Turbulence*turbulence(p, t.Octaves)) * math.Pi)
+	// Sharpen the veins
+	blend := float32(math.Pow(1-math.Abs(s), 4))
+	return vec4.Interpolate(&t.Base, &t.Vein, blend)
+}
+
+// WoodTexture is rings around the Y axis, made irregular by noise.
+type WoodTexture struct {
+	Light, Dark vec4.T
+	Scale       float32 // rings per unit
+	Turbulence  float32 // how much the rings wobble
+	Space       TextureSpace
+}
+
+func (t WoodTexture) Evaluate(ctx TextureContext) vec4.T {
+	p := t.Space.point(ctx, t.Scale)
+	radius := math.Sqrt(float64(p[0]*p[0] + p[2]*p[2]))
+	if t.Space == TextureSpaceUV {
+		radius = math.Sqrt(float64(p[0]*p[0] + p[1]*p[1]))
+	}
+	noisy := p.Scaled(0.5)
+	radius += float64(t.Turbulence * perlinNoise(noisy))
+	ring := radius - math.Floor(radius)
+	// Rings darken gradually and end sharply, like late wood
+	return vec4.Interpolate(&t.Light, &t.Dark, float32(math.Pow(ring, 3)))
+}
+
+// perlinPermutation is the permutation of Ken Perlin's reference
+// implementation of improved noise, repeated once.
+var perlinPermutation = func() [512]int {
+	p := [256]int{151, 160, 137, 91, 90, 15, 131, 13, 201, 95, 96, 53, 194, 233, 7, 225, 140, 36, 103, 30, 69, 142,
+		8, 99, 37, 240, 21, 10, 23, 190, 6, 148, 247, 120, 234, 75, 0, 26, 197, 62, 94, 252, 219, 203, 117, 35, 11, 32,
+		57, 177, 33, 88, 237, 149, 56, 87, 174, 20, 125, 136, 171, 168, 68, 175, 74, 165, 71, 134, 139, 48, 27, 166,
+		77, 146, 158, 231, 83, 111, 229, 122, 60, 211, 133, 230, 220, 105, 92, 41, 55, 46, 245, 40, 244, 102, 143, 54,
+		65, 25, 63, 161, 1, 216, 80, 73, 209, 76, 132, 187, 208, 89, 18, 169, 200, 196, 135, 130, 116, 188, 159, 86,
+		164, 100, 109, 198, 173, 186, 3, 64, 52, 217, 226, 250, 124, 123, 5, 202, 38, 147, 118, 126, 255, 82, 85, 212,
+		207, 206, 59, 227, 47, 16, 58, 17, 182, 189, 28, 42, 223, 183, 170, 213, 119, 248, 152, 2, 44, 154, 163, 70,
+		221, 153, 101, 155, 167, 43, 172, 9, 129, 22, 39, 253, 19, 98, 108, 110, 79, 113, 224, 232, 178, 185, 112, 104,
+		218, 246, 97, 228, 251, 34, 242, 193, 238, 210, 144, 12, 191, 179, 162, 241, 81, 51, 145, 235, 249, 14, 239,
+		107, 49, 192, 214, 31, 181, 199, 106, 157, 184, 84, 204, 176, 115, 121, 50, 45, 127, 4, 150, 254, 138, 236,
+		205, 93, 222, 114, 67, 29, 24, 72, 243, 141, 128, 195, 78, 66, 215, 61, 156, 180}
+	var result [512]int
+	for i := range result {
+		result[i] = p[i%256]
+	}
+	return result
+}()
+
+// perlinNoise returns improved Perlin noise, which is between about -1 and 1
+// and 0 at integer points.
+func perlinNoise(p vec3.T) float32 {
+	x, y, z := float64(p[0]), float64(p[1]), float64(p[2])
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	xi, yi, zi := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z = x-fx, y-fy, z-fz
+	u, v, w := fade(x), fade(y), fade(z)
+
+	perm := &perlinPermutation
+	a := perm[xi] + yi
+	aa, ab := perm[a]+zi, perm[a+1]+zi
+	b := perm[xi+1] + yi
+	ba, bb := perm[b]+zi, perm[b+1]+zi
+
+	return float32(lerp(w,
+		lerp(v,
+			lerp(u, grad(perm[aa], x, y, z), grad(perm[ba], x-1, y, z)),
+			lerp(u, grad(perm[ab], x, y-1, z), grad(perm[bb], x-1, y-1, z))),
+		lerp(v,
+			lerp(u, grad(perm[aa+1], x, y, z-1), grad(perm[ba+1], x-1, y, z-1)),
+			lerp(u, grad(perm[ab+1], x, y-1, z-1), grad(perm[bb+1], x-1, y-1, z-1)))))
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// grad returns the dot product of (x, y, z) with one of 12 gradient
+// directions picked by hash.
+func grad(hash int, x, y, z float64) float64 {
+	h := hash & 15
+	u := y
+	if h < 8 {
+		u = x
+	}
+	v := z
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// fbm sums octaves of noise and normalizes the result to about -1 to 1.
+func fbm(p vec3.T, octaves int, lacunarity, gain float32) float32 {
+	var sum, total float32
+	amplitude := float32(1)
+	for i := 0; i < maxInt(octaves, 1); i++ {
+		sum += amplitude * perlinNoise(p)
+		total += amplitude
+		p.Scale(lacunarity)
+		amplitude *= gain
+	}
+	return sum / total
+}
+
+// turbulence sums the absolute value of octaves of noise, which gives the
+// creases that marble veins follow.
+func turbulence(p vec3.T, octaves int) float32 {
+	var sum float32
+	amplitude := float32(1)
+	for i := 0; i < maxInt(octaves, 1); i++ {
+		sum += amplitude * abs32(perlinNoise(p))
+		p.Scale(2)
+		amplitude *= 0.5
+	}
+	return sum
+}
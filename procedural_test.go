@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+var black = vec4.T{0, 0, 0, 1}
+
+func TestCheckerTexture(t *testing.T) {
+	uv := CheckerTexture{Even: white, Odd: black, Scale: 2}
+	object := CheckerTexture{Even: white, Odd: black, Scale: 1, Space: TextureSpaceObject}
+	tests := []struct {
+		texture CheckerTexture
+		ctx     TextureContext
+		want    vec4.T
+	}{
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.25, V: 0.25}}, white},
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.75, V: 0.25}}, black},
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.75, V: 0.75}}, white},
+		{object, TextureContext{Position: vec3.T{0.5, 0.5, 0.5}}, white},
+		{object, TextureContext{Position: vec3.T{0.5, 0.5, 1.5}}, black},
+		{object, TextureContext{Position: vec3.T{-0.5, 0.5, 0.5}}, black},
+		// Small footprints inside a cell are not filtered
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.25, V: 0.25}, DuvDx: TextureCoordinate{U: 0.01}, DuvDy: TextureCoordinate{V: 0.01}}, white},
+		// Footprints of many cells average to grey
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.25, V: 0.25}, DuvDx: TextureCoordinate{U: 10}, DuvDy: TextureCoordinate{V: 10}}, vec4.T{0.5, 0.5, 0.5, 1}},
+		// Half the footprint is in the odd cell on the right
+		{uv, TextureContext{UV: TextureCoordinate{U: 0.5, V: 0.25}, DuvDx: TextureCoordinate{U: 0.1}}, vec4.T{0.5, 0.5, 0.5, 1}},
+	}
+	for _, test := range tests {
+		if got := test.texture.Evaluate(test.ctx); !colorApproxEqual(got, test.want, 1e-5) {
+			t.Errorf("%+v: got %v, want %v", test.ctx, got, test.want)
+		}
+	}
+}
+
+func TestGradientTexture(t *testing.T) {
+	texture := GradientTexture{Start: black, End: white, Direction: vec3.T{2, 0, 0}, Space: TextureSpaceObject}
+	tests := []struct {
+		x    float32
+		want vec4.T
+	}{
+		{0, black},
+		{1, vec4.T{0.5, 0.5, 0.5, 1}},
+		{2, white},
+		{-1, black},
+		{5, white},
+	}
+	for _, test := range tests {
+		if got := texture.Evaluate(TextureContext{Position: vec3.T{test.x, 3, 4}}); !colorApproxEqual(got, test.want, 1e-5) {
+			t.Errorf("x %v: got %v, want %v", test.x, got, test.want)
+		}
+	}
+}
+
+func TestPerlinNoise(t *testing.T) {
+	for _, p := range []vec3.T{{0, 0, 0}, {1, 2, 3}, {-4, 7, 100}} {
+		if n := perlinNoise(p); n != 0 {
+			t.Errorf("noise at the integer point %v is %v, want 0", p, n)
+		}
+	}
+	random := rand.New(rand.NewSource(1))
+	var min, max float32
+	for i := 0; i < 10000; i++ {
+		p := vec3.T{random.Float32()*20 - 10, random.Float32()*20 - 10, random.Float32()*20 - 10}
+		n := perlinNoise(p)
+		if n < -1.1 || n > 1.1 {
+			t.Fatalf("noise at %v is %v", p, n)
+		}
+		min, max = float32(math.Min(float64(min), float64(n))), float32(math.Max(float64(max), float64(n)))
+		// Noise is continuous
+		q := vec3.T{p[0] + 1e-3, p[1], p[2]}
+		if d := math.Abs(float64(perlinNoise(q) - n)); d > 1e-2 {
+			t.Fatalf("noise jumps by %v between %v and %v", d, p, q)
+		}
+	}
+	if min > -0.5 || max < 0.5 {
+		t.Errorf("noise only ranges from %v to %v", min, max)
+	}
+}
+
+func TestProceduralTexturesStayBetweenColors(t *testing.T) {
+	low, high := vec4.T{0.2, 0.2, 0.2, 1}, vec4.T{0.8, 0.8, 0.8, 1}
+	textures := map[string]Texture{
+		"noise":  CreateNoiseTexture(low, high, 4, 5, TextureSpaceObject),
+		"marble": MarbleTexture{Vein: low, Base: high, Scale: 3, Turbulence: 2, Octaves: 4, Space: TextureSpaceObject},
+		"wood":   WoodTexture{Light: high, Dark: low, Scale: 5, Turbulence: 0.5, Space: TextureSpaceObject},
+	}
+	random := rand.New(rand.NewSource(1))
+	for name, texture := range textures {
+		var min, max float32 = 1, 0
+		for i := 0; i < 2000; i++ {
+			ctx := TextureContext{Position: vec3.T{random.Float32()*4 - 2, random.Float32()*4 - 2, random.Float32()*4 - 2}}
+			c := texture.Evaluate(ctx)
+			if c[0] < 0.2-1e-5 || c[0] > 0.8+1e-5 || c[3] != 1 {
+				t.Fatalf("%s: colour %v at %v is not between %v and %v", name, c, ctx.Position, low, high)
+			}
+			// The same point gives the same colour
+			if again := texture.Evaluate(ctx); again != c {
+				t.Fatalf("%s: %v and then %v at %v", name, c, again, ctx.Position)
+			}
+			min, max = float32(math.Min(float64(min), float64(c[0]))), float32(math.Max(float64(max), float64(c[0])))
+		}
+		if max-min < 0.2 {
+			t.Errorf("%s: colours only range from %v to %v", name, min, max)
+		}
+	}
+}
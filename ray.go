@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// RayEpsilon is the relative distance by which the origins of spawned rays are
+// moved away from the surface they start on, so that they do not hit it again
+// because of floating point error.
+const RayEpsilon = 1e-4
+
+// Ray only reports hits at distances inside [TMin, TMax], measured in units
+// of the length of Direction.
+type Ray struct {
+	Origin    vec3.T
+	Direction vec3.T
+	TMin      float32
+	TMax      float32
+
+	// The differentials are the rays one pixel to the right and one pixel
+	// down, which give the footprint of the ray on the surfaces it hits for
+	// texture filtering. Rays without them, like shadow rays, have
+	// HasDifferentials false.
+	HasDifferentials bool
+	RxOrigin         vec3.T
+	RxDirection      vec3.T
+	RyOrigin         vec3.T
+	RyDirection      vec3.T
+}
+
+// CreateRay creates a ray that extends from origin to infinity.
+func CreateRay(origin, direction vec3.T) Ray {
+	return Ray{Origin: origin, Direction: direction, TMin: 0, TMax: math.MaxFloat32}
+}
+
+// At returns the point at distance t along the ray.
+func (r Ray) At(t float32) vec3.T {
+	offset := r.Direction.Scaled(t)
+	return vec3.Add(&r.Origin, &offset)
+}
+
+// OffsetRayOrigin moves point along the geometric normal to the side of the
+// surface that direction points to. The offset grows with the magnitude of
+// the coordinates, since so does the floating point error of the hit point.
+func OffsetRayOrigin(point, geometricNormal, direction vec3.T) vec3.T {
+	magnitude := float32(1)
+	for _, c := range point {
+		magnitude = float32(math.Max(float64(magnitude), math.Abs(float64(c))))
+	}
+	offset := geometricNormal.Normalized()
+	offset.Scale(RayEpsilon * magnitude)
+	if vec3.Dot(&offset, &direction) < 0 {
+		offset.Invert()
+	}
+	return vec3.Add(&point, &offset)
+}
+
+// SpawnRay creates a secondary ray, for example a reflection ray, that starts
+// on a surface at point and goes in direction.
+func SpawnRay(point, geometricNormal, direction vec3.T) Ray {
+	return CreateRay(OffsetRayOrigin(point, geometricNormal, direction), direction)
+}
+
+// SpawnRayTo creates a secondary ray, for example a shadow ray, from a surface
+// at point to target. Hits are only reported between the two points, with the
+// far end shortened by the same epsilon as the origin.
+func SpawnRayTo(point, geometricNormal, target vec3.T) Ray {
+	toTarget := vec3.Sub(&target, &point)
+	origin := OffsetRayOrigin(point, geometricNormal, toTarget)
+	direction := vec3.Sub(&target, &origin)
+	ray := CreateRay(origin, direction)
+	ray.TMax = 1 - RayEpsilon
+	return ray
+}
+
+// intersectBox returns the interval of the ray inside the box, clipped to
+// [TMin, TMax], using the slab method.
+func (r Ray) intersectBox(box vec3.Box) (float32, float32, bool) {
+	tNear, tFar := r.TMin, r.TMax
+	for i := 0; i < 3; i++ {
+		invDir := 1 / r.Direction[i]
+		t0 := (box.Min[i] - r.Origin[i]) * invDir
+		t1 := (box.Max[i] - r.Origin[i]) * invDir
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		// NaN for rays that run inside a slab plane fails both tests
+		if t0 > tNear {
+			tNear = t0
+		}
+		if t1 < tFar {
+			tFar = t1
+		}
+		if tNear > tFar {
+			return 0, 0, false
+		}
+	}
+	return tNear, tFar, true
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/mat3"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// Node is a node of a scene graph. Its geometries, lights and children are
+// placed by Transform relative to the parent node, so that moving a node moves
+// everything below it. Flatten turns the graph into a Space for rendering.
+type Node struct {
+	Name       string
+	Transform  mat4.T
+	Children   []*Node
+	Geometries []Geometry
+	Lights     []Light
+
+	parent *Node
+}
+
+func CreateNode(name string) *Node {
+	return &Node{Name: name, Transform: mat4.Ident}
+}
+
+// AddChild attaches child to the node, detaching it from its previous parent.
+func (n *Node) AddChild(child *Node) {
+	if child.parent != nil {
+		child.parent.RemoveChild(child)
+	}
+	child.parent = n
+	n.Children = append(n.Children, child)
+}
+
+func (n *Node) RemoveChild(child *Node) {
+	for i, c := range n.Children {
+		if c == child {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			child.parent = nil
+			return
+		}
+	}
+}
+
+func (n *Node) Parent() *Node {
+	return n.parent
+}
+
+func (n *Node) AddGeometry(g Geometry) {
+	n.Geometries = append(n.Geometries, g)
+}
+
+func (n *Node) AddLight(l Light) {
+	n.Lights = append(n.Lights, l)
+}
+
+// Translate moves the node by offset in the space of its parent.
+func (n *Node) Translate(offset vec3.T) {
+	translation := mat4.Ident
+	translation.SetTranslation(&offset)
+	n.Transform.AssignMul(&translation, &n.Transform)
+}
+
+// Rotate rotates the node around the X, Y and Z axes, in this order, by the
+// given angles in degrees like Obj.Rotate. The rotation is around the origin
+// of the parent.
+func (n *Node) Rotate(degX, degY, degZ float64) {
+	rotation := mat4From3(rotationMatrix(degX, degY, degZ))
+	n.Transform.AssignMul(&rotation, &n.Transform)
+}
+
+// Scale scales the node along the axes of its parent.
+func (n *Node) Scale(factors vec3.T) {
+	scaling := mat4.Ident
+	scaling.ScaleVec3(&factors)
+	n.Transform.AssignMul(&scaling, &n.Transform)
+}
+
+// WorldTransform returns the transform from the space of the node to world
+// space, which combines the transforms of all its ancestors.
+func (n *Node) WorldTransform() mat4.T {
+	if n.parent == nil {
+		return n.Transform
+	}
+	// AssignMul reads its left operand while it writes, so it must not be
+	// the result
+	var world mat4.T
+	parent := n.parent.WorldTransform()
+	world.AssignMul(&parent, &n.Transform)
+	return world
+}
+
+// Find returns the first node with the given name in depth first order,
+// starting with n itself, or nil if there is none.
+func (n *Node) Find(name string) *Node {
+	if n.Name == name {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.Find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Walk calls visit for n and all nodes below it, parents before their
+// children, together with their world transform.
+func (n *Node) Walk(visit func(node *Node, world mat4.T)) {
+	n.walk(n.WorldTransform(), visit)
+}
+
+func (n *Node) walk(world mat4.T, visit func(node *Node, world mat4.T)) {
+	visit(n, world)
+	for _, child := range n.Children {
+		childWorld := world
+		childWorld.AssignMul(&world, &child.Transform)
+		child.walk(childWorld, visit)
+	}
+}
+
+// Flatten collects the geometries and lights of n and all nodes below it into
+// a Space, with the world transforms of their nodes applied. Geometries of
+// nodes with a transform other than the identity are wrapped in a
+// TransformedGeometry, the geometries themselves are not changed.
+func (n *Node) Flatten() *Space {
+	var s Space
+	n.Walk(func(node *Node, world mat4.T) {
+		for _, g := range node.Geometries {
+			if world == mat4.Ident {
+				s.AddGeometry(g)
+			} else {
+				s.AddGeometry(CreateTransformedGeometry(g, world))
+			}
+		}
+		for _, l := range node.Lights {
+			l.Position = world.MulVec3(&l.Position)
+			s.AddLight(l)
+		}
+	})
+	return &s
+}
+
+// TransformedGeometry places a geometry in world space with an affine
+// transform. Rays are transformed into the space of the geometry and the
+// intersections back out, so any geometry can be moved, rotated and scaled
+// without changing its data.
+type TransformedGeometry struct {
+	Geometry  Geometry
+	Transform mat4.T
+
+	inverse mat4.T
+}
+
+func CreateTransformedGeometry(g Geometry, transform mat4.T) *TransformedGeometry {
+	t := &TransformedGeometry{Geometry: g}
+	t.SetTransform(transform)
+	return t
+}
+
+func (t *TransformedGeometry) SetTransform(transform mat4.T) {
+	t.Transform = transform
+	t.inverse = transform.Inverted()
+}
+
+func (t *TransformedGeometry) GetGeometryData() GeometryData {
+	return t.Geometry.GetGeometryData()
+}
+
+func (t *TransformedGeometry) SetMaterial(material Material) {
+	t.Geometry.SetMaterial(material)
+}
+
+func (t *TransformedGeometry) Bounds() vec3.Box {
+	local := t.Geometry.Bounds()
+	var box vec3.Box
+	for i := 0; i < 8; i++ {
+		corner := local.Min
+		for axis := 0; axis < 3; axis++ {
+			if i&(1<<uint(axis)) != 0 {
+				corner[axis] = local.Max[axis]
+			}
+		}
+		corner = t.Transform.MulVec3(&corner)
+		if i == 0 {
+			box = vec3.Box{Min: corner, Max: corner}
+		} else {
+			box.Min = vec3.Min(&box.Min, &corner)
+			box.Max = vec3.Max(&box.Max, &corner)
+		}
+	}
+	return box
+}
+
+func (t *TransformedGeometry) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	// The direction is not normalized, so distances along the local ray are
+	// the same as along the world ray
+	local := ray
+	local.Origin = t.inverse.MulVec3(&ray.Origin)
+	local.Direction = t.inverse.MulVec3W(&ray.Direction, 0)
+	intersection, ok := t.Geometry.Intersect(local)
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+
+	intersection.Ray = ray
+	intersection.IntersectionPoint = ray.At(intersection.IntersectionDistance)
+	intersection.Normal = t.transformNormal(intersection.Normal)
+	intersection.GeometricNormal = t.transformNormal(intersection.GeometricNormal)
+	if !intersection.Tangent.IsZero() {
+		intersection.Tangent = t.Transform.MulVec3W(&intersection.Tangent, 0)
+		intersection.Tangent.Normalize()
+	}
+	if !intersection.Bitangent.IsZero() {
+		intersection.Bitangent = t.Transform.MulVec3W(&intersection.Bitangent, 0)
+		intersection.Bitangent.Normalize()
+	}
+	intersection.DpDu = t.Transform.MulVec3W(&intersection.DpDu, 0)
+	intersection.DpDv = t.Transform.MulVec3W(&intersection.DpDv, 0)
+	intersection.DnDu = t.inverseTranspose(intersection.DnDu)
+	intersection.DnDv = t.inverseTranspose(intersection.DnDv)
+	return intersection, true
+}
+
+// SelectLevel lets the geometry adapt to the camera if it depends on it, see
+// LOD. The camera is moved into the space of the geometry, which keeps
+// projected sizes right for uniform scaling.
+func (t *TransformedGeometry) SelectLevel(c Camera) {
+	if g, ok := t.Geometry.(cameraDependent); ok {
+		c.Origin = t.inverse.MulVec3(&c.Origin)
+		g.SelectLevel(c)
+	}
+}
+
+// transformNormal transforms a normal to world space with the inverse
+// transpose of the transform, which keeps it perpendicular to the surface
+// under non-uniform scaling.
+func (t *TransformedGeometry) transformNormal(normal vec3.T) vec3.T {
+	n := t.inverseTranspose(normal)
+	if length := n.Length(); length > 0 && !math.IsInf(float64(length), 0) {
+		n.Scale(1 / length)
+	}
+	return n
+}
+
+// inverseTranspose multiplies v with the inverse transpose of the transform.
+func (t *TransformedGeometry) inverseTranspose(v vec3.T) vec3.T {
+	return vec3.T{
+		t.inverse[0][0]*v[0] + t.inverse[0][1]*v[1] + t.inverse[0][2]*v[2],
+		t.inverse[1][0]*v[0] + t.inverse[1][1]*v[1] + t.inverse[1][2]*v[2],
+		t.inverse[2][0]*v[0] + t.inverse[2][1]*v[1] + t.inverse[2][2]*v[2],
+	}
+}
+
+// mat4From3 turns a linear transform into a 4x4 matrix without translation.
+func mat4From3(m mat3.T) mat4.T {
+	result := mat4.Ident
+	for axis := 0; axis < 3; axis++ {
+		var basis vec3.T
+		basis[axis] = 1
+		column := m.MulVec3(&basis)
+		result[axis] = vec4.T{column[0], column[1], column[2], 0}
+	}
+	return result
+}
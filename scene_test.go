@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// armScene is a hierarchy of translated and rotated nodes. The origin of
+// the hand is at (1, 3, 0) in world space.
+func armScene() (root, arm, hand *Node) {
+	root = CreateNode("root")
+	root.Translate(vec3.T{0, 5, 0})
+	arm = CreateNode("arm")
+	arm.Rotate(0, 0, 90)
+	arm.Translate(vec3.T{1, 0, 0})
+	hand = CreateNode("hand")
+	hand.Translate(vec3.T{2, 0, 0})
+	root.AddChild(arm)
+	arm.AddChild(hand)
+	return root, arm, hand
+}
+
+func TestNodeWorldTransform(t *testing.T) {
+	_, _, hand := armScene()
+	world := hand.WorldTransform()
+
+	// Applying the transforms one after another up the hierarchy
+	for _, p := range []vec3.T{{0, 0, 0}, {1, 2, 3}} {
+		want := p
+		for n := hand; n != nil; n = n.Parent() {
+			want = n.Transform.MulVec3(&want)
+		}
+		if got := world.MulVec3(&p); !vecApproxEqual(got, want, 1e-5) {
+			t.Errorf("%v is at %v, want %v", p, got, want)
+		}
+	}
+	if origin := world.MulVec3(&vec3.Zero); !vecApproxEqual(origin, vec3.T{1, 3, 0}, 1e-5) {
+		t.Errorf("origin of the hand at %v, want %v", origin, vec3.T{1, 3, 0})
+	}
+}
+
+func TestNodeWalk(t *testing.T) {
+	root, arm, hand := armScene()
+	// Walking from a node below the root starts at its world transform
+	worlds := map[*Node]mat4.T{}
+	arm.Walk(func(node *Node, world mat4.T) {
+		worlds[node] = world
+	})
+	if len(worlds) != 2 {
+		t.Fatalf("visited %d nodes, want 2", len(worlds))
+	}
+	for _, n := range []*Node{arm, hand} {
+		want := n.WorldTransform()
+		got := worlds[n]
+		for _, p := range []vec3.T{{0, 0, 0}, {1, 2, 3}} {
+			if a, b := got.MulVec3(&p), want.MulVec3(&p); !vecApproxEqual(a, b, 1e-5) {
+				t.Errorf("%s: %v is at %v, want %v", n.Name, p, a, b)
+			}
+		}
+	}
+	if root.Find("hand") != hand || root.Find("foot") != nil {
+		t.Error("Find does not find the hand")
+	}
+}
+
+func TestNodeFlatten(t *testing.T) {
+	root, arm, hand := armScene()
+	sphere := CreateSphere(0.5, vec3.T{0, 0, 0})
+	hand.AddGeometry(&sphere)
+	hand.AddLight(CreateLight(vec3.T{0, 1, 0}, color.RGBA{255, 255, 255, 255}, 1, 1))
+
+	for _, n := range []*Node{root, arm} {
+		s := n.Flatten()
+		hit, ok := s.Intersect(CreateRay(vec3.T{1, 3, 10}, vec3.T{0, 0, -1}))
+		if !ok {
+			t.Fatalf("flattening %s: ray misses the sphere of the hand", n.Name)
+		}
+		if !vecApproxEqual(hit.IntersectionPoint, vec3.T{1, 3, 0.5}, 1e-4) || !vecApproxEqual(hit.Normal, vec3.T{0, 0, 1}, 1e-4) {
+			t.Errorf("flattening %s: hit at %v with normal %v", n.Name, hit.IntersectionPoint, hit.Normal)
+		}
+		// The light one unit along Y of the hand is rotated with the arm
+		if len(s.Lights) != 1 || !vecApproxEqual(s.Lights[0].Position, vec3.T{2, 3, 0}, 1e-5) {
+			t.Errorf("flattening %s: lights %v", n.Name, s.Lights)
+		}
+	}
+}
+
+func TestNodeReparent(t *testing.T) {
+	root, arm, hand := armScene()
+	root.AddChild(hand)
+	if len(arm.Children) != 0 || hand.Parent() != root {
+		t.Fatal("hand was not moved to the root")
+	}
+	world := hand.WorldTransform()
+	origin := world.MulVec3(&vec3.Zero)
+	if !vecApproxEqual(origin, vec3.T{2, 5, 0}, 1e-5) {
+		t.Errorf("origin of the hand at %v, want %v", origin, vec3.T{2, 5, 0})
+	}
+}
+
+func TestTransformedGeometryScaling(t *testing.T) {
+	// Non-uniform scaling keeps normals perpendicular to the surface
+	n := CreateNode("ellipsoid")
+	n.Scale(vec3.T{2, 1, 1})
+	n.Rotate(0, 0, 45)
+	sphere := CreateSphere(1, vec3.T{0, 0, 0})
+	n.AddGeometry(&sphere)
+	s := n.Flatten()
+
+	world := n.WorldTransform()
+	for i := 0; i < 16; i++ {
+		angle := float64(i) * math.Pi / 8
+		target := vec3.T{float32(math.Cos(angle)), float32(math.Sin(angle)), 0}
+		target = world.MulVec3(&target)
+		// From outside along the line through the centre
+		origin := target.Scaled(3)
+		hit, ok := s.Intersect(CreateRay(origin, vec3.Sub(&target, &origin)))
+		if !ok {
+			t.Fatalf("ray to %v misses", target)
+		}
+		if !vecApproxEqual(hit.IntersectionPoint, target, 1e-4) {
+			t.Errorf("hit at %v, want %v", hit.IntersectionPoint, target)
+		}
+		// The equator of the ellipsoid has its normal in the XY plane,
+		// perpendicular to the tangent of the equator
+		next := vec3.T{float32(math.Cos(angle + 1e-3)), float32(math.Sin(angle + 1e-3)), 0}
+		next = world.MulVec3(&next)
+		tangent := vec3.Sub(&next, &target)
+		tangent.Normalize()
+		if d := vec3.Dot(&hit.Normal, &tangent); math.Abs(float64(d)) > 1e-2 || math.Abs(float64(hit.Normal[2])) > 1e-3 {
+			t.Errorf("normal %v at %v is not perpendicular to the surface", hit.Normal, target)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ungerik/go3d/mat3"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// SDF is a signed distance function. Distance is negative inside the shape,
+// positive outside and must never be larger than the real distance to the
+// surface, so that sphere tracing does not step through it.
+type SDF interface {
+	Distance(p vec3.T) float32
+}
+
+// SDFFunc turns a plain function into an SDF.
+type SDFFunc func(p vec3.T) float32
+
+func (f SDFFunc) Distance(p vec3.T) float32 {
+	return f(p)
+}
+
+// The primitives below are centred at the origin, use SDFTranslate and
+// SDFRotate to place them.
+
+type SDFSphere struct {
+	Radius float32
+}
+
+func (s SDFSphere) Distance(p vec3.T) float32 {
+	return p.Length() - s.Radius
+}
+
+type SDFBox struct {
+	HalfSize vec3.T
+}
+
+func (b SDFBox) Distance(p vec3.T) float32 {
+	q := p.Absed()
+	q.Sub(&b.HalfSize)
+	outside := vec3.Max(&q, &vec3.Zero)
+	inside := float32(math.Min(math.Max(float64(q[0]), math.Max(float64(q[1]), float64(q[2]))), 0))
+	return outside.Length() + inside
+}
+
+// SDFRoundBox is a box with its edges and corners rounded off by Radius. The
+// rounding is inside HalfSize.
+type SDFRoundBox struct {
+	HalfSize vec3.T
+	Radius   float32
+}
+
+func (b SDFRoundBox) Distance(p vec3.T) float32 {
+	r := vec3.T{b.Radius, b.Radius, b.Radius}
+	inner := vec3.Sub(&b.HalfSize, &r)
+	return SDFBox{HalfSize: inner}.Distance(p) - b.Radius
+}
+
+// SDFTorus lies in the XZ plane.
+type SDFTorus struct {
+	MajorRadius float32
+	MinorRadius float32
+}
+
+func (t SDFTorus) Distance(p vec3.T) float32 {
+	ring := float32(math.Hypot(float64(p[0]), float64(p[2]))) - t.MajorRadius
+	return float32(math.Hypot(float64(ring), float64(p[1]))) - t.MinorRadius
+}
+
+type SDFCapsule struct {
+	A, B   vec3.T
+	Radius float32
+}
+
+func (c SDFCapsule) Distance(p vec3.T) float32 {
+	pa := vec3.Sub(&p, &c.A)
+	ba := vec3.Sub(&c.B, &c.A)
+	h := vec3.Dot(&pa, &ba) / vec3.Dot(&ba, &ba)
+	h = float32(math.Max(0, math.Min(1, float64(h))))
+	ba.Scale(h)
+	pa.Sub(&ba)
+	return pa.Length() - c.Radius
+}
+
+// SDFMandelbulb is the Mandelbulb fractal. Its distance is only an estimate,
+// so it is best traced with a StepScale below 1.
+type SDFMandelbulb struct {
+	Power      float32
+	Iterations int
+}
+
+func (m SDFMandelbulb) Distance(p vec3.T) float32 {
+	z := p
+	dr := 1.0
+	r := 0.0
+	power := float64(m.Power)
+	for i := 0; i < m.Iterations; i++ {
+		r = float64(z.Length())
+		if r > 2 {
+			break
+		}
+
+		// Raise z to the power in spherical coordinates and add p
+		theta := math.Acos(float64(z[2])/r) * power
+		phi := math.Atan2(float64(z[1]), float64(z[0])) * power
+		dr = math.Pow(r, power-1)*power*dr + 1
+		zr := math.Pow(r, power)
+		z = vec3.T{
+			float32(zr * math.Sin(theta) * math.Cos(phi)),
+			float32(zr * math.Sin(phi) * math.Sin(theta)),
+			float32(zr * math.Cos(theta)),
+		}
+		z.Add(&p)
+	}
+	if r == 0 {
+		return 0
+	}
+	return float32(0.5 * math.Log(r) * r / dr)
+}
+
+type SDFUnion struct {
+	A, B SDF
+}
+
+func (u SDFUnion) Distance(p vec3.T) float32 {
+	return float32(math.Min(float64(u.A.Distance(p)), float64(u.B.Distance(p))))
+}
+
+type SDFIntersection struct {
+	A, B SDF
+}
+
+func (i SDFIntersection) Distance(p vec3.T) float32 {
+	return float32(math.Max(float64(i.A.Distance(p)), float64(i.B.Distance(p))))
+}
+
+// SDFSubtraction is A with B cut out of it.
+type SDFSubtraction struct {
+	A, B SDF
+}
+
+func (s SDFSubtraction) Distance(p vec3.T) float32 {
+	return float32(math.Max(float64(s.A.Distance(p)), -float64(s.B.Distance(p))))
+}
+
+// SDFSmoothUnion blends A and B together where they are closer than K.
+type SDFSmoothUnion struct {
+	A, B SDF
+	K    float32
+}
+
+func (u SDFSmoothUnion) Distance(p vec3.T) float32 {
+	a, b := float64(u.A.Distance(p)), float64(u.B.Distance(p))
+	k := float64(u.K)
+	if k <= 0 {
+		return float32(math.Min(a, b))
+	}
+	h := math.Max(k-math.Abs(a-b), 0) / k
+	return float32(math.Min(a, b) - h*h*k/4)
+}
+
+// SDFRepeat repeats the shape infinitely with the given period along each
+// axis. A period of 0 leaves that axis alone. The shape has to fit inside
+// one period for the distance to stay correct.
+type SDFRepeat struct {
+	SDF    SDF
+	Period vec3.T
+}
+
+func (r SDFRepeat) Distance(p vec3.T) float32 {
+	for i := 0; i < 3; i++ {
+		if r.Period[i] != 0 {
+			period := float64(r.Period[i])
+			p[i] = float32(float64(p[i]) - period*math.Floor(float64(p[i])/period+0.5))
+		}
+	}
+	return r.SDF.Distance(p)
+}
+
+type SDFTranslate struct {
+	SDF    SDF
+	Offset vec3.T
+}
+
+func (t SDFTranslate) Distance(p vec3.T) float32 {
+	return t.SDF.Distance(vec3.Sub(&p, &t.Offset))
+}
+
+// SDFRotate rotates the shape by Rotation, which has to be orthonormal.
+type SDFRotate struct {
+	SDF      SDF
+	Rotation mat3.T
+}
+
+// RotateSDF rotates the shape around the X, Y and Z axes, in this order, by
+// the given angles in degrees like Obj.Rotate.
+func RotateSDF(sdf SDF, degX, degY, degZ float64) SDFRotate {
+	return SDFRotate{SDF: sdf, Rotation: rotationMatrix(degX, degY, degZ)}
+}
+
+func (r SDFRotate) Distance(p vec3.T) float32 {
+	// The inverse of a rotation is its transpose
+	inverse := r.Rotation
+	inverse.Transpose()
+	return r.SDF.Distance(inverse.MulVec3(&p))
+}
+
+// SDFScale scales the shape uniformly. Factor has to be positive, see
+// ScaleSDF.
+type SDFScale struct {
+	SDF    SDF
+	Factor float32
+}
+
+// ScaleSDF scales the shape uniformly by factor. Factors that are not
+// positive are rejected, they would turn the distances into NaN or flip
+// their sign.
+func ScaleSDF(sdf SDF, factor float32) (SDFScale, error) {
+	if !(factor > 0) {
+		return SDFScale{}, fmt.Errorf("invalid SDF scale factor %v, it has to be positive", factor)
+	}
+	return SDFScale{SDF: sdf, Factor: factor}, nil
+}
+
+func (s SDFScale) Distance(p vec3.T) float32 {
+	return s.SDF.Distance(p.Scaled(1/s.Factor)) * s.Factor
+}
+
+// SDFGeometry renders an SDF by sphere tracing it inside Box.
+type SDFGeometry struct {
+	Field    SDF
+	Box      vec3.Box
+	Material Material
+	// MaxSteps limits the number of steps taken along a ray.
+	MaxSteps int
+	// Epsilon is the distance to the surface at which a ray counts as hit,
+	// relative to the distance travelled along the ray.
+	Epsilon float32
+	// StepScale shortens the steps for fields that overestimate the distance.
+	StepScale float32
+}
+
+func CreateSDFGeometry(field SDF, box vec3.Box) *SDFGeometry {
+	return &SDFGeometry{Field: field, Box: box, MaxSteps: 256, Epsilon: 1e-4, StepScale: 1}
+}
+
+func (g *SDFGeometry) GetGeometryData() GeometryData {
+	return GeometryData{Material: g.Material}
+}
+
+func (g *SDFGeometry) SetMaterial(material Material) {
+	g.Material = material
+}
+
+func (g *SDFGeometry) Bounds() vec3.Box {
+	return g.Box
+}
+
+func (g *SDFGeometry) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	tNear, tFar, ok := ray.intersectBox(g.Box)
+	if !ok {
+		return RayFaceIntersection{}, false
+	}
+
+	// Distances are in world units, t is in units of the ray direction
+	length := ray.Direction.Length()
+	if length == 0 {
+		return RayFaceIntersection{}, false
+	}
+	t := tNear
+	for i := 0; i < g.MaxSteps && t <= tFar; i++ {
+		p := ray.At(t)
+		d := g.Field.Distance(p)
+		if abs32(d) < g.Epsilon*float32(math.Max(1, float64(t*length))) {
+			intersection := newIntersection(ray, t)
+			intersection.Normal = g.Normal(p)
+			intersection.GeometricNormal = intersection.Normal
+			intersection.Material = g.Material
+			return intersection, true
+		}
+		t += abs32(d) * g.StepScale / length
+	}
+	return RayFaceIntersection{}, false
+}
+
+// Normal estimates the surface normal at p from the gradient of the field,
+// sampled at the corners of a tetrahedron.
+func (g *SDFGeometry) Normal(p vec3.T) vec3.T {
+	h := g.Epsilon
+	offsets := [4]vec3.T{{1, -1, -1}, {-1, -1, 1}, {-1, 1, -1}, {1, 1, 1}}
+	var normal vec3.T
+	for _, offset := range offsets {
+		sample := offset.Scaled(h)
+		sample.Add(&p)
+		weighted := offset.Scaled(g.Field.Distance(sample))
+		normal.Add(&weighted)
+	}
+	normal.Normalize()
+	return normal
+}
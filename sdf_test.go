@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+func TestSDFDistances(t *testing.T) {
+	tests := []struct {
+		name  string
+		field SDF
+		p     vec3.T
+		want  float32
+	}{
+		{"sphere outside", SDFSphere{Radius: 1}, vec3.T{3, 0, 0}, 2},
+		{"sphere inside", SDFSphere{Radius: 1}, vec3.T{0, 0.5, 0}, -0.5},
+		{"box face", SDFBox{HalfSize: vec3.T{1, 2, 3}}, vec3.T{0, 4, 0}, 2},
+		{"box corner", SDFBox{HalfSize: vec3.T{1, 1, 1}}, vec3.T{2, 2, 1}, float32(math.Sqrt2)},
+		{"box inside", SDFBox{HalfSize: vec3.T{1, 2, 3}}, vec3.T{0.5, 0, 0}, -0.5},
+		{"torus", SDFTorus{MajorRadius: 2, MinorRadius: 0.5}, vec3.T{0, 0, 2}, -0.5},
+		{"translate", SDFTranslate{SDF: SDFSphere{Radius: 1}, Offset: vec3.T{5, 0, 0}}, vec3.T{5, 3, 0}, 2},
+		{"rotate", RotateSDF(SDFBox{HalfSize: vec3.T{3, 1, 1}}, 0, 0, 90), vec3.T{0, 4, 0}, 1},
+		{"subtraction", SDFSubtraction{A: SDFSphere{Radius: 2}, B: SDFSphere{Radius: 1}}, vec3.T{0, 0, 0}, 1},
+	}
+	for _, test := range tests {
+		if d := test.field.Distance(test.p); math.Abs(float64(d-test.want)) > 1e-5 {
+			t.Errorf("%s: distance %v, want %v", test.name, d, test.want)
+		}
+	}
+}
+
+func TestScaleSDF(t *testing.T) {
+	for _, factor := range []float32{0, -2, float32(math.NaN())} {
+		if _, err := ScaleSDF(SDFSphere{Radius: 1}, factor); err == nil {
+			t.Errorf("factor %v is accepted", factor)
+		}
+	}
+	scaled, err := ScaleSDF(SDFSphere{Radius: 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := scaled.Distance(vec3.T{0, 0, 5}); d != 3 {
+		t.Errorf("distance %v, want 3", d)
+	}
+}
+
+func TestSDFGeometryIntersect(t *testing.T) {
+	scaled, err := ScaleSDF(SDFSphere{Radius: 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := CreateSDFGeometry(scaled, vec3.Box{Min: vec3.T{-3, -3, -3}, Max: vec3.T{3, 3, 3}})
+
+	hit, ok := g.Intersect(CreateRay(vec3.T{-10, 0, 0}, vec3.T{2, 0, 0}))
+	if !ok {
+		t.Fatal("ray misses the sphere")
+	}
+	// The direction has length 2, so the surface at x = -2 is at t = 4
+	if math.Abs(float64(hit.IntersectionDistance-4)) > 1e-3 {
+		t.Errorf("distance %v, want 4", hit.IntersectionDistance)
+	}
+	if !vecApproxEqual(hit.Normal, vec3.T{-1, 0, 0}, 1e-3) {
+		t.Errorf("normal %v, want %v", hit.Normal, vec3.T{-1, 0, 0})
+	}
+
+	if _, ok := g.Intersect(CreateRay(vec3.T{-10, 2.1, 0}, vec3.T{1, 0, 0})); ok {
+		t.Error("ray that passes the sphere hits it")
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// ShaderNodeType is the kind of a ShaderNode. Every node outputs a colour of
+// four values, of which scalar inputs use the first.
+type ShaderNodeType string
+
+const (
+	// ShaderValue outputs Value.
+	ShaderValue ShaderNodeType = "value"
+	// ShaderUV outputs the texture coordinate as (U, V, 0, 1).
+	ShaderUV ShaderNodeType = "uv"
+	// ShaderPosition outputs the point in the space of the geometry.
+	ShaderPosition ShaderNodeType = "position"
+	// ShaderNormal outputs the shading normal in world space.
+	ShaderNormal ShaderNodeType = "normal"
+	// ShaderImage samples the image File.
+	ShaderImage ShaderNodeType = "image"
+	// ShaderChecker, ShaderNoise, ShaderMarble and ShaderWood output the
+	// procedural textures from black to white, with the "scale", "octaves"
+	// and "turbulence" parameters where they apply. ShaderGradient blends
+	// along Value as the direction.
+	ShaderChecker  ShaderNodeType = "checker"
+	ShaderNoise    ShaderNodeType = "noise"
+	ShaderMarble   ShaderNodeType = "marble"
+	ShaderWood     ShaderNodeType = "wood"
+	ShaderGradient ShaderNodeType = "gradient"
+	// ShaderMath combines the inputs "a" and "b" per component with
+	// Operation.
+	ShaderMath ShaderNodeType = "math"
+	// ShaderMix blends from input "a" to "b" by input "factor".
+	ShaderMix ShaderNodeType = "mix"
+	// ShaderRamp maps input "factor" onto the colours of Stops.
+	ShaderRamp ShaderNodeType = "ramp"
+	// ShaderFresnel outputs the reflectance of a dielectric with the "ior"
+	// parameter, by Schlick's approximation, which grows towards grazing
+	// angles.
+	ShaderFresnel ShaderNodeType = "fresnel"
+)
+
+// ShaderNode is a node of a ShaderGraph. Which fields are used depends on
+// Type.
+type ShaderNode struct {
+	ID   string         `json:"id"`
+	Type ShaderNodeType `json:"type"`
+	// Inputs maps the inputs of the node to the IDs of the nodes that feed
+	// them. Inputs that are not connected are Value, or 0 without it. The
+	// "vector" input of texture nodes replaces the point they are evaluated
+	// at, UV by its first two components and the position by all three.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Value is a scalar, an RGB colour with alpha 1 or an RGBA colour.
+	Value     []float32          `json:"value,omitempty"`
+	Params    map[string]float32 `json:"params,omitempty"`
+	Operation string             `json:"operation,omitempty"` // add, subtract, multiply, divide, power, minimum, maximum, absolute, sine or cosine
+	Space     string             `json:"space,omitempty"`     // "uv" or "object" for procedural textures
+	File      string             `json:"file,omitempty"`      // image, relative to the graph file
+	Stops     []RampStop         `json:"stops,omitempty"`
+}
+
+// RampStop is a colour of a ShaderRamp at a position between 0 and 1.
+type RampStop struct {
+	Position float32   `json:"position"`
+	Color    []float32 `json:"color"`
+}
+
+// ShaderGraph builds the parameters of a material from nodes. Outputs maps
+// material parameters to the IDs of the nodes that give them: "color",
+// "specular" and "emission" take colours, "reflectivity", "opacity",
+// "diffuse", "roughness", "metallic", "shininess", "ior" and
+// "emission_strength" the first component, and "normal" and "bump" become
+// the normal and bump map of the material. Graphs are saved as JSON.
+type ShaderGraph struct {
+	Nodes   []ShaderNode      `json:"nodes"`
+	Outputs map[string]string `json:"outputs"`
+
+	// dir is where image files are looked up
+	dir      string
+	compiled map[string]Texture
+	err      error
+}
+
+// shaderOutputs sets the parameters of materials that graphs can output.
+var shaderOutputs = map[string]func(m *Material, v vec4.T){
+	"color":             func(m *Material, v vec4.T) { m.Color = vec4ToColor(v) },
+	"specular":          func(m *Material, v vec4.T) { m.Specular = vec4ToColor(v) },
+	"emission":          func(m *Material, v vec4.T) { m.Emission = vec4ToColor(v) },
+	"reflectivity":      func(m *Material, v vec4.T) { m.Reflectivity = v[0] },
+	"opacity":           func(m *Material, v vec4.T) { m.Opacity = v[0] },
+	"diffuse":           func(m *Material, v vec4.T) { m.Diffuse = v[0] },
+	"roughness":         func(m *Material, v vec4.T) { m.Roughness = v[0] },
+	"metallic":          func(m *Material, v vec4.T) { m.Metallic = v[0] },
+	"shininess":         func(m *Material, v vec4.T) { m.Shininess = v[0] },
+	"ior":               func(m *Material, v vec4.T) { m.IOR = v[0] },
+	"emission_strength": func(m *Material, v vec4.T) { m.EmissionStrength = v[0] },
+}
+
+// CreateShaderGraph creates a graph and checks it, see Compile.
+func CreateShaderGraph(nodes []ShaderNode, outputs map[string]string) (*ShaderGraph, error) {
+	g := &ShaderGraph{Nodes: nodes, Outputs: outputs}
+	return g, g.Compile()
+}
+
+// LoadShaderGraph reads a graph from a JSON file. Image files are relative to
+// it.
+func LoadShaderGraph(filename string) (*ShaderGraph, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	g := &ShaderGraph{dir: filepath.Dir(filename)}
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := g.Compile(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return g, nil
+}
+
+// Save writes the graph to a JSON file.
+func (g *ShaderGraph) Save(filename string) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Compile checks the graph and turns its nodes into textures. It fails for
+// unknown node types and outputs, missing nodes, cycles and images that
+// cannot be loaded. It has to be called again after the graph is changed.
+func (g *ShaderGraph) Compile() error {
+	nodes := make(map[string]*ShaderNode, len(g.Nodes))
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		if _, ok := nodes[n.ID]; ok {
+			return fmt.Errorf("duplicate shader node %q", n.ID)
+		}
+		nodes[n.ID] = n
+	}
+
+	c := &shaderCompiler{
+		graph:    g,
+		nodes:    nodes,
+		textures: make(map[string]Texture),
+		visiting: make(map[string]bool),
+		images:   make(map[string]*ImageTexture),
+	}
+	compiled := make(map[string]Texture, len(g.Outputs))
+	for _, output := range g.sortedOutputs() {
+		if _, ok := shaderOutputs[output]; !ok && output != "normal" && output != "bump" {
+			return fmt.Errorf("unknown shader output %q", output)
+		}
+		t, err := c.compile(g.Outputs[output])
+		if err != nil {
+			return err
+		}
+		compiled[output] = t
+	}
+	g.compiled = compiled
+	g.err = nil
+	return nil
+}
+
+// sortedOutputs returns the outputs in a fixed order, so that errors do not
+// depend on the order of the map.
+func (g *ShaderGraph) sortedOutputs() []string {
+	outputs := make([]string, 0, len(g.Outputs))
+	for output := range g.Outputs {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// apply sets the parameters of m that the graph outputs to their values at
+// ctx. Graphs that fail to compile are skipped.
+func (g *ShaderGraph) apply(m *Material, ctx TextureContext) {
+	if g.compiled == nil && g.err == nil {
+		g.err = g.Compile()
+	}
+	if g.err != nil {
+		return
+	}
+	for output, t := range g.compiled {
+		switch output {
+		case "normal":
+			m.NormalMap = t
+		case "bump":
+			m.BumpMap = t
+		default:
+			shaderOutputs[output](m, t.Evaluate(ctx))
+		}
+	}
+}
+
+type shaderCompiler struct {
+	graph    *ShaderGraph
+	nodes    map[string]*ShaderNode
+	textures map[string]Texture
+	visiting map[string]bool
+	images   map[string]*ImageTexture
+}
+
+// compile returns the texture of a node, compiling the nodes that feed it
+// first. Nodes that feed several others are compiled once.
+func (c *shaderCompiler) compile(id string) (Texture, error) {
+	if t, ok := c.textures[id]; ok {
+		return t, nil
+	}
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown shader node %q", id)
+	}
+	if c.visiting[id] {
+		return nil, fmt.Errorf("shader node %q feeds itself", id)
+	}
+	c.visiting[id] = true
+	defer delete(c.visiting, id)
+
+	inputs := make(map[string]Texture)
+	for name, from := range n.Inputs {
+		t, err := c.compile(from)
+		if err != nil {
+			return nil, err
+		}
+		inputs[name] = t
+	}
+	input := func(name string) Texture {
+		if t, ok := inputs[name]; ok {
+			return t
+		}
+		return ConstantTexture(nodeValue(n.Value))
+	}
+
+	space := TextureSpaceUV
+	switch n.Space {
+	case "", "uv":
+	case "object":
+		space = TextureSpaceObject
+	default:
+		return nil, fmt.Errorf("shader node %q: unknown space %q", id, n.Space)
+	}
+	param := func(name string, fallback float32) float32 {
+		if v, ok := n.Params[name]; ok {
+			return v
+		}
+		return fallback
+	}
+	black, white := vec4.T{0, 0, 0, 1}, vec4.T{1, 1, 1, 1}
+
+	var t Texture
+	switch n.Type {
+	case ShaderValue:
+		t = ConstantTexture(nodeValue(n.Value))
+	case ShaderUV:
+		t = uvNode{}
+	case ShaderPosition:
+		t = positionNode{}
+	case ShaderNormal:
+		t = normalNode{}
+	case ShaderImage:
+		image, err := c.image(n.File)
+		if err != nil {
+			return nil, fmt.Errorf("shader node %q: %v", id, err)
+		}
+		t = image
+	case ShaderChecker:
+		t = CheckerTexture{Even: black, Odd: white, Scale: param("scale", 1), Space: space}
+	case ShaderNoise:
+		t = CreateNoiseTexture(black, white, param("scale", 1), int(param("octaves", 4)), space)
+	case ShaderMarble:
+		t = MarbleTexture{Vein: white, Base: black, Scale: param("scale", 1), Turbulence: param("turbulence", 5), Octaves: int(param("octaves", 4)), Space: space}
+	case ShaderWood:
+		t = WoodTexture{Light: white, Dark: black, Scale: param("scale", 1), Turbulence: param("turbulence", 1), Space: space}
+	case ShaderGradient:
+		direction := nodeValue(n.Value)
+		t = GradientTexture{Start: black, End: white, Direction: vec3.T{direction[0], direction[1], direction[2]}, Space: space}
+	case ShaderMath:
+		op, ok := mathOperations[n.Operation]
+		if !ok {
+			return nil, fmt.Errorf("shader node %q: unknown operation %q", id, n.Operation)
+		}
+		t = mathNode{op, input("a"), input("b")}
+	case ShaderMix:
+		t = mixNode{input("a"), input("b"), input("factor")}
+	case ShaderRamp:
+		if len(n.Stops) == 0 {
+			return nil, fmt.Errorf("shader node %q: ramp without stops", id)
+		}
+		stops := append([]RampStop(nil), n.Stops...)
+		sort.SliceStable(stops, func(i, j int) bool { return stops[i].Position < stops[j].Position })
+		t = rampNode{input("factor"), stops}
+	case ShaderFresnel:
+		t = fresnelNode{param("ior", 1.5)}
+	default:
+		return nil, fmt.Errorf("shader node %q: unknown type %q", id, n.Type)
+	}
+
+	if vector, ok := inputs["vector"]; ok && isTextureNode(n.Type) {
+		t = remappedTexture{t, vector}
+	}
+	c.textures[id] = t
+	return t, nil
+}
+
+func (c *shaderCompiler) image(file string) (*ImageTexture, error) {
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(c.graph.dir, file)
+	}
+	if t, ok := c.images[file]; ok {
+		return t, nil
+	}
+	t, err := LoadImageTexture(file, WrapRepeat)
+	if err != nil {
+		return nil, err
+	}
+	c.images[file] = t
+	return t, nil
+}
+
+func isTextureNode(t ShaderNodeType) bool {
+	switch t {
+	case ShaderImage, ShaderChecker, ShaderNoise, ShaderMarble, ShaderWood, ShaderGradient:
+		return true
+	}
+	return false
+}
+
+// nodeValue expands a scalar or RGB value to RGBA.
+func nodeValue(v []float32) vec4.T {
+	switch len(v) {
+	case 0:
+		return vec4.T{}
+	case 1, 2:
+		return vec4.T{v[0], v[0], v[0], 1}
+	case 3:
+		return vec4.T{v[0], v[1], v[2], 1}
+	default:
+		return vec4.T{v[0], v[1], v[2], v[3]}
+	}
+}
+
+func vec4ToColor(v vec4.T) color.RGBA {
+	return color.RGBA{
+		R: uint8(clampColorComponent(v[0] * 255)),
+		G: uint8(clampColorComponent(v[1] * 255)),
+		B: uint8(clampColorComponent(v[2] * 255)),
+		A: uint8(clampColorComponent(v[3] * 255)),
+	}
+}
+
+type uvNode struct{}
+
+func (uvNode) Evaluate(ctx TextureContext) vec4.T {
+	return vec4.T{float32(ctx.UV.U), float32(ctx.UV.V), 0, 1}
+}
+
+type positionNode struct{}
+
+func (positionNode) Evaluate(ctx TextureContext) vec4.T {
+	return vec4.T{ctx.Position[0], ctx.Position[1], ctx.Position[2], 1}
+}
+
+type normalNode struct{}
+
+func (normalNode) Evaluate(ctx TextureContext) vec4.T {
+	return vec4.T{ctx.Normal[0], ctx.Normal[1], ctx.Normal[2], 1}
+}
+
+// remappedTexture evaluates a texture at the point given by another. The
+// footprint is kept, which is only right if the mapping keeps the scale.
+type remappedTexture struct {
+	texture Texture
+	vector  Texture
+}
+
+func (t remappedTexture) Evaluate(ctx TextureContext) vec4.T {
+	v := t.vector.Evaluate(ctx)
+	ctx.UV = TextureCoordinate{U: float64(v[0]), V: float64(v[1])}
+	ctx.Position = vec3.T{v[0], v[1], v[2]}
+	return t.texture.Evaluate(ctx)
+}
+
+var mathOperations = map[string]func(a, b float32) float32{
+	"add":      func(a, b float32) float32 { return a + b },
+	"subtract": func(a, b float32) float32 { return a - b },
+	"multiply": func(a, b float32) float32 { return a * b },
+	"divide": func(a, b float32) float32 {
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	},
+	"power":    func(a, b float32) float32 { return float32(math.Pow(float64(a), float64(b))) },
+	"minimum":  func(a, b float32) float32 { return float32(math.Min(float64(a), float64(b))) },
+	"maximum":  func(a, b float32) float32 { return float32(math.Max(float64(a), float64(b))) },
+	"absolute": func(a, b float32) float32 { return abs32(a) },
+	"sine":     func(a, b float32) float32 { return float32(math.Sin(float64(a))) },
+	"cosine":   func(a, b float32) float32 { return float32(math.Cos(float64(a))) },
+}
+
+type mathNode struct {
+	op   func(a, b float32) float32
+	a, b Texture
+}
+
+func (n mathNode) Evaluate(ctx TextureContext) vec4.T {
+	a, b := n.a.Evaluate(ctx), n.b.Evaluate(ctx)
+	var result vec4.T
+	for i := range result {
+		result[i] = n.op(a[i], b[i])
+	}
+	return result
+}
+
+type mixNode struct {
+	a, b, factor Texture
+}
+
+func (n mixNode) Evaluate(ctx TextureContext) vec4.T {
+	a, b := n.a.Evaluate(ctx), n.b.Evaluate(ctx)
+	return vec4.Interpolate(&a, &b, n.factor.Evaluate(ctx)[0])
+}
+
+// rampNode blends linearly between the stops around the factor and holds the
+// first and last colour outside of them.
+type rampNode struct {
+	factor Texture
+	stops  []RampStop
+}
+
+func (n rampNode) Evaluate(ctx TextureContext) vec4.T {
+	f := n.factor.Evaluate(ctx)[0]
+	if f <= n.stops[0].Position {
+		return nodeValue(n.stops[0].Color)
+	}
+	for i := 1; i < len(n.stops); i++ {
+		a, b := n.stops[i-1], n.stops[i]
+		if f <= b.Position {
+			ca, cb := nodeValue(a.Color), nodeValue(b.Color)
+			if b.Position == a.Position {
+				return cb
+			}
+			return vec4.Interpolate(&ca, &cb, (f-a.Position)/(b.Position-a.Position))
+		}
+	}
+	return nodeValue(n.stops[len(n.stops)-1].Color)
+}
+
+type fresnelNode struct {
+	ior float32
+}
+
+func (n fresnelNode) Evaluate(ctx TextureContext) vec4.T {
+	f0 := (n.ior - 1) / (n.ior + 1)
+	f0 *= f0
+	cos := abs32(vec3.Dot(&ctx.Normal, &ctx.ViewDirection))
+	f := schlickFresnel(vec3.T{f0, f0, f0}, cos)[0]
+	return vec4.T{f, f, f, 1}
+}
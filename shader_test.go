@@ -0,0 +1,160 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// testShaderNodes mix red and blue by a checker that is scaled in the
+// math node, and give a ramp of the U coordinate as roughness.
+func testShaderNodes() ([]ShaderNode, map[string]string) {
+	nodes := []ShaderNode{
+		{ID: "red", Type: ShaderValue, Value: []float32{1, 0, 0}},
+		{ID: "blue", Type: ShaderValue, Value: []float32{0, 0, 1, 1}},
+		{ID: "uv", Type: ShaderUV},
+		{ID: "two", Type: ShaderValue, Value: []float32{2}},
+		{ID: "scaled", Type: ShaderMath, Operation: "multiply", Inputs: map[string]string{"a": "uv", "b": "two"}},
+		{ID: "checker", Type: ShaderChecker, Params: map[string]float32{"scale": 2}, Inputs: map[string]string{"vector": "scaled"}},
+		{ID: "mix", Type: ShaderMix, Inputs: map[string]string{"a": "red", "b": "blue", "factor": "checker"}},
+		{ID: "ramp", Type: ShaderRamp, Inputs: map[string]string{"factor": "uv"}, Stops: []RampStop{
+			{Position: 1, Color: []float32{0.9}},
+			{Position: 0, Color: []float32{0.1}},
+		}},
+		{ID: "fresnel", Type: ShaderFresnel, Params: map[string]float32{"ior": 1.5}},
+	}
+	outputs := map[string]string{"color": "mix", "roughness": "ramp", "reflectivity": "fresnel"}
+	return nodes, outputs
+}
+
+func TestShaderGraphApply(t *testing.T) {
+	g, err := CreateShaderGraph(testShaderNodes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uv        TextureCoordinate
+		color     color.RGBA
+		roughness float32
+	}{
+		// The checker is at four times the UV, the scale of the math node
+		// times that of the checker
+		{TextureCoordinate{U: 0.1, V: 0.1}, color.RGBA{255, 0, 0, 255}, 0.18},
+		{TextureCoordinate{U: 0.3, V: 0.1}, color.RGBA{0, 0, 255, 255}, 0.34},
+		{TextureCoordinate{U: 0.6, V: 0.1}, color.RGBA{255, 0, 0, 255}, 0.58},
+	}
+	for _, test := range tests {
+		ctx := TextureContext{UV: test.uv, Normal: vec3.T{0, 0, 1}, ViewDirection: vec3.T{0, 0, 1}}
+		m := Material{Color: color.RGBA{0, 255, 0, 255}}
+		g.apply(&m, ctx)
+		if m.Color != test.color {
+			t.Errorf("%v: colour %v, want %v", test.uv, m.Color, test.color)
+		}
+		if d := m.Roughness - test.roughness; d > 1e-5 || d < -1e-5 {
+			t.Errorf("%v: roughness %v, want %v", test.uv, m.Roughness, test.roughness)
+		}
+		// Glass reflects 4% straight on
+		if d := m.Reflectivity - 0.04; d > 1e-5 || d < -1e-5 {
+			t.Errorf("%v: reflectivity %v, want 0.04", test.uv, m.Reflectivity)
+		}
+	}
+}
+
+func TestShaderGraphRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPNG(t, filepath.Join(dir, "grain.png"), 2, 2, color.RGBA{0, 128, 255, 255})
+	nodes, outputs := testShaderNodes()
+	nodes = append(nodes, ShaderNode{ID: "grain", Type: ShaderImage, File: "grain.png"})
+	outputs["specular"] = "grain"
+	outputs["bump"] = "checker"
+
+	// Images are looked up next to the graph
+	original := &ShaderGraph{Nodes: nodes, Outputs: outputs, dir: dir}
+	if err := original.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "graph.json")
+	if err := original.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadShaderGraph(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Nodes, original.Nodes) || !reflect.DeepEqual(loaded.Outputs, original.Outputs) {
+		t.Errorf("loaded %+v, saved %+v", loaded, original)
+	}
+
+	for _, uv := range []TextureCoordinate{{U: 0.1, V: 0.1}, {U: 0.3, V: 0.7}, {U: 0.9, V: 0.4}} {
+		ctx := TextureContext{UV: uv, Normal: vec3.T{0, 0, 1}, ViewDirection: vec3.T{0, 0.6, 0.8}}
+		var a, b Material
+		original.apply(&a, ctx)
+		loaded.apply(&b, ctx)
+		if a.Color != b.Color || a.Roughness != b.Roughness || a.Reflectivity != b.Reflectivity || a.Specular != b.Specular {
+			t.Errorf("%v: loaded graph gives %+v, saved one %+v", uv, b, a)
+		}
+		if b.BumpMap == nil || b.Specular != (color.RGBA{0, 128, 255, 255}) {
+			t.Errorf("%v: bump map %v and specular %v", uv, b.BumpMap, b.Specular)
+		}
+	}
+}
+
+func TestShaderGraphErrors(t *testing.T) {
+	value := ShaderNode{ID: "v", Type: ShaderValue, Value: []float32{1}}
+	tests := []struct {
+		name    string
+		nodes   []ShaderNode
+		outputs map[string]string
+		err     string
+	}{
+		{"cycle", []ShaderNode{
+			{ID: "a", Type: ShaderMath, Operation: "add", Inputs: map[string]string{"a": "b"}},
+			{ID: "b", Type: ShaderMix, Inputs: map[string]string{"factor": "a"}},
+		}, map[string]string{"color": "a"}, `shader node "a" feeds itself`},
+		{"self", []ShaderNode{
+			{ID: "a", Type: ShaderMath, Operation: "add", Inputs: map[string]string{"a": "a"}},
+		}, map[string]string{"color": "a"}, `shader node "a" feeds itself`},
+		{"unknown type", []ShaderNode{{ID: "a", Type: "bricks"}}, map[string]string{"color": "a"}, `unknown type "bricks"`},
+		{"unknown output", []ShaderNode{value}, map[string]string{"colour": "v"}, `unknown shader output "colour"`},
+		{"unknown node", []ShaderNode{value}, map[string]string{"color": "w"}, `unknown shader node "w"`},
+		{"unknown input node", []ShaderNode{
+			{ID: "a", Type: ShaderMix, Inputs: map[string]string{"factor": "w"}},
+		}, map[string]string{"color": "a"}, `unknown shader node "w"`},
+		{"duplicate", []ShaderNode{value, value}, map[string]string{"color": "v"}, `duplicate shader node "v"`},
+		{"unknown operation", []ShaderNode{{ID: "a", Type: ShaderMath, Operation: "modulo"}}, map[string]string{"color": "a"}, `unknown operation "modulo"`},
+		{"unknown space", []ShaderNode{{ID: "a", Type: ShaderNoise, Space: "world"}}, map[string]string{"color": "a"}, `unknown space "world"`},
+		{"ramp without stops", []ShaderNode{{ID: "a", Type: ShaderRamp}}, map[string]string{"color": "a"}, "ramp without stops"},
+		{"missing image", []ShaderNode{{ID: "a", Type: ShaderImage, File: "missing.png"}}, map[string]string{"color": "a"}, `shader node "a"`},
+	}
+	for _, test := range tests {
+		_, err := CreateShaderGraph(test.nodes, test.outputs)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	// Graphs that fail to compile leave materials alone
+	g := &ShaderGraph{Nodes: []ShaderNode{{ID: "a", Type: "bricks"}}, Outputs: map[string]string{"color": "a"}}
+	m := Material{Color: color.RGBA{1, 2, 3, 255}}
+	g.apply(&m, TextureContext{})
+	if m.Color != (color.RGBA{1, 2, 3, 255}) {
+		t.Errorf("broken graph changed the colour to %v", m.Color)
+	}
+
+	// Loading reports the file
+	filename := writeTestFile(t, "graph.json", `{"nodes": [{"id": "a", "type": "bricks"}], "outputs": {"color": "a"}}`)
+	if _, err := LoadShaderGraph(filename); err == nil || !strings.HasPrefix(err.Error(), filename) {
+		t.Errorf("loading a broken graph: %v", err)
+	}
+	if err := os.WriteFile(filename, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadShaderGraph(filename); err == nil {
+		t.Error("invalid JSON is accepted")
+	}
+}
@@ -1,14 +1,69 @@
 package main
 
+import (
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
 type Space struct {
 	Geometries []*Geometry
 	Lights     []*Light
+	AreaLights []*AreaLight
+
+	// bounds caches the bounding boxes of Geometries, see UpdateBounds
+	bounds []vec3.Box
 }
 
+// AddGeometry adds a geometry to the space. Meshes with emissive materials,
+// also inside a TransformedGeometry, are added as area lights as well, with
+// the faces they have at this point.
 func (s *Space) AddGeometry(g Geometry) {
 	s.Geometries = append(s.Geometries, &g)
+
+	transform := mat4.Ident
+	mesh := g
+	if t, ok := g.(*TransformedGeometry); ok {
+		transform, mesh = t.Transform, t.Geometry
+	}
+	if o, ok := mesh.(*Obj); ok {
+		if l, ok := CreateAreaLight(o, transform); ok {
+			s.AreaLights = append(s.AreaLights, l)
+		}
+	}
 }
 
 func (s *Space) AddLight(l Light) {
 	s.Lights = append(s.Lights, &l)
 }
+
+// UpdateBounds caches the bounding boxes of the geometries, which Intersect
+// uses to skip geometries the ray misses. It has to be called again after
+// geometries are added or changed.
+func (s *Space) UpdateBounds() {
+	s.bounds = make([]vec3.Box, len(s.Geometries))
+	for i, geometry := range s.Geometries {
+		s.bounds[i] = (*geometry).Bounds()
+	}
+}
+
+// Intersect returns the closest intersection of the ray with any geometry.
+func (s *Space) Intersect(ray Ray) (RayFaceIntersection, bool) {
+	var closest RayFaceIntersection
+	found := false
+	useBounds := len(s.bounds) == len(s.Geometries)
+	for i, geometry := range s.Geometries {
+		if useBounds {
+			if _, _, ok := ray.intersectBox(s.bounds[i]); !ok {
+				continue
+			}
+		}
+		intersection, ok := (*geometry).Intersect(ray)
+		if ok {
+			intersection.Geometry = *geometry
+			closest = intersection
+			found = true
+			ray.TMax = intersection.IntersectionDistance
+		}
+	}
+	return closest, found
+}
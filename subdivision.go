@@ -0,0 +1,549 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+type SubdivisionScheme int
+
+const (
+	// SubdivisionAuto uses Loop for triangle meshes and Catmull-Clark
+	// otherwise.
+	SubdivisionAuto SubdivisionScheme = iota
+	SubdivisionLoop
+	SubdivisionCatmullClark
+)
+
+type SubdivisionOptions struct {
+	Scheme SubdivisionScheme
+	Levels int
+	// CreaseAngle marks edges whose faces meet at more than this many degrees
+	// as creases. 0 disables the test. Border edges and edges across which
+	// the faces use different normals, for example between smoothing groups,
+	// are always creases.
+	CreaseAngle float64
+}
+
+// meshEdge is an edge between two vertices, with the lower index first.
+type meshEdge struct {
+	a, b int
+}
+
+func newMeshEdge(a, b int) meshEdge {
+	if a > b {
+		a, b = b, a
+	}
+	return meshEdge{a, b}
+}
+
+// Subdivide returns a refined copy of the mesh. Creases stay sharp, texture
+// coordinates are interpolated linearly and the normals of the result are
+// smooth everywhere except across creases. Loop subdivision triangulates
+// faces with more than three vertices first.
+func (o *Obj) Subdivide(options SubdivisionOptions) *Obj {
+	result := o.clone()
+
+	scheme := options.Scheme
+	if scheme == SubdivisionAuto {
+		scheme = SubdivisionLoop
+		for _, f := range result.Faces {
+			if len(f.VertexIndices) != 3 {
+				scheme = SubdivisionCatmullClark
+				break
+			}
+		}
+	}
+	if scheme == SubdivisionLoop {
+		result.triangulate()
+	}
+
+	creases := result.creaseEdges(options.CreaseAngle)
+	for level := 0; level < options.Levels; level++ {
+		if scheme == SubdivisionLoop {
+			creases = result.loopStep(creases)
+		} else {
+			creases = result.catmullClarkStep(creases)
+		}
+	}
+	result.generateCreasedNormals(creases)
+	result.GenerateTangents()
+	return result
+}
+
+// clone returns a deep copy of the mesh.
+func (o *Obj) clone() *Obj {
+	c := &Obj{
+		Vertices:           append([]vec3.T(nil), o.Vertices...),
+		TextureCoordinates: append([]TextureCoordinate(nil), o.TextureCoordinates...),
+		Normals:            append([]Normal(nil), o.Normals...),
+		Faces:              make([]Face, len(o.Faces)),
+		Origin:             o.Origin,
+		Material:           o.Material,
+		VertexColors:       append([]vec3.T(nil), o.VertexColors...),
+		Tangents:           append([]vec4.T(nil), o.Tangents...),
+	}
+	for i, f := range o.Faces {
+		c.Faces[i] = f
+		c.Faces[i].VertexIndices = append([]int(nil), f.VertexIndices...)
+		c.Faces[i].TextureCoordinateIndices = append([]int(nil), f.TextureCoordinateIndices...)
+		c.Faces[i].NormalIndices = append([]int(nil), f.NormalIndices...)
+		c.Faces[i].TangentIndices = append([]int(nil), f.TangentIndices...)
+	}
+	return c
+}
+
+// triangulate splits faces with more than three vertices into triangle fans.
+func (o *Obj) triangulate() {
+	var faces []Face
+	for _, f := range o.Faces {
+		if len(f.VertexIndices) <= 3 {
+			faces = append(faces, f)
+			continue
+		}
+		for i := 1; i+1 < len(f.VertexIndices); i++ {
+			corners := []int{0, i, i + 1}
+			faces = append(faces, f.subFace(corners))
+		}
+	}
+	o.Faces = faces
+}
+
+// subFace returns a face made of the given corners of f.
+func (f Face) subFace(corners []int) Face {
+	sub := f
+	sub.VertexIndices = pickCorners(f.VertexIndices, corners)
+	sub.TextureCoordinateIndices = pickCorners(f.TextureCoordinateIndices, corners)
+	sub.NormalIndices = pickCorners(f.NormalIndices, corners)
+	sub.TangentIndices = pickCorners(f.TangentIndices, corners)
+	return sub
+}
+
+func pickCorners(indices []int, corners []int) []int {
+	if len(indices) == 0 {
+		return nil
+	}
+	picked := make([]int, len(corners))
+	for i, c := range corners {
+		picked[i] = indices[c]
+	}
+	return picked
+}
+
+// edgeFaces maps every edge of the mesh to the faces that contain it.
+func (o *Obj) edgeFaces() map[meshEdge][]int {
+	edges := make(map[meshEdge][]int)
+	for i, f := range o.Faces {
+		for j := range f.VertexIndices {
+			e := newMeshEdge(f.VertexIndices[j], f.VertexIndices[(j+1)%len(f.VertexIndices)])
+			edges[e] = append(edges[e], i)
+		}
+	}
+	return edges
+}
+
+// orderedEdges lists every edge once, in the order the faces use them, so
+// that refined meshes come out the same every time.
+func (o *Obj) orderedEdges() []meshEdge {
+	seen := make(map[meshEdge]bool)
+	var edges []meshEdge
+	for _, f := range o.Faces {
+		for j := range f.VertexIndices {
+			e := newMeshEdge(f.VertexIndices[j], f.VertexIndices[(j+1)%len(f.VertexIndices)])
+			if !seen[e] {
+				seen[e] = true
+				edges = append(edges, e)
+			}
+		}
+	}
+	return edges
+}
+
+// corner returns the position of vertex in the face, or -1.
+func (f Face) corner(vertex int) int {
+	for j, v := range f.VertexIndices {
+		if v == vertex {
+			return j
+		}
+	}
+	return -1
+}
+
+func (o *Obj) creaseEdges(creaseAngle float64) map[meshEdge]bool {
+	cosCrease := float32(math.Cos(creaseAngle * math.Pi / 180))
+	creases := make(map[meshEdge]bool)
+	for e, faces := range o.edgeFaces() {
+		if len(faces) != 2 {
+			creases[e] = true
+			continue
+		}
+		f, g := o.Faces[faces[0]], o.Faces[faces[1]]
+
+		// Faces that do not share their normals along the edge meet at a hard edge
+		if len(f.NormalIndices) == len(f.VertexIndices) && len(g.NormalIndices) == len(g.VertexIndices) {
+			if f.NormalIndices[f.corner(e.a)] != g.NormalIndices[g.corner(e.a)] ||
+				f.NormalIndices[f.corner(e.b)] != g.NormalIndices[g.corner(e.b)] {
+				creases[e] = true
+				continue
+			}
+		}
+
+		if creaseAngle > 0 {
+			nf := polygonNormal(o.Vertices, f.VertexIndices)
+			ng := polygonNormal(o.Vertices, g.VertexIndices)
+			if vec3.Dot(&nf, &ng) < cosCrease {
+				creases[e] = true
+			}
+		}
+	}
+	return creases
+}
+
+// vertexCreases returns, for every vertex, the other ends of the crease edges
+// that meet there.
+func (o *Obj) vertexCreases(creases map[meshEdge]bool) [][]int {
+	ends := make([][]int, len(o.Vertices))
+	for e := range creases {
+		ends[e.a] = append(ends[e.a], e.b)
+		ends[e.b] = append(ends[e.b], e.a)
+	}
+	return ends
+}
+
+// texturePoints creates texture coordinates that are the average of others,
+// and reuses them when the same average is asked for again.
+type texturePoints struct {
+	obj    *Obj
+	shared map[meshEdge]int
+}
+
+func (t *texturePoints) average(indices ...int) int {
+	var sum TextureCoordinate
+	for _, idx := range indices {
+		sum.U += t.obj.TextureCoordinates[idx].U
+		sum.V += t.obj.TextureCoordinates[idx].V
+	}
+	n := float64(len(indices))
+	t.obj.TextureCoordinates = append(t.obj.TextureCoordinates, TextureCoordinate{U: sum.U / n, V: sum.V / n})
+	return len(t.obj.TextureCoordinates) - 1
+}
+
+func (t *texturePoints) midpoint(a, b int) int {
+	key := newMeshEdge(a, b)
+	if idx, ok := t.shared[key]; ok {
+		return idx
+	}
+	idx := t.average(a, b)
+	t.shared[key] = idx
+	return idx
+}
+
+// loopStep performs one step of Loop subdivision on a triangle mesh and
+// returns the creases of the refined mesh.
+func (o *Obj) loopStep(creases map[meshEdge]bool) map[meshEdge]bool {
+	edges := o.edgeFaces()
+	creaseEnds := o.vertexCreases(creases)
+
+	// Neighbours of every vertex
+	neighbours := make([][]int, len(o.Vertices))
+	for e := range edges {
+		neighbours[e.a] = append(neighbours[e.a], e.b)
+		neighbours[e.b] = append(neighbours[e.b], e.a)
+	}
+
+	// New positions of the existing vertices
+	vertices := make([]vec3.T, len(o.Vertices), len(o.Vertices)+len(edges))
+	for v, p := range o.Vertices {
+		switch ends := creaseEnds[v]; {
+		case len(ends) > 2 || len(neighbours[v]) == 0:
+			vertices[v] = p
+		case len(ends) == 2:
+			vertices[v] = weightedSum(o.Vertices, []int{v, ends[0], ends[1]}, []float32{0.75, 0.125, 0.125})
+		default:
+			n := float64(len(neighbours[v]))
+			c := 3.0/8 + math.Cos(2*math.Pi/n)/4
+			beta := float32((5.0/8 - c*c) / n)
+			weights := []float32{1 - float32(n)*beta}
+			for range neighbours[v] {
+				weights = append(weights, beta)
+			}
+			vertices[v] = weightedSum(o.Vertices, append([]int{v}, neighbours[v]...), weights)
+		}
+	}
+
+	// One new vertex on every edge, vertex colours are interpolated linearly
+	hasColors := o.hasVertexColors()
+	edgePoints := make(map[meshEdge]int, len(edges))
+	for _, e := range o.orderedEdges() {
+		faces := edges[e]
+		point := weightedSum(o.Vertices, []int{e.a, e.b}, []float32{0.5, 0.5})
+		if hasColors {
+			o.VertexColors = append(o.VertexColors, weightedSum(o.VertexColors, []int{e.a, e.b}, []float32{0.5, 0.5}))
+		}
+		if !creases[e] && len(faces) == 2 {
+			var opposite []int
+			for _, f := range faces {
+				for _, v := range o.Faces[f].VertexIndices {
+					if v != e.a && v != e.b {
+						opposite = append(opposite, v)
+					}
+				}
+			}
+			point = weightedSum(o.Vertices, []int{e.a, e.b, opposite[0], opposite[1]}, []float32{0.375, 0.375, 0.125, 0.125})
+		}
+		edgePoints[e] = len(vertices)
+		vertices = append(vertices, point)
+	}
+
+	// Every triangle becomes four
+	uvs := &texturePoints{obj: o, shared: make(map[meshEdge]int)}
+	faces := make([]Face, 0, 4*len(o.Faces))
+	for _, f := range o.Faces {
+		vi := f.VertexIndices
+		mids := [3]int{
+			edgePoints[newMeshEdge(vi[0], vi[1])],
+			edgePoints[newMeshEdge(vi[1], vi[2])],
+			edgePoints[newMeshEdge(vi[2], vi[0])],
+		}
+		var uv, uvMids [3]int
+		hasUV := len(f.TextureCoordinateIndices) == 3
+		if hasUV {
+			copy(uv[:], f.TextureCoordinateIndices)
+			for j := 0; j < 3; j++ {
+				uvMids[j] = uvs.midpoint(uv[j], uv[(j+1)%3])
+			}
+		}
+		children := [4][3][2]int{
+			{{vi[0], uv[0]}, {mids[0], uvMids[0]}, {mids[2], uvMids[2]}},
+			{{mids[0], uvMids[0]}, {vi[1], uv[1]}, {mids[1], uvMids[1]}},
+			{{mids[2], uvMids[2]}, {mids[1], uvMids[1]}, {vi[2], uv[2]}},
+			{{mids[0], uvMids[0]}, {mids[1], uvMids[1]}, {mids[2], uvMids[2]}},
+		}
+		for _, child := range children {
+			faces = append(faces, f.child(child[:], hasUV))
+		}
+	}
+
+	o.Vertices = vertices
+	o.Faces = faces
+	return splitCreases(creases, edgePoints)
+}
+
+// catmullClarkStep performs one step of Catmull-Clark subdivision and returns
+// the creases of the refined mesh.
+func (o *Obj) catmullClarkStep(creases map[meshEdge]bool) map[meshEdge]bool {
+	edges := o.edgeFaces()
+	creaseEnds := o.vertexCreases(creases)
+
+	// One new vertex in the middle of every face, vertex colours are
+	// interpolated linearly
+	hasColors := o.hasVertexColors()
+	vertices := append([]vec3.T(nil), o.Vertices...)
+	facePoints := make([]int, len(o.Faces))
+	for i, f := range o.Faces {
+		facePoints[i] = len(vertices)
+		vertices = append(vertices, average(o.Vertices, f.VertexIndices))
+		if hasColors {
+			o.VertexColors = append(o.VertexColors, average(o.VertexColors, f.VertexIndices))
+		}
+	}
+
+	// One new vertex on every edge
+	edgePoints := make(map[meshEdge]int, len(edges))
+	vertexFaces := make([][]int, len(o.Vertices))
+	vertexEdges := make([][]meshEdge, len(o.Vertices))
+	for _, e := range o.orderedEdges() {
+		faces := edges[e]
+		point := weightedSum(o.Vertices, []int{e.a, e.b}, []float32{0.5, 0.5})
+		if !creases[e] && len(faces) == 2 {
+			// Average of the two ends and the two face points
+			point = weightedSum(vertices, []int{e.a, e.b, facePoints[faces[0]], facePoints[faces[1]]}, []float32{0.25, 0.25, 0.25, 0.25})
+		}
+		if hasColors {
+			o.VertexColors = append(o.VertexColors, weightedSum(o.VertexColors, []int{e.a, e.b}, []float32{0.5, 0.5}))
+		}
+		edgePoints[e] = len(vertices)
+		vertices = append(vertices, point)
+		vertexEdges[e.a] = append(vertexEdges[e.a], e)
+		vertexEdges[e.b] = append(vertexEdges[e.b], e)
+	}
+	for i, f := range o.Faces {
+		for _, v := range f.VertexIndices {
+			vertexFaces[v] = append(vertexFaces[v], i)
+		}
+	}
+
+	// New positions of the existing vertices
+	for v, p := range o.Vertices {
+		switch ends := creaseEnds[v]; {
+		case len(ends) > 2 || len(vertexEdges[v]) == 0:
+			vertices[v] = p
+		case len(ends) == 2:
+			vertices[v] = weightedSum(o.Vertices, []int{v, ends[0], ends[1]}, []float32{0.75, 0.125, 0.125})
+		default:
+			// (F + 2R + (n-3)P) / n
+			n := float32(len(vertexEdges[v]))
+			var f, r vec3.T
+			for _, face := range vertexFaces[v] {
+				f.Add(&vertices[facePoints[face]])
+			}
+			f.Scale(1 / float32(len(vertexFaces[v])))
+			for _, e := range vertexEdges[v] {
+				mid := weightedSum(o.Vertices, []int{e.a, e.b}, []float32{0.5, 0.5})
+				r.Add(&mid)
+			}
+			r.Scale(2 / n)
+			point := p.Scaled(n - 3)
+			point.Add(&f)
+			point.Add(&r)
+			vertices[v] = point.Scaled(1 / n)
+		}
+	}
+
+	// Every face with n vertices becomes n quads
+	uvs := &texturePoints{obj: o, shared: make(map[meshEdge]int)}
+	var faces []Face
+	for i, f := range o.Faces {
+		vi := f.VertexIndices
+		n := len(vi)
+		hasUV := len(f.TextureCoordinateIndices) == n
+		var uvCenter int
+		if hasUV {
+			uvCenter = uvs.average(f.TextureCoordinateIndices...)
+		}
+		for j := range vi {
+			prev, next := (j+n-1)%n, (j+1)%n
+			var uv, uvNext, uvPrev int
+			if hasUV {
+				uv = f.TextureCoordinateIndices[j]
+				uvNext = uvs.midpoint(uv, f.TextureCoordinateIndices[next])
+				uvPrev = uvs.midpoint(f.TextureCoordinateIndices[prev], uv)
+			}
+			child := [][2]int{
+				{vi[j], uv},
+				{edgePoints[newMeshEdge(vi[j], vi[next])], uvNext},
+				{facePoints[i], uvCenter},
+				{edgePoints[newMeshEdge(vi[prev], vi[j])], uvPrev},
+			}
+			faces = append(faces, f.child(child, hasUV))
+		}
+	}
+
+	o.Vertices = vertices
+	o.Faces = faces
+	return splitCreases(creases, edgePoints)
+}
+
+// child creates a face of the refined mesh from pairs of vertex and texture
+// coordinate indices. Normals are generated for the whole mesh at the end.
+func (f Face) child(corners [][2]int, hasUV bool) Face {
+	c := f
+	c.VertexIndices = make([]int, len(corners))
+	c.TextureCoordinateIndices = nil
+	c.NormalIndices = nil
+	if hasUV {
+		c.TextureCoordinateIndices = make([]int, len(corners))
+	}
+	for i, corner := range corners {
+		c.VertexIndices[i] = corner[0]
+		if hasUV {
+			c.TextureCoordinateIndices[i] = corner[1]
+		}
+	}
+	return c
+}
+
+// splitCreases returns the halves of the crease edges after a new vertex was
+// put on every edge.
+func splitCreases(creases map[meshEdge]bool, edgePoints map[meshEdge]int) map[meshEdge]bool {
+	split := make(map[meshEdge]bool, 2*len(creases))
+	for e := range creases {
+		mid := edgePoints[e]
+		split[newMeshEdge(e.a, mid)] = true
+		split[newMeshEdge(mid, e.b)] = true
+	}
+	return split
+}
+
+func weightedSum(vertices []vec3.T, indices []int, weights []float32) vec3.T {
+	var sum vec3.T
+	for i, idx := range indices {
+		weighted := vertices[idx].Scaled(weights[i])
+		sum.Add(&weighted)
+	}
+	return sum
+}
+
+func average(vertices []vec3.T, indices []int) vec3.T {
+	var sum vec3.T
+	for _, idx := range indices {
+		sum.Add(&vertices[idx])
+	}
+	return sum.Scaled(1 / float32(len(indices)))
+}
+
+// generateCreasedNormals replaces the normals of the mesh with ones that are
+// smooth across every edge except the creases.
+func (o *Obj) generateCreasedNormals(creases map[meshEdge]bool) {
+	// Give every face corner an id and join the corners that share a vertex
+	// across a smooth edge
+	firstCorner := make([]int, len(o.Faces)+1)
+	for i, f := range o.Faces {
+		firstCorner[i+1] = firstCorner[i] + len(f.VertexIndices)
+	}
+	parent := make([]int, firstCorner[len(o.Faces)])
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(c int) int {
+		for parent[c] != c {
+			parent[c] = parent[parent[c]]
+			c = parent[c]
+		}
+		return c
+	}
+	for e, faces := range o.edgeFaces() {
+		if creases[e] || len(faces) != 2 {
+			continue
+		}
+		f, g := faces[0], faces[1]
+		for _, v := range []int{e.a, e.b} {
+			a := find(firstCorner[f] + o.Faces[f].corner(v))
+			b := find(firstCorner[g] + o.Faces[g].corner(v))
+			parent[a] = b
+		}
+	}
+
+	// Average the face normals of every group of corners
+	sums := make(map[int]vec3.T)
+	for i, f := range o.Faces {
+		normal := polygonNormal(o.Vertices, f.VertexIndices)
+		for j := range f.VertexIndices {
+			root := find(firstCorner[i] + j)
+			sum := sums[root]
+			sums[root] = *sum.Add(&normal)
+		}
+	}
+
+	o.Normals = nil
+	normalIndices := make(map[int]int)
+	for i := range o.Faces {
+		f := &o.Faces[i]
+		f.NormalIndices = make([]int, len(f.VertexIndices))
+		for j := range f.VertexIndices {
+			root := find(firstCorner[i] + j)
+			idx, ok := normalIndices[root]
+			if !ok {
+				normal := sums[root]
+				normal.Normalize()
+				idx = len(o.Normals)
+				o.Normals = append(o.Normals, Normal{X: normal[0], Y: normal[1], Z: normal[2]})
+				normalIndices[root] = idx
+			}
+			f.NormalIndices[j] = idx
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+func TestSubdivideFaceCounts(t *testing.T) {
+	tests := []struct {
+		name                string
+		scheme              SubdivisionScheme
+		levels              int
+		vertices, faces     int
+		faceVertices, edges int
+	}{
+		{"Catmull-Clark level 1", SubdivisionCatmullClark, 1, 26, 24, 4, 48},
+		{"Catmull-Clark level 2", SubdivisionCatmullClark, 2, 98, 96, 4, 192},
+		{"Loop level 1", SubdivisionLoop, 1, 26, 48, 3, 72},
+		{"Loop level 2", SubdivisionLoop, 2, 98, 192, 3, 288},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cube := cubeObj()
+			result := cube.Subdivide(SubdivisionOptions{Scheme: test.scheme, Levels: test.levels})
+			if len(result.Vertices) != test.vertices || len(result.Faces) != test.faces {
+				t.Errorf("%d vertices and %d faces, want %d and %d",
+					len(result.Vertices), len(result.Faces), test.vertices, test.faces)
+			}
+			for i, f := range result.Faces {
+				if len(f.VertexIndices) != test.faceVertices {
+					t.Fatalf("face %d has %d vertices, want %d", i, len(f.VertexIndices), test.faceVertices)
+				}
+			}
+			if edges := len(result.edgeFaces()); edges != test.edges {
+				t.Errorf("%d edges, want %d", edges, test.edges)
+			}
+			if len(cube.Faces) != 6 || len(cube.Vertices) != 8 {
+				t.Error("the original mesh was changed")
+			}
+		})
+	}
+}
+
+func TestSubdivideAutoScheme(t *testing.T) {
+	if n := len(cubeObj().Subdivide(SubdivisionOptions{Levels: 1}).Faces); n != 24 {
+		t.Errorf("quads give %d faces, want 24 of Catmull-Clark", n)
+	}
+	triangles := fanObj()
+	if n := len(triangles.Subdivide(SubdivisionOptions{Levels: 1}).Faces); n != 16 {
+		t.Errorf("triangles give %d faces, want 16 of Loop", n)
+	}
+}
+
+func TestCatmullClarkSmoothsCorners(t *testing.T) {
+	result := cubeObj().Subdivide(SubdivisionOptions{Scheme: SubdivisionCatmullClark, Levels: 1})
+	// The corner (1, 1, 1) moves to (F + 2R + (n-3)P) / n with n = 3
+	want := vec3.T{5.0 / 9, 5.0 / 9, 5.0 / 9}
+	found := false
+	for _, v := range result.Vertices {
+		if vecApproxEqual(v, want, 1e-5) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no vertex at %v", want)
+	}
+}
+
+func TestSubdivideKeepsCreases(t *testing.T) {
+	for _, scheme := range []SubdivisionScheme{SubdivisionLoop, SubdivisionCatmullClark} {
+		// All edges of the cube are sharper than the crease angle, so the
+		// result is still the cube
+		result := cubeObj().Subdivide(SubdivisionOptions{Scheme: scheme, Levels: 2, CreaseAngle: 60})
+		for i, v := range result.Vertices {
+			largest := math.Max(math.Abs(float64(v[0])), math.Max(math.Abs(float64(v[1])), math.Abs(float64(v[2]))))
+			if math.Abs(largest-1) > 1e-5 {
+				t.Fatalf("scheme %d: vertex %d at %v is not on the cube", scheme, i, v)
+			}
+		}
+	}
+
+	// Border edges are creases: the border of the square is smoothed along
+	// itself and stays in its plane
+	result := fanObj().Subdivide(SubdivisionOptions{Levels: 1})
+	for _, want := range []vec3.T{{1, 0, 0}, {0.75, 0.75, 0}, {-0.75, 0.75, 0}} {
+		found := false
+		for _, v := range result.Vertices {
+			found = found || vecApproxEqual(v, want, 1e-6)
+		}
+		if !found {
+			t.Errorf("no vertex at %v", want)
+		}
+	}
+	for i, v := range result.Vertices {
+		if v[2] != 0 {
+			t.Errorf("vertex %d at %v left the plane", i, v)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// GenerateTangents calculates the tangents that normal and bump maps need,
+// pointing along increasing U in the plane of the normal. W is 1 or -1 and
+// gives the direction of increasing V, the bitangent, as W * normal x tangent,
+// which handles mirrored texture coordinates. Corners that share their vertex,
+// texture coordinate and normal share their tangent. Faces without texture
+// coordinates or normals get no tangents.
+func (o *Obj) GenerateTangents() {
+	o.Tangents = nil
+
+	type cornerKey struct {
+		vertex, uv, normal int
+	}
+	sums := make(map[cornerKey]*tangentSum)
+	key := func(f Face, j int) cornerKey {
+		return cornerKey{f.VertexIndices[j], f.TextureCoordinateIndices[j], f.NormalIndices[j]}
+	}
+	hasTangents := func(f Face) bool {
+		return len(f.TextureCoordinateIndices) == len(f.VertexIndices) && len(f.NormalIndices) == len(f.VertexIndices)
+	}
+
+	// Sum the tangents of the triangles of the fans around every corner
+	for _, f := range o.Faces {
+		if !hasTangents(f) {
+			continue
+		}
+		for i := 1; i+1 < len(f.VertexIndices); i++ {
+			corners := [3]int{0, i, i + 1}
+			tangent, bitangent, ok := o.triangleTangent(f, corners)
+			if !ok {
+				continue
+			}
+			for _, j := range corners {
+				s, ok := sums[key(f, j)]
+				if !ok {
+					s = &tangentSum{}
+					sums[key(f, j)] = s
+				}
+				s.tangent.Add(&tangent)
+				s.bitangent.Add(&bitangent)
+			}
+		}
+	}
+
+	indices := make(map[cornerKey]int)
+	for i := range o.Faces {
+		f := &o.Faces[i]
+		f.TangentIndices = nil
+		if !hasTangents(*f) {
+			continue
+		}
+		f.TangentIndices = make([]int, len(f.VertexIndices))
+		for j := range f.VertexIndices {
+			k := key(*f, j)
+			idx, ok := indices[k]
+			if !ok {
+				idx = len(o.Tangents)
+				o.Tangents = append(o.Tangents, cornerTangent(o.Normals[k.normal].ToVec3(), sums[k]))
+				indices[k] = idx
+			}
+			f.TangentIndices[j] = idx
+		}
+	}
+}
+
+// tangentSum is the sum of the directions of U and V of the triangles around
+// a corner.
+type tangentSum struct {
+	tangent, bitangent vec3.T
+}
+
+// cornerTangent makes the summed tangent of a corner perpendicular to its
+// normal with Gram-Schmidt.
+func cornerTangent(normal vec3.T, s *tangentSum) vec4.T {
+	var tangent, bitangent vec3.T
+	if s != nil {
+		tangent, bitangent = s.tangent, s.bitangent
+	}
+	along := normal.Scaled(vec3.Dot(&normal, &tangent))
+	tangent.Sub(&along)
+	if tangent.LengthSqr() < 1e-12 {
+		tangent, _ = coordinateSystem(normal.Normalized())
+	}
+	tangent.Normalize()
+
+	w := float32(1)
+	cross := vec3.Cross(&normal, &tangent)
+	if vec3.Dot(&cross, &bitangent) < 0 {
+		w = -1
+	}
+	return vec4.T{tangent[0], tangent[1], tangent[2], w}
+}
+
+// triangleTangent returns the directions in which U and V increase on a
+// triangle of a face.
+func (o *Obj) triangleTangent(f Face, corners [3]int) (vec3.T, vec3.T, bool) {
+	var points [3]vec3.T
+	var uvs [3]TextureCoordinate
+	for j, corner := range corners {
+		points[j] = o.Vertices[f.VertexIndices[corner]]
+		uvs[j] = o.TextureCoordinates[f.TextureCoordinateIndices[corner]]
+	}
+	return triangleDerivatives(points, uvs)
+}
+
+// triangleDerivatives returns the change of values that are interpolated
+// linearly over a triangle with U and V. ok is false if the texture
+// coordinates of the triangle are degenerate.
+func triangleDerivatives(values [3]vec3.T, uvs [3]TextureCoordinate) (vec3.T, vec3.T, bool) {
+	p0, p1, p2 := values[0], values[1], values[2]
+	uv0, uv1, uv2 := uvs[0], uvs[1], uvs[2]
+
+	e1, e2 := vec3.Sub(&p1, &p0), vec3.Sub(&p2, &p0)
+	du1, dv1 := float32(uv1.U-uv0.U), float32(uv1.V-uv0.V)
+	du2, dv2 := float32(uv2.U-uv0.U), float32(uv2.V-uv0.V)
+	det := du1*dv2 - du2*dv1
+	if math.Abs(float64(det)) < 1e-12 {
+		return vec3.T{}, vec3.T{}, false
+	}
+	r := 1 / det
+
+	a, b := e1.Scaled(dv2*r), e2.Scaled(dv1*r)
+	dU := vec3.Sub(&a, &b)
+	a, b = e2.Scaled(du1*r), e1.Scaled(du2*r)
+	dV := vec3.Sub(&a, &b)
+	return dU, dV, true
+}
+
+// perturbNormal applies the normal and bump maps of the material of the
+// intersection to its shading normal. Surfaces without a tangent use an
+// arbitrary one, which is only right for textures that look the same in
+// every direction.
+func (i *RayFaceIntersection) perturbNormal(ctx TextureContext) {
+	m := &i.Material
+	if m.NormalMap == nil && m.BumpMap == nil {
+		return
+	}
+
+	normal := i.Normal.Normalized()
+	tangent, bitangent := i.Tangent, i.Bitangent
+	if tangent.IsZero() || bitangent.IsZero() {
+		tangent, bitangent = coordinateSystem(normal)
+	}
+
+	if m.NormalMap != nil {
+		// Tangent space normal with X along U, Y along V and Z outwards
+		sample := m.NormalMap.Evaluate(ctx)
+		x, y, z := sample[0]*2-1, sample[1]*2-1, sample[2]*2-1
+		t, b, n := tangent.Scaled(x), bitangent.Scaled(y), normal.Scaled(z)
+		perturbed := vec3.Add(&t, &b)
+		perturbed.Add(&n)
+		if perturbed.LengthSqr() > 0 {
+			normal = perturbed.Normalized()
+		}
+	}
+
+	if m.BumpMap != nil {
+		// Slope of the height along U and V by finite differences over half
+		// the footprint of the pixel, if known
+		du := 0.5 * (math.Abs(ctx.DuvDx.U) + math.Abs(ctx.DuvDy.U))
+		dv := 0.5 * (math.Abs(ctx.DuvDx.V) + math.Abs(ctx.DuvDy.V))
+		if du == 0 {
+			du = 1.0 / 1024
+		}
+		if dv == 0 {
+			dv = 1.0 / 1024
+		}
+		height := m.BumpMap.Evaluate(ctx)[0]
+		uCtx, vCtx := ctx, ctx
+		uCtx.UV.U += du
+		vCtx.UV.V += dv
+		dHdU := (m.BumpMap.Evaluate(uCtx)[0] - height) / float32(du)
+		dHdV := (m.BumpMap.Evaluate(vCtx)[0] - height) / float32(dv)
+		t, b := tangent.Scaled(m.BumpScale*dHdU), bitangent.Scaled(m.BumpScale*dHdV)
+		normal.Sub(&t)
+		normal.Sub(&b)
+		normal.Normalize()
+	}
+
+	// The shading normal must not face away from the surface
+	if vec3.Dot(&normal, &i.GeometricNormal) < 0 {
+		along := i.GeometricNormal.Scaled(vec3.Dot(&normal, &i.GeometricNormal) * 1.01)
+		normal.Sub(&along)
+		normal.Normalize()
+	}
+	i.Normal = normal
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// normalSquare is texturedSquare with a normal along Z at every corner. If
+// mirrored is set U runs along -X.
+func normalSquare(mirrored bool) *Obj {
+	o := texturedSquare()
+	if mirrored {
+		for i := range o.TextureCoordinates {
+			o.TextureCoordinates[i].U = 1 - o.TextureCoordinates[i].U
+		}
+	}
+	o.Normals = []Normal{{X: 0, Y: 0, Z: 1}}
+	for i := range o.Faces {
+		o.Faces[i].NormalIndices = make([]int, len(o.Faces[i].VertexIndices))
+	}
+	o.GenerateTangents()
+	return o
+}
+
+func TestGenerateTangents(t *testing.T) {
+	tests := []struct {
+		mirrored  bool
+		tangent   vec4.T
+		bitangent vec3.T
+	}{
+		{false, vec4.T{1, 0, 0, 1}, vec3.T{0, 1, 0}},
+		{true, vec4.T{-1, 0, 0, -1}, vec3.T{0, 1, 0}},
+	}
+	for _, test := range tests {
+		o := normalSquare(test.mirrored)
+		// All corners of the centre vertex share their tangent
+		if len(o.Tangents) != len(o.Vertices) {
+			t.Errorf("mirrored %v: %d tangents for %d vertices", test.mirrored, len(o.Tangents), len(o.Vertices))
+		}
+		for i, tangent := range o.Tangents {
+			if !colorApproxEqual(tangent, test.tangent, 1e-5) {
+				t.Errorf("mirrored %v: tangent %d is %v, want %v", test.mirrored, i, tangent, test.tangent)
+			}
+		}
+
+		// V increases along the bitangent of hits
+		hit, ok := o.Intersect(CreateRay(vec3.T{0.3, 0.6, 1}, vec3.T{0, 0, -1}))
+		if !ok {
+			t.Fatalf("mirrored %v: ray misses", test.mirrored)
+		}
+		if !vecApproxEqual(hit.Tangent, test.tangent.Vec3(), 1e-5) || !vecApproxEqual(hit.Bitangent, test.bitangent, 1e-5) {
+			t.Errorf("mirrored %v: hit has tangent %v and bitangent %v, want %v and %v", test.mirrored, hit.Tangent, hit.Bitangent, test.tangent.Vec3(), test.bitangent)
+		}
+	}
+
+	// Faces without texture coordinates get no tangents
+	o := fanObj()
+	o.GenerateTangents()
+	if len(o.Tangents) != 0 || o.Faces[0].TangentIndices != nil {
+		t.Errorf("mesh without texture coordinates has tangents %v", o.Tangents)
+	}
+}
+
+func TestPerturbNormal(t *testing.T) {
+	diagonal := float32(math.Sqrt(0.5))
+	tests := []struct {
+		name     string
+		material Material
+		mirrored bool
+		want     vec3.T
+	}{
+		{"flat normal map", Material{NormalMap: ConstantTexture{0.5, 0.5, 1, 1}}, false, vec3.T{0, 0, 1}},
+		{"tilted normal map", Material{NormalMap: ConstantTexture{0.75, 0.5, 0.75, 1}}, false, vec3.T{diagonal, 0, diagonal}},
+		{"mirrored normal map", Material{NormalMap: ConstantTexture{0.75, 0.5, 0.75, 1}}, true, vec3.T{-diagonal, 0, diagonal}},
+		{"normal map along V", Material{NormalMap: ConstantTexture{0.5, 0.75, 0.75, 1}}, true, vec3.T{0, diagonal, diagonal}},
+		// The height rises along U, which tilts the normal back
+		{"bump map", Material{BumpMap: GradientTexture{Start: black, End: white, Direction: vec3.T{1, 0, 0}}, BumpScale: 1}, false, vec3.T{-diagonal, 0, diagonal}},
+		{"flat bump map", Material{BumpMap: ConstantTexture{0.5, 0.5, 0.5, 1}, BumpScale: 1}, false, vec3.T{0, 0, 1}},
+		// Normals that would face away from the surface are bent back
+		{"inverted normal map", Material{NormalMap: ConstantTexture{0.5, 0.5, 0, 1}}, false, vec3.T{0, 0, 1}},
+	}
+	for _, test := range tests {
+		o := normalSquare(test.mirrored)
+		o.Material = test.material
+		hit, ok := o.Intersect(CreateRay(vec3.T{0.3, 0.6, 1}, vec3.T{0, 0, -1}))
+		if !ok {
+			t.Fatalf("%s: ray misses", test.name)
+		}
+		hit.applyTextures()
+		if !vecApproxEqual(hit.Normal, test.want, 1e-3) {
+			t.Errorf("%s: normal %v, want %v", test.name, hit.Normal, test.want)
+		}
+	}
+}
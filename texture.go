@@ -0,0 +1,382 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"github.com/ungerik/go3d/vec4"
+)
+
+// WrapMode decides how a texture is continued outside of [0, 1].
+type WrapMode int
+
+const (
+	WrapRepeat WrapMode = iota
+	WrapClamp
+	WrapMirror
+)
+
+// TextureFilter selects how an ImageTexture is filtered over the footprint
+// of a pixel.
+type TextureFilter int
+
+const (
+	// FilterBilinear samples the full resolution image, which aliases where
+	// the texture is smaller than a pixel.
+	FilterBilinear TextureFilter = iota
+	// FilterTrilinear blends the two levels of the mip pyramid closest to
+	// the size of the footprint. It blurs textures seen at an angle.
+	FilterTrilinear
+	// FilterEWA averages the texels under the elliptical footprint with
+	// Gaussian weights, which keeps textures seen at an angle sharp.
+	FilterEWA
+)
+
+// maxAnisotropy limits how elongated the footprint of FilterEWA can be,
+// which bounds the number of texels it reads.
+const maxAnisotropy = 8
+
+// ImageTexture is an image with a mip pyramid of successively halved
+// copies. Colours are stored as red, green, blue and alpha between 0 and 1.
+// V runs from the bottom of the image to the top like in OBJ files.
+type ImageTexture struct {
+	Width  int
+	Height int
+	Pixels []vec4.T
+	Wrap   WrapMode
+	Filter TextureFilter
+
+	// levels holds the mip pyramid, with the full image first, see
+	// GenerateMipmaps
+	levels []mipLevel
+}
+
+type mipLevel struct {
+	width, height int
+	pixels        []vec4.T
+}
+
+// CreateImageTexture creates a texture with trilinear filtering.
+func CreateImageTexture(img image.Image, wrap WrapMode) *ImageTexture {
+	bounds := img.Bounds()
+	t := &ImageTexture{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Pixels: make([]vec4.T, bounds.Dx()*bounds.Dy()),
+		Wrap:   wrap,
+		Filter: FilterTrilinear,
+	}
+	for y := 0; y < t.Height; y++ {
+		for x := 0; x < t.Width; x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+			t.Pixels[y*t.Width+x] = vec4.T{
+				float32(c.R) / 0xffff,
+				float32(c.G) / 0xffff,
+				float32(c.B) / 0xffff,
+				float32(c.A) / 0xffff,
+			}
+		}
+	}
+	t.GenerateMipmaps()
+	return t
+}
+
+// GenerateMipmaps builds the mip pyramid from Pixels down to a single texel.
+// Every level averages two by two texels of the one before, with the wrap
+// mode deciding the neighbours of the last row and column of odd sizes. It
+// has to be called again after Pixels are changed.
+func (t *ImageTexture) GenerateMipmaps() {
+	level := mipLevel{t.Width, t.Height, t.Pixels}
+	t.levels = []mipLevel{level}
+	for level.width > 1 || level.height > 1 {
+		next := mipLevel{width: maxInt(level.width/2, 1), height: maxInt(level.height/2, 1)}
+		next.pixels = make([]vec4.T, next.width*next.height)
+		// Sizes that are not halved, because they are 1, take single texels
+		stepX, stepY := level.width/next.width, level.height/next.height
+		for y := 0; y < next.height; y++ {
+			for x := 0; x < next.width; x++ {
+				var sum vec4.T
+				for dy := 0; dy < stepY; dy++ {
+					for dx := 0; dx < stepX; dx++ {
+						addWeighted(&sum, t.texel(level, x*stepX+dx, y*stepY+dy), 1)
+					}
+				}
+				next.pixels[y*next.width+x] = scaleColor(sum, 1/float32(stepX*stepY))
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+}
+
+// mipmaps returns the mip pyramid, generating it for textures that were
+// created without CreateImageTexture.
+func (t *ImageTexture) mipmaps() []mipLevel {
+	if len(t.levels) == 0 || t.levels[0].width != t.Width || t.levels[0].height != t.Height {
+		t.GenerateMipmaps()
+	}
+	return t.levels
+}
+
+// LoadImageTexture reads a PNG or JPEG file as a texture.
+func LoadImageTexture(filename string, wrap WrapMode) (*ImageTexture, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return CreateImageTexture(img, wrap), nil
+}
+
+// Sample returns the bilinearly filtered colour at a texture coordinate.
+func (t *ImageTexture) Sample(uv TextureCoordinate) vec4.T {
+	if t.Width == 0 || t.Height == 0 {
+		return vec4.T{}
+	}
+	return t.bilinear(mipLevel{t.Width, t.Height, t.Pixels}, uv)
+}
+
+// SampleFiltered returns the colour at a texture coordinate averaged over
+// the footprint of a pixel, given by the change of the texture coordinate
+// from one pixel to the next, with the filter of the texture.
+func (t *ImageTexture) SampleFiltered(uv, duvdx, duvdy TextureCoordinate) vec4.T {
+	if t.Width == 0 || t.Height == 0 {
+		return vec4.T{}
+	}
+	switch t.Filter {
+	case FilterTrilinear:
+		width := math.Max(
+			math.Max(math.Abs(duvdx.U), math.Abs(duvdy.U))*float64(t.Width),
+			math.Max(math.Abs(duvdx.V), math.Abs(duvdy.V))*float64(t.Height))
+		return t.trilinear(uv, width)
+	case FilterEWA:
+		return t.ewa(uv, duvdx, duvdy)
+	default:
+		return t.Sample(uv)
+	}
+}
+
+// trilinear blends the levels for a footprint of width texels of the full
+// image.
+func (t *ImageTexture) trilinear(uv TextureCoordinate, width float64) vec4.T {
+	levels := t.mipmaps()
+	lod := math.Max(0, math.Log2(math.Max(width, 1e-8)))
+	if lod >= float64(len(levels)-1) {
+		return t.bilinear(levels[len(levels)-1], uv)
+	}
+	i := int(lod)
+	a, b := t.bilinear(levels[i], uv), t.bilinear(levels[i+1], uv)
+	return vec4.Interpolate(&a, &b, float32(lod-float64(i)))
+}
+
+// ewa filters with the ellipse whose axes are the two differentials, on the
+// level where its minor axis is about a texel. Ellipses more elongated than
+// maxAnisotropy are widened, trading sharpness for speed.
+func (t *ImageTexture) ewa(uv, duvdx, duvdy TextureCoordinate) vec4.T {
+	// Axes in texels of the full image, with Y down like the pixels
+	axis0 := [2]float64{duvdx.U * float64(t.Width), -duvdx.V * float64(t.Height)}
+	axis1 := [2]float64{duvdy.U * float64(t.Width), -duvdy.V * float64(t.Height)}
+	length0, length1 := math.Hypot(axis0[0], axis0[1]), math.Hypot(axis1[0], axis1[1])
+	if length0 < length1 {
+		axis0, axis1 = axis1, axis0
+		length0, length1 = length1, length0
+	}
+	if length1 == 0 {
+		return t.trilinear(uv, length0)
+	}
+	if length1*maxAnisotropy < length0 {
+		scale := length0 / (length1 * maxAnisotropy)
+		axis1[0] *= scale
+		axis1[1] *= scale
+		length1 *= scale
+	}
+
+	levels := t.mipmaps()
+	lod := math.Max(0, math.Log2(length1))
+	if lod >= float64(len(levels)-1) {
+		return t.bilinear(levels[len(levels)-1], uv)
+	}
+	i := int(lod)
+	a := t.ewaLevel(levels[i], uv, axis0, axis1)
+	b := t.ewaLevel(levels[i+1], uv, axis0, axis1)
+	return vec4.Interpolate(&a, &b, float32(lod-float64(i)))
+}
+
+// ewaWeights is a Gaussian falloff over the squared radius inside the
+// ellipse, going to 0 at its edge.
+var ewaWeights = func() [128]float32 {
+	const alpha = 2
+	var weights [128]float32
+	for i := range weights {
+		r2 := float64(i) / float64(len(weights)-1)
+		weights[i] = float32(math.Exp(-alpha*r2) - math.Exp(-alpha))
+	}
+	return weights
+}()
+
+// ewaLevel averages the texels of a level inside the ellipse, following
+// Heckbert's elliptical weighted average as described in Physically Based
+// Rendering. The axes are in texels of the full image.
+func (t *ImageTexture) ewaLevel(level mipLevel, uv TextureCoordinate, axis0, axis1 [2]float64) vec4.T {
+	s := uv.U*float64(level.width) - 0.5
+	u := (1-uv.V)*float64(level.height) - 0.5
+	scaleX, scaleY := float64(level.width)/float64(t.Width), float64(level.height)/float64(t.Height)
+	x0, y0 := axis0[0]*scaleX, axis0[1]*scaleY
+	x1, y1 := axis1[0]*scaleX, axis1[1]*scaleY
+
+	// Implicit ellipse A x^2 + B x y + C y^2 = 1, widened by a texel so it
+	// covers at least one
+	a := y0*y0 + y1*y1 + 1
+	b := -2 * (x0*y0 + x1*y1)
+	c := x0*x0 + x1*x1 + 1
+	invF := 1 / (a*c - b*b*0.25)
+	a, b, c = a*invF, b*invF, c*invF
+
+	det := -b*b + 4*a*c
+	invDet := 1 / det
+	sSqrt, tSqrt := math.Sqrt(det*c), math.Sqrt(a*det)
+	s0, s1 := int(math.Ceil(s-2*invDet*sSqrt)), int(math.Floor(s+2*invDet*sSqrt))
+	t0, t1 := int(math.Ceil(u-2*invDet*tSqrt)), int(math.Floor(u+2*invDet*tSqrt))
+
+	var sum vec4.T
+	var weights float32
+	for y := t0; y <= t1; y++ {
+		dy := float64(y) - u
+		for x := s0; x <= s1; x++ {
+			dx := float64(x) - s
+			r2 := a*dx*dx + b*dx*dy + c*dy*dy
+			if r2 >= 1 {
+				continue
+			}
+			weight := ewaWeights[minInt(int(r2*float64(len(ewaWeights))), len(ewaWeights)-1)]
+			addWeighted(&sum, t.texel(level, x, y), weight)
+			weights += weight
+		}
+	}
+	if weights == 0 {
+		return t.bilinear(level, uv)
+	}
+	return scaleColor(sum, 1/weights)
+}
+
+// addWeighted adds weight times c to sum. Unlike the methods of vec4, which
+// treat W as homogeneous, it includes alpha.
+func addWeighted(sum, c *vec4.T, weight float32) {
+	for i := range sum {
+		sum[i] += c[i] * weight
+	}
+}
+
+func scaleColor(c vec4.T, f float32) vec4.T {
+	return vec4.T{c[0] * f, c[1] * f, c[2] * f, c[3] * f}
+}
+
+func (t *ImageTexture) bilinear(level mipLevel, uv TextureCoordinate) vec4.T {
+	x := uv.U*float64(level.width) - 0.5
+	y := (1-uv.V)*float64(level.height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := float32(x-x0), float32(y-y0)
+	ix, iy := int(x0), int(y0)
+
+	top := vec4.Interpolate(t.texel(level, ix, iy), t.texel(level, ix+1, iy), fx)
+	bottom := vec4.Interpolate(t.texel(level, ix, iy+1), t.texel(level, ix+1, iy+1), fx)
+	return vec4.Interpolate(&top, &bottom, fy)
+}
+
+func (t *ImageTexture) texel(level mipLevel, x, y int) *vec4.T {
+	x = wrapIndex(x, level.width, t.Wrap)
+	y = wrapIndex(y, level.height, t.Wrap)
+	return &level.pixels[y*level.width+x]
+}
+
+func wrapIndex(i, n int, wrap WrapMode) int {
+	switch wrap {
+	case WrapClamp:
+		return minInt(maxInt(i, 0), n-1)
+	case WrapMirror:
+		period := 2 * n
+		i = ((i % period) + period) % period
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	default:
+		return ((i % n) + n) % n
+	}
+}
+
+// applyTextures replaces the parameters of the material of the intersection
+// with its textures evaluated at the intersection. Colour textures are
+// multiplied with the colour of the material, or used as it if the colour is
+// unset, scalar textures replace the value with their red channel, and the
+// shininess texture scales it. Normal and bump maps replace the shading
+// normal. A shader graph is applied before all of them.
+func (i *RayFaceIntersection) applyTextures() {
+	m := &i.Material
+	viewDirection := i.Ray.Direction.Normalized()
+	viewDirection.Invert()
+	ctx := TextureContext{
+		UV:            i.TextureCoordinate,
+		Position:      i.ObjectPoint,
+		DuvDx:         i.DuvDx,
+		DuvDy:         i.DuvDy,
+		Normal:        i.Normal,
+		ViewDirection: viewDirection,
+	}
+	if m.Shader != nil {
+		m.Shader.apply(m, ctx)
+	}
+	for _, c := range []struct {
+		texture Texture
+		color   *color.RGBA
+	}{
+		{m.ColorTexture, &m.Color},
+		{m.SpecularTexture, &m.Specular},
+		{m.EmissionTexture, &m.Emission},
+	} {
+		if c.texture == nil {
+			continue
+		}
+		// An unset colour would turn the texture black
+		if *c.color == (color.RGBA{}) {
+			*c.color = color.RGBA{255, 255, 255, 255}
+		}
+		*c.color = modulateColor(*c.color, c.texture.Evaluate(ctx))
+	}
+	for _, s := range []struct {
+		texture Texture
+		value   *float32
+	}{
+		{m.ReflectivityTexture, &m.Reflectivity},
+		{m.OpacityTexture, &m.Opacity},
+		{m.DiffuseTexture, &m.Diffuse},
+		{m.RoughnessTexture, &m.Roughness},
+		{m.MetallicTexture, &m.Metallic},
+	} {
+		if s.texture != nil {
+			*s.value = s.texture.Evaluate(ctx)[0]
+		}
+	}
+	if m.ShininessTexture != nil {
+		m.Shininess *= m.ShininessTexture.Evaluate(ctx)[0]
+	}
+	i.perturbNormal(ctx)
+}
+
+// modulateColor multiplies a colour with a texture sample.
+func modulateColor(c color.RGBA, sample vec4.T) color.RGBA {
+	return color.RGBA{
+		R: uint8(clampColorComponent(float32(c.R) * sample[0])),
+		G: uint8(clampColorComponent(float32(c.G) * sample[1])),
+		B: uint8(clampColorComponent(float32(c.B) * sample[2])),
+		A: uint8(clampColorComponent(float32(c.A) * sample[3])),
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
+)
+
+// quadrantTexture is two by two texels, red and green in the top row and blue
+// and white in the bottom one.
+func quadrantTexture(wrap WrapMode) *ImageTexture {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{0, 255, 0, 255})
+	img.Set(0, 1, color.RGBA{0, 0, 255, 255})
+	img.Set(1, 1, color.RGBA{255, 255, 255, 255})
+	return CreateImageTexture(img, wrap)
+}
+
+func colorApproxEqual(a, b vec4.T, epsilon float32) bool {
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > float64(epsilon) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	red   = vec4.T{1, 0, 0, 1}
+	green = vec4.T{0, 1, 0, 1}
+	blue  = vec4.T{0, 0, 1, 1}
+	white = vec4.T{1, 1, 1, 1}
+)
+
+func TestImageTextureSample(t *testing.T) {
+	texture := quadrantTexture(WrapClamp)
+	tests := []struct {
+		uv   TextureCoordinate
+		want vec4.T
+	}{
+		// V runs from the bottom of the image to the top
+		{TextureCoordinate{U: 0.25, V: 0.75}, red},
+		{TextureCoordinate{U: 0.75, V: 0.75}, green},
+		{TextureCoordinate{U: 0.25, V: 0.25}, blue},
+		{TextureCoordinate{U: 0.75, V: 0.25}, white},
+		// Bilinear filtering between texel centres
+		{TextureCoordinate{U: 0.5, V: 0.75}, vec4.T{0.5, 0.5, 0, 1}},
+		{TextureCoordinate{U: 0.5, V: 0.5}, vec4.T{0.5, 0.5, 0.5, 1}},
+	}
+	for _, test := range tests {
+		if got := texture.Sample(test.uv); !colorApproxEqual(got, test.want, 1e-5) {
+			t.Errorf("%v: got %v, want %v", test.uv, got, test.want)
+		}
+	}
+}
+
+func TestImageTextureWrap(t *testing.T) {
+	tests := []struct {
+		wrap WrapMode
+		u    float64
+		want vec4.T
+	}{
+		{WrapRepeat, 1.25, red},
+		{WrapRepeat, 1.75, green},
+		{WrapRepeat, -0.25, green},
+		{WrapClamp, 1.25, green},
+		{WrapClamp, 1.75, green},
+		{WrapClamp, -0.25, red},
+		{WrapMirror, 1.25, green},
+		{WrapMirror, 1.75, red},
+		{WrapMirror, -0.25, red},
+	}
+	for _, test := range tests {
+		texture := quadrantTexture(test.wrap)
+		uv := TextureCoordinate{U: test.u, V: 0.75}
+		if got := texture.Sample(uv); !colorApproxEqual(got, test.want, 1e-5) {
+			t.Errorf("wrap %d, U %v: got %v, want %v", test.wrap, test.u, got, test.want)
+		}
+	}
+}
+
+func TestApplyColorTexture(t *testing.T) {
+	texture := ConstantTexture{0.5, 0.25, 1, 1}
+	tests := []struct {
+		color, want color.RGBA
+	}{
+		// An unset colour shows the texture as it is
+		{color.RGBA{}, color.RGBA{127, 63, 255, 255}},
+		{color.RGBA{255, 0, 0, 255}, color.RGBA{127, 0, 0, 255}},
+		{color.RGBA{0, 0, 0, 255}, color.RGBA{0, 0, 0, 255}},
+	}
+	for _, test := range tests {
+		i := RayFaceIntersection{
+			Ray:      CreateRay(vec3.T{0, 0, 1}, vec3.T{0, 0, -1}),
+			Normal:   vec3.T{0, 0, 1},
+			Material: Material{Color: test.color, ColorTexture: texture},
+		}
+		i.applyTextures()
+		if i.Material.Color != test.want {
+			t.Errorf("colour %v: got %v, want %v", test.color, i.Material.Color, test.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// UVProjection generates texture coordinates from positions. Positions are
+// first transformed into projection space, where the projections are laid
+// out on the unit cube from (0, 0, 0) to (1, 1, 1), see FitUVTransform.
+type UVProjection int
+
+const (
+	// UVPlanar projects along Z, U and V are X and Y.
+	UVPlanar UVProjection = iota
+	// UVBox projects every face along the axis its normal is closest to, so
+	// each side of the unit cube gets the full texture. Also known as
+	// triplanar mapping.
+	UVBox
+	// UVCylindrical wraps U around the Y axis through the center of the unit
+	// cube. V is Y.
+	UVCylindrical
+	// UVSpherical wraps U around the Y axis like UVCylindrical and runs V from
+	// the bottom pole to the top one, around the center of the unit cube.
+	UVSpherical
+)
+
+// FitUVTransform returns the transform that maps box onto the unit cube,
+// which makes a projection cover the whole box once.
+func FitUVTransform(box vec3.Box) mat4.T {
+	transform := mat4.Ident
+	size := box.Diagonal()
+	scale := vec3.T{1, 1, 1}
+	for axis := 0; axis < 3; axis++ {
+		if size[axis] > 0 {
+			scale[axis] = 1 / size[axis]
+		}
+	}
+	transform.ScaleVec3(&scale)
+	offset := vec3.Mul(&box.Min, &scale)
+	offset.Invert()
+	transform.SetTranslation(&offset)
+	return transform
+}
+
+// ProjectUVs replaces the texture coordinates of the mesh with ones generated
+// by projection after applying transform to the vertices. Every face gets
+// texture coordinate indices. Faces that cross the seam of the cylindrical
+// and spherical projections get their own coordinates so that the texture
+// does not run backwards across them. Tangents are generated again for the
+// new coordinates.
+func (o *Obj) ProjectUVs(projection UVProjection, transform mat4.T) {
+	points := make([]vec3.T, len(o.Vertices))
+	for i, v := range o.Vertices {
+		points[i] = transform.MulVec3(&v)
+	}
+
+	// Normals are transformed with the inverse transpose
+	inverse := transform.Inverted()
+	transformNormal := func(n vec3.T) vec3.T {
+		return vec3.T{
+			inverse[0][0]*n[0] + inverse[0][1]*n[1] + inverse[0][2]*n[2],
+			inverse[1][0]*n[0] + inverse[1][1]*n[1] + inverse[1][2]*n[2],
+			inverse[2][0]*n[0] + inverse[2][1]*n[1] + inverse[2][2]*n[2],
+		}
+	}
+
+	// Corners of the same vertex with the same coordinates share them
+	type cornerUV struct {
+		vertex int
+		uv     TextureCoordinate
+	}
+	indices := make(map[cornerUV]int)
+	o.TextureCoordinates = nil
+
+	for i := range o.Faces {
+		f := &o.Faces[i]
+		uvs := make([]TextureCoordinate, len(f.VertexIndices))
+		switch projection {
+		case UVPlanar:
+			for j, v := range f.VertexIndices {
+				uvs[j] = TextureCoordinate{U: float64(points[v][0]), V: float64(points[v][1])}
+			}
+		case UVBox:
+			normal := transformNormal(polygonNormal(o.Vertices, f.VertexIndices))
+			axis := 0
+			for a := 1; a < 3; a++ {
+				if math.Abs(float64(normal[a])) > math.Abs(float64(normal[axis])) {
+					axis = a
+				}
+			}
+			for j, v := range f.VertexIndices {
+				uvs[j] = boxUV(points[v], axis, normal[axis] >= 0)
+			}
+		case UVCylindrical, UVSpherical:
+			poles := make([]bool, len(f.VertexIndices))
+			for j, v := range f.VertexIndices {
+				p := points[v]
+				x, y, z := float64(p[0])-0.5, float64(p[1]), float64(p[2])-0.5
+				poles[j] = x*x+z*z < 1e-12
+				uvs[j].U = math.Atan2(x, z)/(2*math.Pi) + 0.5
+				uvs[j].V = y
+				if projection == UVSpherical {
+					y -= 0.5
+					uvs[j].V = math.Atan2(y, math.Sqrt(x*x+z*z))/math.Pi + 0.5
+				}
+			}
+			unwrapSeam(uvs, poles)
+		}
+
+		f.TextureCoordinateIndices = make([]int, len(f.VertexIndices))
+		for j, v := range f.VertexIndices {
+			key := cornerUV{v, uvs[j]}
+			idx, ok := indices[key]
+			if !ok {
+				idx = len(o.TextureCoordinates)
+				o.TextureCoordinates = append(o.TextureCoordinates, uvs[j])
+				indices[key] = idx
+			}
+			f.TextureCoordinateIndices[j] = idx
+		}
+	}
+	o.GenerateTangents()
+}
+
+// boxUV projects p onto the side of the unit cube along axis, oriented so
+// that the texture is not mirrored when the side is seen from outside.
+func boxUV(p vec3.T, axis int, positive bool) TextureCoordinate {
+	x, y, z := float64(p[0]), float64(p[1]), float64(p[2])
+	switch axis {
+	case 0:
+		if positive {
+			return TextureCoordinate{U: 1 - z, V: y}
+		}
+		return TextureCoordinate{U: z, V: y}
+	case 1:
+		if positive {
+			return TextureCoordinate{U: x, V: 1 - z}
+		}
+		return TextureCoordinate{U: x, V: z}
+	default:
+		if positive {
+			return TextureCoordinate{U: x, V: y}
+		}
+		return TextureCoordinate{U: 1 - x, V: y}
+	}
+}
+
+// unwrapSeam moves the U of corners of a face across the seam at U = 0 and 1
+// so that the face does not span the whole texture backwards. Corners on the
+// axis, where U is undefined, get the mean U of the others.
+func unwrapSeam(uvs []TextureCoordinate, poles []bool) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for j, uv := range uvs {
+		if !poles[j] {
+			min = math.Min(min, uv.U)
+			max = math.Max(max, uv.U)
+		}
+	}
+	if math.IsInf(min, 1) {
+		return
+	}
+	var sum float64
+	var count int
+	for j := range uvs {
+		if poles[j] {
+			continue
+		}
+		if max-min > 0.5 && uvs[j].U < 0.5 {
+			uvs[j].U++
+		}
+		sum += uvs[j].U
+		count++
+	}
+	for j := range uvs {
+		if poles[j] {
+			uvs[j].U = sum / float64(count)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ungerik/go3d/vec3"
+)
+
+// faceUVs returns the texture coordinates of the corners of face f.
+func faceUVs(o *Obj, f Face) []TextureCoordinate {
+	uvs := make([]TextureCoordinate, len(f.TextureCoordinateIndices))
+	for j, idx := range f.TextureCoordinateIndices {
+		uvs[j] = o.TextureCoordinates[idx]
+	}
+	return uvs
+}
+
+func TestFitUVTransform(t *testing.T) {
+	box := vec3.Box{Min: vec3.T{-1, -2, 0}, Max: vec3.T{3, 2, 5}}
+	transform := FitUVTransform(box)
+	tests := []struct {
+		p, want vec3.T
+	}{
+		{box.Min, vec3.T{0, 0, 0}},
+		{box.Max, vec3.T{1, 1, 1}},
+		{vec3.T{1, 0, 2.5}, vec3.T{0.5, 0.5, 0.5}},
+	}
+	for _, test := range tests {
+		if got := transform.MulVec3(&test.p); !vecApproxEqual(got, test.want, 1e-6) {
+			t.Errorf("%v is mapped to %v, want %v", test.p, got, test.want)
+		}
+	}
+
+	// Flat boxes are not scaled along their flat axis
+	flat := FitUVTransform(vec3.Box{Min: vec3.T{0, 0, 1}, Max: vec3.T{2, 2, 1}})
+	if got := flat.MulVec3(&vec3.T{1, 1, 1}); !vecApproxEqual(got, vec3.T{0.5, 0.5, 0}, 1e-6) {
+		t.Errorf("centre of a flat box is mapped to %v, want %v", got, vec3.T{0.5, 0.5, 0})
+	}
+}
+
+func TestProjectUVsPlanar(t *testing.T) {
+	o := fanObj()
+	o.ProjectUVs(UVPlanar, FitUVTransform(boundsOf(o.Vertices)))
+	for _, f := range o.Faces {
+		for j, uv := range faceUVs(o, f) {
+			v := o.Vertices[f.VertexIndices[j]]
+			want := TextureCoordinate{U: float64(v[0]+1) / 2, V: float64(v[1]+1) / 2}
+			if math.Abs(uv.U-want.U) > 1e-6 || math.Abs(uv.V-want.V) > 1e-6 {
+				t.Errorf("vertex %v has %v, want %v", v, uv, want)
+			}
+		}
+	}
+	// The shared vertices share their coordinates
+	if len(o.TextureCoordinates) != len(o.Vertices) {
+		t.Errorf("%d texture coordinates for %d vertices", len(o.TextureCoordinates), len(o.Vertices))
+	}
+}
+
+func TestProjectUVsBox(t *testing.T) {
+	o := cubeObj()
+	o.ProjectUVs(UVBox, FitUVTransform(boundsOf(o.Vertices)))
+	for i, f := range o.Faces {
+		uvs := faceUVs(o, f)
+		// Every side gets the whole texture
+		corners := map[TextureCoordinate]bool{}
+		for _, uv := range uvs {
+			corners[uv] = true
+		}
+		for _, want := range []TextureCoordinate{{U: 0, V: 0}, {U: 1, V: 0}, {U: 0, V: 1}, {U: 1, V: 1}} {
+			if !corners[want] {
+				t.Errorf("face %d: coordinates %v do not include %v", i, uvs, want)
+			}
+		}
+		// Seen from outside the texture is not mirrored, so the coordinates
+		// wind the same way as the vertices
+		var area float64
+		for j := range uvs {
+			a, b := uvs[j], uvs[(j+1)%len(uvs)]
+			area += a.U*b.V - b.U*a.V
+		}
+		if area <= 0 {
+			t.Errorf("face %d: texture is mirrored, coordinates %v", i, uvs)
+		}
+	}
+}
+
+func TestProjectUVsAround(t *testing.T) {
+	for _, projection := range []UVProjection{UVCylindrical, UVSpherical} {
+		o := cubeObj().Subdivide(SubdivisionOptions{Levels: 2})
+		o.ProjectUVs(projection, FitUVTransform(boundsOf(o.Vertices)))
+
+		top := 0
+		for i, v := range o.Vertices {
+			if v[1] > o.Vertices[top][1] {
+				top = i
+			}
+		}
+		seam := false
+		for i, f := range o.Faces {
+			uvs := faceUVs(o, f)
+			min, max := uvs[0].U, uvs[0].U
+			for j, uv := range uvs {
+				min = math.Min(min, uv.U)
+				max = math.Max(max, uv.U)
+				if uv.U < 0 || uv.U > 1.5 || uv.V < -1e-6 || uv.V > 1+1e-6 {
+					t.Errorf("projection %d, face %d: coordinates %v out of range", projection, i, uv)
+				}
+				if projection == UVSpherical && f.VertexIndices[j] == top && math.Abs(uv.V-1) > 1e-6 {
+					t.Errorf("projection %d: top pole at V %v, want 1", projection, uv.V)
+				}
+			}
+			// Faces across the seam continue past U = 1 instead of running
+			// backwards over the texture
+			if max-min > 0.5 {
+				t.Errorf("projection %d, face %d: coordinates %v span the texture", projection, i, uvs)
+			}
+			if max > 1 {
+				seam = true
+			}
+		}
+		if !seam {
+			t.Errorf("projection %d: no face crosses the seam", projection)
+		}
+	}
+}